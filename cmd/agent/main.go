@@ -12,6 +12,9 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	a := agent.New(cfg)
+	a, err := agent.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 	a.Run()
 }
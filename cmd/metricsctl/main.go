@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/configs"
+	"github.com/volchkovski/go-practicum-metrics/internal/ctl"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), ctl.Usage)
+		flag.PrintDefaults()
+	}
+	cfg, err := configs.NewCtlConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err = ctl.Run(ctx, cfg, flag.Args(), os.Stdout); err != nil {
+		stop()
+		log.Fatal(err)
+	}
+}
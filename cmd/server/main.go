@@ -1,7 +1,9 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
 
 	"github.com/volchkovski/go-practicum-metrics/internal/configs"
 	"github.com/volchkovski/go-practicum-metrics/internal/server"
@@ -12,5 +14,15 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Fatal(server.Run(cfg))
+	switch args := flag.Args(); {
+	case len(args) == 0:
+		err = server.Run(cfg)
+	case args[0] == "migrate":
+		err = server.Migrate(cfg, args[1:], os.Stdout)
+	default:
+		log.Fatalf("unknown command %q, the only one is migrate", args[0])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 }
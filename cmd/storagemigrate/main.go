@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/configs"
+	"github.com/volchkovski/go-practicum-metrics/internal/storagemigrate"
+)
+
+func main() {
+	cfg, err := configs.NewStorageMigrateConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err = storagemigrate.Run(ctx, cfg, os.Stdout); err != nil {
+		stop()
+		log.Fatal(err)
+	}
+}
@@ -4,74 +4,214 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"reflect"
 	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 
 	"github.com/volchkovski/go-practicum-metrics/internal/configs"
 	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/tlsutil"
 )
 
 type Agent struct {
 	memStats   *runtime.MemStats
 	repIntr    time.Duration
 	pollIntr   time.Duration
-	serverAddr string
+	jitter     time.Duration
+	backoffMax time.Duration
+	failures   int
+	retryAfter time.Duration
+	scheme     string
 	pollCount  int64
 	client     *resty.Client
+	certs      *tlsutil.Reloader
+	custom     *customMetrics
+	local      net.Listener
+	prefixes   map[string]string
+	collectors map[string]bool
+	relabel    relabeler
+	compress   bool
+	dryRun     bool
+	deliverer  deliverer
+	probe      func()
 }
 
-func New(cfg *configs.AgentConfig) *Agent {
-	return &Agent{
+// IdempotencyKeyHeader carries the key of a batch, the same on every resend.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+func New(cfg *configs.AgentConfig) (*Agent, error) {
+	client := NewRestyClient(cfg.BackoffMax.Duration())
+	if hostname, err := os.Hostname(); err == nil {
+		client.SetHeader("X-Agent-ID", hostname)
+	}
+	if cfg.Token != "" {
+		client.SetAuthToken(cfg.Token)
+	}
+	client.SetTimeout(cfg.HTTP.Timeout.Duration()).SetRetryCount(cfg.HTTP.Retries)
+	compress := cfg.HTTP.Compression == "gzip"
+	if !compress {
+		client.Header.Del("Content-Encoding")
+	}
+
+	relabel, err := newRelabeler(cfg.Allow, cfg.Deny, cfg.Relabel)
+	if err != nil {
+		return nil, fmt.Errorf("agent relabel rules setup failed: %w", err)
+	}
+
+	collectors := make(map[string]bool, len(cfg.Collectors))
+	for _, c := range cfg.Collectors {
+		collectors[c] = true
+	}
+
+	a := &Agent{
 		memStats:   &runtime.MemStats{},
-		repIntr:    time.Duration(cfg.ReportIntr) * time.Second,
-		pollIntr:   time.Duration(cfg.PollIntr) * time.Second,
-		serverAddr: cfg.ServerAddr,
+		repIntr:    cfg.ReportIntr.Duration(),
+		pollIntr:   cfg.PollIntr.Duration(),
+		jitter:     cfg.Jitter.Duration(),
+		backoffMax: cfg.BackoffMax.Duration(),
+		scheme:     "http",
 		pollCount:  0,
-		client:     NewRestyClient(),
+		client:     client,
+		custom:     newCustomMetrics(),
+		prefixes:   cfg.Prefixes,
+		collectors: collectors,
+		relabel:    relabel,
+		compress:   compress,
+		dryRun:     cfg.DryRun,
+	}
+
+	if cfg.TLSCA != "" || cfg.TLSCert != "" || cfg.TLSKey != "" {
+		certs, err := tlsutil.NewReloader(cfg.TLSCert, cfg.TLSKey, cfg.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("agent TLS setup failed: %w", err)
+		}
+		client.SetTLSClientConfig(certs.ClientConfig())
+		a.certs = certs
+		a.scheme = "https"
+	}
+
+	switch cfg.Mode {
+	case ModeFanout:
+		a.deliverer = newFanout(cfg.Servers, cfg.SpoolSize, a.post)
+	default:
+		f := newFailover(cfg.Servers, cfg.SpoolSize, a.post, a.ping)
+		a.deliverer = f
+		if len(cfg.Servers) > 1 {
+			a.probe = func() { f.probe(cfg.ProbeIntr.Duration(), nil) }
+		}
 	}
+
+	if cfg.Listen != "" {
+		l, err := listenLocal(cfg.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("agent local listener setup failed: %w", err)
+		}
+		a.local = l
+	}
+	return a, nil
 }
 
 func (a *Agent) Run() {
-	go func() {
-		for {
-			runtime.ReadMemStats(a.memStats)
-			time.Sleep(a.pollIntr)
-		}
-	}()
+	if a.certs != nil {
+		go a.reloadCertsOnHangup()
+	}
+	if a.probe != nil {
+		go a.probe()
+	}
+	if a.local != nil {
+		go func() {
+			err := http.Serve(a.local, localHandler(a.custom, a.prefixes))
+			log.Printf("Local metrics listener stopped: %s", err.Error())
+		}()
+	}
+	if a.collectors["runtime"] {
+		go func() {
+			for {
+				runtime.ReadMemStats(a.memStats)
+				time.Sleep(a.pollIntr)
+			}
+		}()
+	}
+	// Jitter spreads agents started together, e.g. after a deploy, over the interval.
+	time.Sleep(jitter(a.repIntr))
 	for {
-		time.Sleep(a.repIntr)
+		time.Sleep(reportDelay(a.repIntr, a.backoffMax, a.failures, a.retryAfter) + jitter(a.jitter))
 		a.collectMetrics()
 	}
 }
 
 func (a *Agent) collectMetrics() {
 	metrics := make([]*m.Metrics, 0, 50)
-	for _, metricName := range runtimeMetricNames {
-		v, ok := gaugeVal(a.memStats, metricName)
-		if !ok {
-			log.Printf("Failed to get gauge value for %s", metricName)
-			continue
+	if a.collectors["runtime"] {
+		for _, metricName := range runtimeMetricNames {
+			v, ok := gaugeVal(a.memStats, metricName)
+			if !ok {
+				log.Printf("Failed to get gauge value for %s", metricName)
+				continue
+			}
+			metric := &m.Metrics{ID: metricName, MType: "gauge", Value: &v}
+			metrics = append(metrics, metric)
 		}
-		metric := &m.Metrics{ID: metricName, MType: "gauge", Value: &v}
+	}
+	if a.collectors["random"] {
+		rv := getRandomFloat()
+		metric := &m.Metrics{ID: "RandomValue", MType: "gauge", Value: &rv}
+		metrics = append(metrics, metric)
+	}
+	if a.collectors["pollcount"] {
+		a.pollCount += 1
+		metric := &m.Metrics{ID: "PollCount", MType: "counter", Delta: &a.pollCount}
 		metrics = append(metrics, metric)
 	}
-	rv := getRandomFloat()
-	metric := &m.Metrics{ID: "RandomValue", MType: "gauge", Value: &rv}
-	metrics = append(metrics, metric)
 
-	a.pollCount += 1
-	metric = &m.Metrics{ID: "PollCount", MType: "counter", Delta: &a.pollCount}
-	metrics = append(metrics, metric)
+	custom := a.custom.drain()
+	metrics = append(metrics, custom...)
+	metrics = a.relabel.apply(metrics)
+	if len(metrics) == 0 {
+		return
+	}
+	if a.dryRun {
+		a.printMetrics(metrics)
+		return
+	}
 
-	if err := a.postMetrics(metrics); err != nil {
+	batch, err := json.Marshal(metrics)
+	if err != nil {
+		log.Printf("Failed to encode metrics: %s", err.Error())
+		return
+	}
+	a.retryAfter = 0
+	if err = a.deliverer.deliver(batch); err != nil {
+		a.failures++
+		var ra *retryAfterError
+		if errors.As(err, &ra) {
+			a.retryAfter = ra.after
+		}
 		log.Printf("Failed to post metrics: %s", err.Error())
+		return
+	}
+	a.failures = 0
+}
+
+// printMetrics writes the batch that would be sent to stdout, one metric per line.
+func (a *Agent) printMetrics(metrics []*m.Metrics) {
+	enc := json.NewEncoder(os.Stdout)
+	for _, metric := range metrics {
+		if err := enc.Encode(metric); err != nil {
+			log.Printf("Failed to print metric: %s", err.Error())
+			return
+		}
 	}
 }
 
@@ -90,29 +230,36 @@ func gaugeVal(stat *runtime.MemStats, fname string) (float64, bool) {
 	return float64(0), false
 }
 
-func (a *Agent) postMetrics(metrics []*m.Metrics) error {
-	url := "http://" + a.serverAddr + "/updates/"
-
-	p, err := json.Marshal(metrics)
-	if err != nil {
-		return err
-	}
+// post sends an encoded batch to the server at addr.
+func (a *Agent) post(addr string, b batch) error {
+	url := a.scheme + "://" + addr + "/updates/"
 
 	var buff bytes.Buffer
+	if !a.compress {
+		buff.Write(b.body)
+		return a.send(url, b.key, &buff)
+	}
 
 	cw, err := gzip.NewWriterLevel(&buff, gzip.BestSpeed)
 	if err != nil {
 		return err
 	}
-	if _, err = cw.Write(p); err != nil {
+	if _, err = cw.Write(b.body); err != nil {
 		return err
 	}
 
 	if err = cw.Close(); err != nil {
 		return err
 	}
+	return a.send(url, b.key, &buff)
+}
 
-	res, err := a.client.R().SetBody(&buff).Post(url)
+func (a *Agent) send(url, key string, body *bytes.Buffer) error {
+	req := a.client.R().SetBody(body)
+	if key != "" {
+		req.SetHeader(IdempotencyKeyHeader, key)
+	}
+	res, err := req.Post(url)
 	if err != nil {
 		return err
 	}
@@ -120,8 +267,32 @@ func (a *Agent) postMetrics(metrics []*m.Metrics) error {
 	if statusCode == http.StatusOK {
 		return nil
 	}
-	body := res.Body()
-	return fmt.Errorf("got bad response status: %d body: %s", statusCode, string(body))
+	if d, ok := serverRetryAfter(res); ok {
+		return &retryAfterError{status: statusCode, after: d}
+	}
+	respBody := res.Body()
+	return fmt.Errorf("got bad response status: %d body: %s", statusCode, string(respBody))
+}
+
+func (a *Agent) ping(addr string) error {
+	res, err := a.client.GetClient().Get(a.scheme + "://" + addr + "/ping")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return pingStatus(res)
+}
+
+func (a *Agent) reloadCertsOnHangup() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	for range hangup {
+		if err := a.certs.Reload(); err != nil {
+			log.Printf("Failed to reload certificates: %s", err.Error())
+			continue
+		}
+		log.Println("Certificates reloaded")
+	}
 }
 
 func getRandomFloat() float64 {
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxBackoffShift bounds the exponent so that doubling the interval cannot overflow.
+const maxBackoffShift = 16
+
+// retryAfterError is returned when the server asked to come back later with Retry-After.
+type retryAfterError struct {
+	status int
+	after  time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("got status %d, retry after %s", e.status, e.after)
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(h string, now time.Time) (time.Duration, bool) {
+	if h == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(h)
+	if err != nil {
+		return 0, false
+	}
+	return max(t.Sub(now), 0), true
+}
+
+// jitter returns a random duration in [0, limit).
+func jitter(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// reportDelay is the wait before the next report: the report interval, doubled for every
+// failed report in a row up to limit, and no shorter than the server asked for.
+func reportDelay(interval, limit time.Duration, failures int, retryAfter time.Duration) time.Duration {
+	d := interval
+	if failures > 0 {
+		d = interval << min(failures, maxBackoffShift)
+		if limit > 0 && d > limit {
+			d = max(limit, interval)
+		}
+	}
+	return max(d, retryAfter)
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{header: "", ok: false},
+		{header: "7", want: 7 * time.Second, ok: true},
+		{header: "-1", ok: false},
+		{header: "Mon, 01 Jan 2024 12:00:30 GMT", want: 30 * time.Second, ok: true},
+		{header: "Mon, 01 Jan 2024 11:00:00 GMT", want: 0, ok: true},
+		{header: "soon", ok: false},
+	}
+	for _, tc := range tests {
+		got, ok := parseRetryAfter(tc.header, now)
+		assert.Equal(t, tc.ok, ok, tc.header)
+		assert.Equal(t, tc.want, got, tc.header)
+	}
+}
+
+func TestReportDelay(t *testing.T) {
+	interval, limit := 10*time.Second, time.Minute
+	assert.Equal(t, interval, reportDelay(interval, limit, 0, 0))
+	assert.Equal(t, 20*time.Second, reportDelay(interval, limit, 1, 0))
+	assert.Equal(t, 40*time.Second, reportDelay(interval, limit, 2, 0))
+	assert.Equal(t, limit, reportDelay(interval, limit, 3, 0))
+	assert.Equal(t, limit, reportDelay(interval, limit, 1000, 0))
+	assert.Equal(t, 90*time.Second, reportDelay(interval, limit, 1, 90*time.Second))
+}
+
+func TestClientHonorsRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+	res, err := NewRestyClient(time.Minute).R().Post(ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode())
+	assert.Equal(t, int32(2), calls.Load())
+	assert.GreaterOrEqual(t, time.Since(start), 2*time.Second)
+}
+
+func TestClientReturnsRetryAfterOverMaxWait(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	start := time.Now()
+	res, err := NewRestyClient(time.Minute).R().Post(ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode())
+	assert.Equal(t, int32(1), calls.Load(), "no retry before the server allows it")
+	assert.Less(t, time.Since(start), time.Second)
+
+	d, ok := serverRetryAfter(res)
+	require.True(t, ok)
+	assert.Equal(t, 120*time.Second, d, "the caller gets the full delay")
+}
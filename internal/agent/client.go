@@ -22,24 +22,28 @@ var headers = map[string]string{
 	"Content-Type":     "application/json",
 }
 
-func NewRestyClient() *resty.Client {
+// NewRestyClient retries failed requests with exponential backoff and jitter, starting at
+// one second and capped at maxWait. Retry-After of 429 and 503 responses is honored: when it
+// asks for more than maxWait the response is returned instead of retrying sooner.
+func NewRestyClient(maxWait time.Duration) *resty.Client {
 	return resty.New().
 		SetHeaders(headers).
 		SetRetryCount(3).
+		SetRetryWaitTime(1 * time.Second).
+		SetRetryMaxWaitTime(maxWait).
 		SetRetryAfter(func(c *resty.Client, r *resty.Response) (time.Duration, error) {
-			switch r.Request.Attempt {
-			case 1:
-				return 1 * time.Second, nil
-			case 2:
-				return 3 * time.Second, nil
-			case 3:
-				return 5 * time.Second, nil
-			default:
-				return 0, nil
+			// Zero falls back to the exponential backoff of resty.
+			if d, ok := serverRetryAfter(r); ok {
+				return d, nil
 			}
+			return 0, nil
 		}).
 		AddRetryCondition(func(r *resty.Response, err error) bool {
 			if r != nil {
+				// Resty clamps the wait to maxWait, which would retry before the server allows.
+				if d, ok := serverRetryAfter(r); ok && maxWait > 0 && d > maxWait {
+					return false
+				}
 				statusCode := r.StatusCode()
 				if slices.Contains(retryCodes, statusCode) {
 					return true
@@ -51,3 +55,15 @@ func NewRestyClient() *resty.Client {
 			return false
 		})
 }
+
+func serverRetryAfter(r *resty.Response) (time.Duration, bool) {
+	if r == nil || r.RawResponse == nil {
+		return 0, false
+	}
+	switch r.StatusCode() {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return parseRetryAfter(r.Header().Get("Retry-After"), time.Now())
+	default:
+		return 0, false
+	}
+}
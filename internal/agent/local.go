@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+)
+
+// SourceHeader names the application that sends custom metrics to the local endpoint.
+const SourceHeader = "X-Source"
+
+const maxLocalBody = 1 << 20
+
+var errInvalidCustomMetric = errors.New("metric must have id and gauge value or counter delta")
+
+// customMetrics accumulates application metrics between reports:
+// counters are summed, gauges keep the last written value.
+type customMetrics struct {
+	mu       sync.Mutex
+	gauges   map[string]float64
+	counters map[string]int64
+}
+
+func newCustomMetrics() *customMetrics {
+	return &customMetrics{
+		gauges:   make(map[string]float64),
+		counters: make(map[string]int64),
+	}
+}
+
+func (c *customMetrics) add(prefix string, metrics []m.Metrics) error {
+	for _, metric := range metrics {
+		if err := validateCustomMetric(metric); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, metric := range metrics {
+		nm := prefix + metric.ID
+		if metric.MType == "gauge" {
+			c.gauges[nm] = *metric.Value
+		} else {
+			c.counters[nm] += *metric.Delta
+		}
+	}
+	return nil
+}
+
+func validateCustomMetric(metric m.Metrics) error {
+	switch {
+	case metric.ID == "":
+		return errInvalidCustomMetric
+	case metric.MType == "gauge" && metric.Value != nil:
+		return nil
+	case metric.MType == "counter" && metric.Delta != nil:
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", errInvalidCustomMetric, metric.ID)
+	}
+}
+
+// drain returns accumulated metrics and starts a new report period.
+func (c *customMetrics) drain() []*m.Metrics {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	metrics := make([]*m.Metrics, 0, len(c.gauges)+len(c.counters))
+	for nm, v := range c.gauges {
+		metrics = append(metrics, &m.Metrics{ID: nm, MType: "gauge", Value: &v})
+	}
+	for nm, v := range c.counters {
+		metrics = append(metrics, &m.Metrics{ID: nm, MType: "counter", Delta: &v})
+	}
+	c.gauges = make(map[string]float64)
+	c.counters = make(map[string]int64)
+	return metrics
+}
+
+// localHandler accepts the same JSON batch as the server /updates/ endpoint.
+// Names are prefixed with the configured prefix of the source, or "<source>." for
+// sources without one; requests without a source are taken as is.
+func localHandler(custom *customMetrics, prefixes map[string]string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /updates/", func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = http.MaxBytesReader(w, r.Body, maxLocalBody)
+		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
+			zr, err := gzip.NewReader(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			defer zr.Close()
+			body = io.LimitReader(zr, maxLocalBody)
+		}
+
+		var metrics []m.Metrics
+		if err := json.NewDecoder(body).Decode(&metrics); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		prefix := ""
+		if src := r.Header.Get(SourceHeader); src != "" {
+			var ok bool
+			if prefix, ok = prefixes[src]; !ok {
+				prefix = src + "."
+			}
+		}
+		if err := custom.add(prefix, metrics); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	return mux
+}
+
+// listenLocal listens on addr, which is host:port or unix:/path/to/socket.
+func listenLocal(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+	}
+	return net.Listen("unix", path)
+}
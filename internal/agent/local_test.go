@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalHandlerMerge(t *testing.T) {
+	custom := newCustomMetrics()
+	h := localHandler(custom, map[string]string{"billing": "billing_"})
+
+	post := func(src, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+		if src != "" {
+			req.Header.Set(SourceHeader, src)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusOK, post("billing", `[{"id":"orders","type":"counter","delta":2}]`))
+	require.Equal(t, http.StatusOK, post("billing", `[{"id":"orders","type":"counter","delta":3},{"id":"queue","type":"gauge","value":1}]`))
+	require.Equal(t, http.StatusOK, post("billing", `[{"id":"queue","type":"gauge","value":7}]`))
+	require.Equal(t, http.StatusOK, post("web", `[{"id":"sessions","type":"gauge","value":4}]`))
+	assert.Equal(t, http.StatusBadRequest, post("", `[{"id":"broken","type":"counter"}]`))
+
+	got := map[string]float64{}
+	for _, metric := range custom.drain() {
+		if metric.MType == "gauge" {
+			got[metric.ID] = *metric.Value
+		} else {
+			got[metric.ID] = float64(*metric.Delta)
+		}
+	}
+	assert.Equal(t, map[string]float64{"billing_orders": 5, "billing_queue": 7, "web.sessions": 4}, got)
+	assert.Empty(t, custom.drain())
+}
@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"path"
+	"regexp"
+	"slices"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/configs"
+	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+)
+
+type relabelRule struct {
+	configs.RelabelRule
+	re    *regexp.Regexp
+	match func(nm string) bool
+}
+
+// relabeler applies relabel rules in order to every metric of a batch.
+type relabeler []relabelRule
+
+// newRelabeler turns the allow and deny globs into keep and drop rules that run before
+// the relabel rules: metrics matching no allow pattern, when there are any, and those
+// matching a deny pattern are dropped.
+func newRelabeler(allow, deny []string, rules []configs.RelabelRule) (relabeler, error) {
+	r := make(relabeler, 0, len(rules)+2)
+	if len(allow) > 0 {
+		r = append(r, globRule("keep", allow))
+	}
+	if len(deny) > 0 {
+		r = append(r, globRule("drop", deny))
+	}
+	for _, rule := range rules {
+		re, err := rule.Compile()
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, relabelRule{RelabelRule: rule, re: re, match: re.MatchString})
+	}
+	return r, nil
+}
+
+// globRule matches names against any of the glob patterns.
+func globRule(action string, patterns []string) relabelRule {
+	return relabelRule{
+		RelabelRule: configs.RelabelRule{Action: action},
+		match: func(nm string) bool {
+			return slices.ContainsFunc(patterns, func(p string) bool {
+				ok, _ := path.Match(p, nm)
+				return ok
+			})
+		},
+	}
+}
+
+func (r relabeler) apply(metrics []*m.Metrics) []*m.Metrics {
+	if len(r) == 0 {
+		return metrics
+	}
+	return slices.DeleteFunc(metrics, func(metric *m.Metrics) bool {
+		return !r.relabel(metric)
+	})
+}
+
+// relabel changes the metric in place and reports whether it is kept.
+func (r relabeler) relabel(metric *m.Metrics) bool {
+	for _, rule := range r {
+		matched := rule.match(metric.ID)
+		switch rule.Action {
+		case "keep":
+			if !matched {
+				return false
+			}
+		case "drop":
+			if matched {
+				return false
+			}
+		case "rename":
+			if matched {
+				metric.ID = rule.re.ReplaceAllString(metric.ID, rule.Replacement)
+			}
+		case "prefix":
+			if matched {
+				metric.ID = rule.Prefix + metric.ID
+			}
+		}
+	}
+	return true
+}
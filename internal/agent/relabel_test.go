@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volchkovski/go-practicum-metrics/internal/configs"
+	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+)
+
+func TestRelabeler(t *testing.T) {
+	r, err := newRelabeler([]string{"Heap*", "GC*", "PollCount", "RandomValue", "MyHeap"}, []string{"MyHeap"}, []configs.RelabelRule{
+		{Action: "drop", Regex: "RandomValue"},
+		{Action: "keep", Regex: "Heap.*|GC.*|PollCount"},
+		{Action: "rename", Regex: "Heap(.*)", Replacement: "heap_$1"},
+		{Action: "prefix", Regex: "heap_.*", Prefix: "go_"},
+	})
+	require.NoError(t, err)
+
+	v := 1.0
+	metrics := []*m.Metrics{
+		{ID: "RandomValue", MType: "gauge", Value: &v},
+		{ID: "HeapAlloc", MType: "gauge", Value: &v},
+		{ID: "Alloc", MType: "gauge", Value: &v},
+		{ID: "GCSys", MType: "gauge", Value: &v},
+		{ID: "MyHeap", MType: "gauge", Value: &v},
+		{ID: "GCCPUFraction", MType: "gauge", Value: &v},
+	}
+
+	got := r.apply(metrics)
+	names := make([]string, 0, len(got))
+	for _, metric := range got {
+		names = append(names, metric.ID)
+	}
+	assert.Equal(t, []string{"go_heap_Alloc", "GCSys", "GCCPUFraction"}, names)
+}
@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	ModeFailover = "failover"
+	ModeFanout   = "fanout"
+)
+
+// batch is an encoded batch with the idempotency key it keeps across resends. Delivery is
+// at least once: servers drop a resent batch they already applied, but one sent to another
+// server after an unknown outcome, or after the server restarted, may be applied twice.
+type batch struct {
+	key  string
+	body []byte
+}
+
+// newBatch keys body randomly. Without randomness the batch is sent without a key, since
+// a shared one would make servers drop different batches.
+func newBatch(body []byte) batch {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return batch{body: body}
+	}
+	return batch{key: hex.EncodeToString(key), body: body}
+}
+
+// spool keeps batches that are not delivered yet, oldest first. When full the oldest
+// batch is dropped.
+type spool struct {
+	batches []batch
+	limit   int
+	dropped int
+}
+
+func (s *spool) push(b batch) {
+	s.batches = append(s.batches, b)
+	if over := len(s.batches) - s.limit; s.limit > 0 && over > 0 {
+		s.batches = s.batches[over:]
+		s.dropped += over
+	}
+}
+
+// flush sends spooled batches in order and stops at the first failure.
+func (s *spool) flush(send func(batch) error) error {
+	for len(s.batches) > 0 {
+		if err := send(s.batches[0]); err != nil {
+			return err
+		}
+		s.batches = s.batches[1:]
+	}
+	return nil
+}
+
+// target is a server with its own delivery state, used in fan-out mode.
+type target struct {
+	addr      string
+	spool     spool
+	delivered int
+	failed    int
+}
+
+type deliverer interface {
+	deliver(body []byte) error
+}
+
+// fanout sends every batch to all servers; each server spools independently.
+type fanout struct {
+	targets []*target
+	post    func(addr string, b batch) error
+}
+
+func newFanout(addrs []string, spoolSize int, post func(string, batch) error) *fanout {
+	targets := make([]*target, 0, len(addrs))
+	for _, addr := range addrs {
+		targets = append(targets, &target{addr: addr, spool: spool{limit: spoolSize}})
+	}
+	return &fanout{targets: targets, post: post}
+}
+
+func (f *fanout) deliver(body []byte) error {
+	b := newBatch(body)
+	errs := make([]error, len(f.targets))
+	var wg sync.WaitGroup
+	for i, t := range f.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.spool.push(b)
+			err := t.spool.flush(func(b batch) error {
+				return f.post(t.addr, b)
+			})
+			if err != nil {
+				t.failed++
+				errs[i] = fmt.Errorf("%s (%d batches spooled, %d dropped): %w", t.addr, len(t.spool.batches), t.spool.dropped, err)
+				return
+			}
+			t.delivered++
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// failover sends to the first healthy server in list order. Servers before the active one
+// are probed via /ping and taken back as soon as they answer.
+type failover struct {
+	addrs  []string
+	spool  spool
+	post   func(addr string, b batch) error
+	ping   func(addr string) error
+	mu     sync.Mutex
+	active int
+}
+
+func newFailover(addrs []string, spoolSize int, post func(string, batch) error, ping func(string) error) *failover {
+	return &failover{addrs: addrs, spool: spool{limit: spoolSize}, post: post, ping: ping}
+}
+
+func (f *failover) current() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.active
+}
+
+func (f *failover) setActive(i int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active != i {
+		log.Printf("Switching to server %s", f.addrs[i])
+	}
+	f.active = i
+}
+
+// deliver tries every server once, starting from the active one.
+func (f *failover) deliver(body []byte) error {
+	f.spool.push(newBatch(body))
+	start := f.current()
+	errs := make([]error, 0, len(f.addrs))
+	for n := range len(f.addrs) {
+		i := (start + n) % len(f.addrs)
+		addr := f.addrs[i]
+		err := f.spool.flush(func(b batch) error {
+			return f.post(addr, b)
+		})
+		if err == nil {
+			f.setActive(i)
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+	}
+	return fmt.Errorf("all servers failed (%d batches spooled, %d dropped): %w",
+		len(f.spool.batches), f.spool.dropped, errors.Join(errs...))
+}
+
+// probe checks servers preferred over the active one until stop is closed.
+func (f *failover) probe(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for i := range f.current() {
+			if f.ping(f.addrs[i]) == nil {
+				f.setActive(i)
+				break
+			}
+		}
+	}
+}
+
+func pingStatus(res *http.Response) error {
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("ping status: %d", res.StatusCode)
+	}
+	return nil
+}
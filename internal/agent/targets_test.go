@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServers records delivered batches per address; addresses in down fail.
+type fakeServers struct {
+	mu   sync.Mutex
+	down map[string]bool
+	got  map[string][]string
+	keys map[string][]string
+}
+
+func newFakeServers() *fakeServers {
+	return &fakeServers{down: map[string]bool{}, got: map[string][]string{}, keys: map[string][]string{}}
+}
+
+func (s *fakeServers) post(addr string, b batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down[addr] {
+		return errors.New("connection refused")
+	}
+	s.got[addr] = append(s.got[addr], string(b.body))
+	s.keys[addr] = append(s.keys[addr], b.key)
+	return nil
+}
+
+func (s *fakeServers) ping(addr string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down[addr] {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (s *fakeServers) setDown(addr string, down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down[addr] = down
+}
+
+func TestFailover(t *testing.T) {
+	srv := newFakeServers()
+	f := newFailover([]string{"a", "b"}, 10, srv.post, srv.ping)
+
+	require.NoError(t, f.deliver([]byte("1")))
+	srv.setDown("a", true)
+	require.NoError(t, f.deliver([]byte("2")))
+	assert.Equal(t, 1, f.current())
+
+	srv.setDown("b", true)
+	assert.Error(t, f.deliver([]byte("3")))
+	srv.setDown("b", false)
+	require.NoError(t, f.deliver([]byte("4")))
+
+	srv.setDown("a", false)
+	stop := make(chan struct{})
+	go f.probe(1, stop)
+	assert.Eventually(t, func() bool { return f.current() == 0 }, time.Second, time.Millisecond)
+	close(stop)
+	require.NoError(t, f.deliver([]byte("5")))
+
+	assert.Equal(t, []string{"1", "5"}, srv.got["a"])
+	assert.Equal(t, []string{"2", "3", "4"}, srv.got["b"])
+}
+
+func TestFanoutSpoolsPerTarget(t *testing.T) {
+	srv := newFakeServers()
+	f := newFanout([]string{"a", "b"}, 2, srv.post)
+
+	srv.setDown("b", true)
+	assert.Error(t, f.deliver([]byte("1")))
+	assert.Error(t, f.deliver([]byte("2")))
+	assert.Error(t, f.deliver([]byte("3")))
+	srv.setDown("b", false)
+	require.NoError(t, f.deliver([]byte("4")))
+
+	assert.Equal(t, []string{"1", "2", "3", "4"}, srv.got["a"])
+	assert.Equal(t, []string{"3", "4"}, srv.got["b"])
+	assert.Equal(t, 2, f.targets[1].spool.dropped)
+	assert.Equal(t, 4, f.targets[0].delivered)
+	assert.Equal(t, srv.keys["a"][2:], srv.keys["b"], "a batch keeps its key on every target")
+	assert.NotEqual(t, srv.keys["a"][2], srv.keys["a"][3])
+}
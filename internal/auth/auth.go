@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+type Scope string
+
+const (
+	ScopeRead  = Scope("read")
+	ScopeWrite = Scope("write")
+	ScopeAdmin = Scope("admin")
+	// ScopeAnyTenant lets a token choose the tenant of a request. It is not implied by
+	// admin and grants nothing on its own.
+	ScopeAnyTenant = Scope("any-tenant")
+)
+
+var ErrUnknownToken = errors.New("unknown token")
+
+type Token struct {
+	Value  string  `json:"token"`
+	Scopes []Scope `json:"scopes"`
+	Prefix string  `json:"prefix"` // empty prefix allows any metric name
+	Tenant string  `json:"tenant"` // empty tenant is the default one
+}
+
+// HasScope reports whether the token grants scope. Admin grants every scope.
+func (t *Token) HasScope(scope Scope) bool {
+	return slices.Contains(t.Scopes, scope) || slices.Contains(t.Scopes, ScopeAdmin)
+}
+
+// AnyTenant reports whether the token may access tenants other than its own.
+func (t *Token) AnyTenant() bool {
+	return slices.Contains(t.Scopes, ScopeAnyTenant)
+}
+
+func (t *Token) Allows(name string) bool {
+	return strings.HasPrefix(name, t.Prefix)
+}
+
+func (t *Token) validate() error {
+	if t.Value == "" {
+		return errors.New("token value is empty")
+	}
+	if len(t.Scopes) == 0 {
+		return errors.New("token has no scopes")
+	}
+	for _, s := range t.Scopes {
+		switch s {
+		case ScopeRead, ScopeWrite, ScopeAdmin, ScopeAnyTenant:
+		default:
+			return fmt.Errorf("unknown scope %q, allowed: %s, %s, %s, %s", s, ScopeRead, ScopeWrite, ScopeAdmin, ScopeAnyTenant)
+		}
+	}
+	return nil
+}
+
+type Store interface {
+	Lookup(ctx context.Context, token string) (*Token, error)
+}
+
+type StoreFunc func(ctx context.Context, token string) (*Token, error)
+
+func (f StoreFunc) Lookup(ctx context.Context, token string) (*Token, error) {
+	return f(ctx, token)
+}
+
+type StaticStore struct {
+	tokens map[string]*Token
+}
+
+func NewStaticStore(tokens []*Token) (*StaticStore, error) {
+	s := &StaticStore{tokens: make(map[string]*Token, len(tokens))}
+	for i, t := range tokens {
+		if err := t.validate(); err != nil {
+			return nil, fmt.Errorf("token #%d: %w", i, err)
+		}
+		s.tokens[t.Value] = t
+	}
+	return s, nil
+}
+
+// LoadFile reads tokens from a JSON file containing an array of tokens.
+func LoadFile(fp string) (*StaticStore, error) {
+	data, err := os.ReadFile(fp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tokens file: %w", err)
+	}
+	var tokens []*Token
+	if err = json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to decode tokens file: %w", err)
+	}
+	return NewStaticStore(tokens)
+}
+
+func (s *StaticStore) Lookup(ctx context.Context, token string) (*Token, error) {
+	if t, ok := s.tokens[token]; ok {
+		return t, nil
+	}
+	return nil, ErrUnknownToken
+}
+
+type ctxKey struct{}
+
+func WithToken(ctx context.Context, t *Token) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+func FromContext(ctx context.Context) (*Token, bool) {
+	t, ok := ctx.Value(ctxKey{}).(*Token)
+	return t, ok
+}
+
+// Permits reports whether the request token may access the metric name.
+// Requests without a token are permitted: authentication is disabled for them.
+func Permits(ctx context.Context, name string) bool {
+	t, ok := FromContext(ctx)
+	if !ok {
+		return true
+	}
+	return t.Allows(name)
+}
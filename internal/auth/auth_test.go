@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticStore(t *testing.T) {
+	_, err := NewStaticStore([]*Token{{Value: "t", Scopes: []Scope{"everything"}}})
+	assert.ErrorContains(t, err, "unknown scope")
+	_, err = NewStaticStore([]*Token{{Value: "t"}})
+	assert.ErrorContains(t, err, "no scopes")
+
+	store, err := NewStaticStore([]*Token{
+		{Value: "admin", Scopes: []Scope{ScopeAdmin}},
+		{Value: "ops", Scopes: []Scope{ScopeRead, ScopeAnyTenant}},
+	})
+	require.NoError(t, err)
+	_, err = store.Lookup(context.Background(), "nope")
+	assert.ErrorIs(t, err, ErrUnknownToken)
+
+	admin, err := store.Lookup(context.Background(), "admin")
+	require.NoError(t, err)
+	assert.True(t, admin.HasScope(ScopeWrite))
+	assert.False(t, admin.AnyTenant(), "admin does not imply any tenant")
+
+	ops, err := store.Lookup(context.Background(), "ops")
+	require.NoError(t, err)
+	assert.True(t, ops.AnyTenant())
+	assert.False(t, ops.HasScope(ScopeWrite))
+}
@@ -2,38 +2,117 @@ package backup
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/volchkovski/go-practicum-metrics/internal/handlers"
 	"github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
 )
 
 type metrics struct {
 	Gauges   []*models.GaugeMetric   `json:"gauges"`
 	Counters []*models.CounterMetric `json:"counters"`
+	Meta     []*models.MetricMeta    `json:"meta,omitempty"`
 }
 
 type metricsGetPusher interface {
-	handlers.MetricPusher
 	handlers.AllMetricsGetter
+	handlers.AllMetaGetter
+	handlers.MetaPutter
+	Tenants(context.Context) ([]string, error)
+	SetMetrics(context.Context, []*models.GaugeMetric, []*models.CounterMetric) error
+	PauseWrites(func() error) error
 }
 
 type MetricsBackup struct {
-	mgp      metricsGetPusher
+	mgp         metricsGetPusher
+	fp          string
+	interval    time.Duration
+	keep        int
+	compression string
+	restoreMode string
+	wal         *WAL
+	checkpoint  time.Duration
+	// replayed is the last WAL segment applied by Restore, the next snapshot covers it.
+	replayed uint64
+	notify   chan error
+	trigger  chan struct{}
+	schedule chan schedule
+
+	mu       sync.Mutex
+	lastDump time.Time
+}
+
+type schedule struct {
 	fp       string
 	interval time.Duration
-	notify   chan error
 }
 
-func NewMetricsBackup(mgp metricsGetPusher, fp string, intr int) *MetricsBackup {
-	return &MetricsBackup{
-		mgp:      mgp,
-		fp:       fp,
-		interval: time.Duration(intr) * time.Second,
-		notify:   make(chan error, 1),
+type Option func(*MetricsBackup)
+
+// WithKeep sets how many snapshots are kept, older ones are removed after each dump.
+func WithKeep(n int) Option {
+	return func(b *MetricsBackup) {
+		b.keep = n
+	}
+}
+
+// WithCompression compresses new snapshots with one of Compressions.
+func WithCompression(compression string) Option {
+	return func(b *MetricsBackup) {
+		b.compression = compression
+	}
+}
+
+// WithWAL makes the backup synchronous: writes are recorded in w and a snapshot is taken
+// every checkpoint interval, after which the WAL segments it covers are removed.
+func WithWAL(w *WAL, checkpoint time.Duration) Option {
+	return func(b *MetricsBackup) {
+		b.wal = w
+		b.checkpoint = checkpoint
+	}
+}
+
+func NewMetricsBackup(mgp metricsGetPusher, fp string, interval time.Duration, opts ...Option) *MetricsBackup {
+	b := &MetricsBackup{
+		mgp:         mgp,
+		fp:          fp,
+		interval:    interval,
+		keep:        5,
+		compression: CompressionNone,
+		restoreMode: RestoreReplace,
+		notify:      make(chan error, 1),
+		trigger:     make(chan struct{}, 1),
+		schedule:    make(chan schedule, 1),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Reschedule changes the backup path and interval of a started backup from the next dump on.
+// A pending change that was not picked up yet is replaced.
+func (b *MetricsBackup) Reschedule(fp string, interval time.Duration) {
+	for {
+		select {
+		case b.schedule <- schedule{fp: fp, interval: interval}:
+			return
+		default:
+		}
+		select {
+		case <-b.schedule:
+		default:
+		}
 	}
 }
 
@@ -41,78 +120,167 @@ func (b *MetricsBackup) Notify() chan error {
 	return b.notify
 }
 
-func (b *MetricsBackup) Restore() (err error) {
-	file, err := os.OpenFile(b.fp, os.O_RDONLY|os.O_CREATE, 0666)
-	if err != nil {
-		return
+// Trigger requests a dump ahead of the interval, e.g. after metrics were deleted.
+// It does not wait for the dump; requests made while one is pending are merged.
+func (b *MetricsBackup) Trigger() {
+	select {
+	case b.trigger <- struct{}{}:
+	default:
 	}
-	defer func() {
-		if errClose := file.Close(); errClose != nil {
-			err = errors.Join(err, errClose)
-		}
-	}()
-	var m metrics
-	err = json.NewDecoder(file).Decode(&m)
+}
+
+// CheckWritable verifies that a backup file can be created next to the configured path.
+func (b *MetricsBackup) CheckWritable(context.Context) error {
+	fp, _ := b.settings()
+	file, err := os.CreateTemp(filepath.Dir(fp), ".writable-*")
 	if err != nil {
-		return
+		return fmt.Errorf("backup directory is not writable: %w", err)
 	}
-	for _, gauge := range m.Gauges {
-		err = b.mgp.PushGaugeMetric(context.Background(), gauge)
-		if err != nil {
-			return
-		}
+	return errors.Join(file.Close(), os.Remove(file.Name()))
+}
+
+// CheckAge fails when no backup succeeded for two intervals. Until the first dump the age
+// counts from Start.
+func (b *MetricsBackup) CheckAge(context.Context) error {
+	_, interval := b.settings()
+	b.mu.Lock()
+	last := b.lastDump
+	b.mu.Unlock()
+	if interval == 0 || last.IsZero() {
+		return nil
 	}
-	for _, counter := range m.Counters {
-		err = b.mgp.PushCounterMetric(context.Background(), counter)
-		if err != nil {
-			return
-		}
+	if age := time.Since(last); age > 2*interval {
+		return fmt.Errorf("last successful backup %s ago, interval is %s", age.Round(time.Second), interval)
 	}
 	return nil
 }
 
+// settings returns the backup path and the period of dumps, which is the checkpoint
+// interval in WAL mode.
+func (b *MetricsBackup) settings() (string, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.wal != nil {
+		return b.fp, b.checkpoint
+	}
+	return b.fp, b.interval
+}
+
 func (b *MetricsBackup) Start() {
+	b.mu.Lock()
+	b.lastDump = time.Now()
+	b.mu.Unlock()
 	go func() {
+		_, period := b.settings()
+		ticker := time.NewTicker(max(period, time.Millisecond))
+		defer ticker.Stop()
 		for {
-			time.Sleep(b.interval)
+			select {
+			case <-ticker.C:
+			case <-b.trigger:
+			case sch := <-b.schedule:
+				b.mu.Lock()
+				b.fp, b.interval = sch.fp, sch.interval
+				b.mu.Unlock()
+				_, period = b.settings()
+				ticker.Reset(max(period, time.Millisecond))
+				continue
+			}
+			done := selfmetrics.Default.Time("backup.dump")
 			err := b.dumpMetrics()
+			done()
 			if err != nil {
+				selfmetrics.Default.Add("backup.failures", 1)
 				b.notify <- err
 				break
 			}
+			b.mu.Lock()
+			b.lastDump = time.Now()
+			b.mu.Unlock()
 		}
 		close(b.notify)
 	}()
 }
 
-func (b *MetricsBackup) dumpMetrics() (err error) {
-	ctx := context.Background()
-	gauges, err := b.mgp.GetAllGaugeMetrics(ctx)
+// Dump writes a snapshot right away, for callers that do not Start the backup.
+func (b *MetricsBackup) Dump() error {
+	return b.dumpMetrics()
+}
+
+// dumpMetrics writes a snapshot of all tenants and removes snapshots beyond keep and
+// WAL segments the snapshot covers. In WAL mode writes are paused while the segment is
+// switched and metrics are read, so the snapshot matches the closed segments exactly.
+func (b *MetricsBackup) dumpMetrics() error {
+	var (
+		s   snapshot
+		seq = b.replayed
+	)
+	capture := func() (err error) {
+		if b.wal != nil {
+			if seq, err = b.wal.Rotate(); err != nil {
+				return err
+			}
+		}
+		s, err = b.capture(context.Background())
+		return err
+	}
+	var err error
+	if b.wal != nil {
+		err = b.mgp.PauseWrites(capture)
+	} else {
+		err = capture()
+	}
 	if err != nil {
-		return
+		return err
 	}
-	counters, err := b.mgp.GetAllCounterMetrics(ctx)
+	s.WALSegment = seq
+
+	fp, _ := b.settings()
+	err = writeFileAtomic(snapshotName(fp, time.Now(), b.compression), func(w io.Writer) error {
+		return encodeSnapshot(w, s, b.compression)
+	})
 	if err != nil {
-		return
+		return err
+	}
+	if err = b.prune(fp); err != nil {
+		return err
 	}
-	file, err := os.Create(b.fp)
+	return removeSegments(fp, seq)
+}
+
+func (b *MetricsBackup) capture(ctx context.Context) (snapshot, error) {
+	ids, err := b.mgp.Tenants(ctx)
 	if err != nil {
-		return
+		return snapshot{}, err
 	}
-	defer func() {
-		if errClose := file.Close(); errClose != nil {
-			err = errors.Join(err, errClose)
+	s := snapshot{Tenants: make(map[string]*metrics, len(ids))}
+	for _, id := range ids {
+		ctx := tenant.WithTenant(ctx, id)
+		gauges, err := b.mgp.GetAllGaugeMetrics(ctx)
+		if err != nil {
+			return s, fmt.Errorf("failed to dump tenant %s: %w", id, err)
 		}
-	}()
-
-	m := metrics{
-		Gauges:   gauges,
-		Counters: counters,
+		counters, err := b.mgp.GetAllCounterMetrics(ctx)
+		if err != nil {
+			return s, fmt.Errorf("failed to dump tenant %s: %w", id, err)
+		}
+		metas, err := b.mgp.GetAllMetricMeta(ctx)
+		if err != nil {
+			return s, fmt.Errorf("failed to dump tenant %s: %w", id, err)
+		}
+		s.Tenants[id] = &metrics{Gauges: gauges, Counters: counters, Meta: slices.Collect(maps.Values(metas))}
 	}
+	return s, nil
+}
 
-	err = json.NewEncoder(file).Encode(m)
-	if err != nil {
-		return
+func (b *MetricsBackup) prune(fp string) error {
+	names, err := listSnapshots(fp)
+	if err != nil || len(names) <= b.keep {
+		return err
 	}
-	return nil
+	errs := make([]error, 0, len(names)-b.keep)
+	for _, nm := range names[b.keep:] {
+		errs = append(errs, os.Remove(nm))
+	}
+	return errors.Join(errs...)
 }
@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
+	"github.com/volchkovski/go-practicum-metrics/internal/services"
+	"github.com/volchkovski/go-practicum-metrics/internal/storage/mem"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+func newService(t *testing.T) *services.MetricService {
+	t.Helper()
+	return services.NewMetricService(mem.NewMemStorage())
+}
+
+func TestDumpRestore(t *testing.T) {
+	for _, compression := range Compressions {
+		t.Run(compression, func(t *testing.T) {
+			fp := filepath.Join(t.TempDir(), "metrics.json")
+			strg := mem.NewMemStorage()
+			src := services.NewMetricService(strg)
+			ctx := context.Background()
+			require.NoError(t, src.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 1.5}))
+			require.NoError(t, src.PushCounterMetric(tenant.WithTenant(ctx, "team"), &models.CounterMetric{Name: "c", Value: 3}))
+			require.NoError(t, strg.WriteCounter(ctx, selfmetrics.Prefix+"ingest.batches", 5))
+			ops := tenant.WithTenant(ctx, "ops")
+			require.NoError(t, src.PutMetricMeta(ops, &models.MetricMeta{Name: "latency", Type: "gauge", Unit: "ms"}))
+
+			b := NewMetricsBackup(src, fp, time.Minute, WithKeep(2), WithCompression(compression))
+			for range 3 {
+				require.NoError(t, b.dumpMetrics())
+			}
+			names, err := listSnapshots(fp)
+			require.NoError(t, err)
+			assert.Len(t, names, 2, "older snapshots are pruned")
+
+			dst := newService(t)
+			require.NoError(t, NewMetricsBackup(dst, fp, time.Minute).Restore())
+			gauge, err := dst.GetGaugeMetric(ctx, "g")
+			require.NoError(t, err)
+			assert.Equal(t, 1.5, gauge.Value)
+			counter, err := dst.GetCounterMetric(tenant.WithTenant(ctx, "team"), "c")
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), counter.Value)
+			_, err = dst.GetCounterMetric(ctx, selfmetrics.Prefix+"ingest.batches")
+			assert.Error(t, err, "server metrics are not restored")
+			meta, err := dst.GetMetricMeta(ops, "latency")
+			require.NoError(t, err, "metadata of a tenant without values is restored")
+			assert.Equal(t, &models.MetricMeta{Name: "latency", Type: "gauge", Unit: "ms"}, meta)
+		})
+	}
+}
+
+func TestRestoreFallsBackToValidSnapshot(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "metrics.json")
+	ctx := context.Background()
+	src := newService(t)
+	require.NoError(t, src.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 1}))
+	b := NewMetricsBackup(src, fp, time.Minute, WithCompression(CompressionGzip))
+	require.NoError(t, b.dumpMetrics())
+	require.NoError(t, src.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 2}))
+	require.NoError(t, b.dumpMetrics())
+
+	names, err := listSnapshots(fp)
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+	data, err := os.ReadFile(names[0])
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(names[0], data, 0o600))
+
+	dst := newService(t)
+	require.NoError(t, NewMetricsBackup(dst, fp, time.Minute).Restore())
+	gauge, err := dst.GetGaugeMetric(ctx, "g")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, gauge.Value, "the older valid snapshot is used")
+
+	require.NoError(t, os.WriteFile(names[1], []byte("garbage\n"), 0o600))
+	assert.ErrorIs(t, NewMetricsBackup(newService(t), fp, time.Minute).Restore(), ErrCorrupted)
+}
+
+func TestRestoreWithoutBackup(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, NewMetricsBackup(newService(t), fp, time.Minute).Restore())
+	_, err := os.Stat(fp)
+	assert.ErrorIs(t, err, os.ErrNotExist, "restore must not create files")
+}
+
+func TestRestorePlainFile(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, os.WriteFile(fp, []byte(`{"gauges":[{"name":"g","value":4}],"counters":[{"name":"c","value":7}]}`), 0o600))
+
+	dst := newService(t)
+	require.NoError(t, NewMetricsBackup(dst, fp, time.Minute).Restore())
+	gauge, err := dst.GetGaugeMetric(context.Background(), "g")
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, gauge.Value)
+	counter, err := dst.GetCounterMetric(context.Background(), "c")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), counter.Value)
+}
+
+func TestRestoreModes(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		mode    string
+		counter int64
+		gauge   float64
+		again   int64
+	}{
+		{mode: RestoreReplace, counter: 5, gauge: 1, again: 5},
+		{mode: RestoreMergeMax, counter: 8, gauge: 9, again: 8},
+		{mode: RestoreAdd, counter: 13, gauge: 1, again: 18},
+	}
+	for _, tc := range tests {
+		t.Run(tc.mode, func(t *testing.T) {
+			fp := filepath.Join(t.TempDir(), "metrics.json")
+			src := newService(t)
+			require.NoError(t, src.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 1}))
+			require.NoError(t, src.PushCounterMetric(ctx, &models.CounterMetric{Name: "c", Value: 5}))
+			require.NoError(t, NewMetricsBackup(src, fp, time.Minute).dumpMetrics())
+
+			dst := newService(t)
+			require.NoError(t, dst.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 9}))
+			require.NoError(t, dst.PushCounterMetric(ctx, &models.CounterMetric{Name: "c", Value: 8}))
+			b := NewMetricsBackup(dst, fp, time.Minute, WithRestoreMode(tc.mode))
+			require.NoError(t, b.Restore())
+
+			counter, err := dst.GetCounterMetric(ctx, "c")
+			require.NoError(t, err)
+			assert.Equal(t, tc.counter, counter.Value)
+			gauge, err := dst.GetGaugeMetric(ctx, "g")
+			require.NoError(t, err)
+			assert.Equal(t, tc.gauge, gauge.Value)
+
+			require.NoError(t, b.Restore())
+			counter, err = dst.GetCounterMetric(ctx, "c")
+			require.NoError(t, err)
+			assert.Equal(t, tc.again, counter.Value, "only add mode changes counters when restoring again")
+		})
+	}
+}
+
+func TestRestoreDiff(t *testing.T) {
+	ctx := context.Background()
+	fp := filepath.Join(t.TempDir(), "metrics.json")
+	src := newService(t)
+	require.NoError(t, src.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 1.5}))
+	require.NoError(t, src.PushCounterMetric(ctx, &models.CounterMetric{Name: "c", Value: 5}))
+	require.NoError(t, src.PushCounterMetric(ctx, &models.CounterMetric{Name: "same", Value: 2}))
+	require.NoError(t, src.PutMetricMeta(ctx, &models.MetricMeta{Name: "c", Type: "counter", Help: "Requests served"}))
+	require.NoError(t, NewMetricsBackup(src, fp, time.Minute).dumpMetrics())
+
+	dst := newService(t)
+	require.NoError(t, dst.PushCounterMetric(ctx, &models.CounterMetric{Name: "c", Value: 3}))
+	require.NoError(t, dst.PushCounterMetric(ctx, &models.CounterMetric{Name: "same", Value: 2}))
+	require.NoError(t, dst.PutMetricMeta(ctx, &models.MetricMeta{Name: "c", Type: "counter"}))
+	changes, err := NewMetricsBackup(dst, fp, time.Minute).Diff()
+	require.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Tenant: tenant.Default, Type: "counter", Name: "c", Current: "3", Restored: "5"},
+		{Tenant: tenant.Default, Type: "gauge", Name: "g", Restored: "1.5"},
+		{Tenant: tenant.Default, Type: "meta", Name: "c", Current: "type=counter", Restored: `type=counter help="Requests served"`},
+	}, changes)
+
+	counter, err := dst.GetCounterMetric(ctx, "c")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), counter.Value, "diff does not change storage")
+}
@@ -0,0 +1,457 @@
+package backup
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/handlers"
+	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+// Restore modes decide how backed up values combine with values already in storage.
+const (
+	// RestoreReplace overwrites stored values with the backup.
+	RestoreReplace = "replace"
+	// RestoreMergeMax keeps the larger counter and the stored gauge when it exists.
+	RestoreMergeMax = "merge-max"
+	// RestoreAdd adds backed up counters to stored ones and overwrites gauges. Unlike the
+	// other modes it is not idempotent: every restore adds the counters again, so it suits
+	// a one-off merge of a backup into storage that does not contain it yet.
+	RestoreAdd = "add"
+)
+
+var RestoreModes = []string{RestoreReplace, RestoreMergeMax, RestoreAdd}
+
+// WithRestoreMode sets one of RestoreModes, RestoreReplace by default.
+func WithRestoreMode(mode string) Option {
+	return func(b *MetricsBackup) {
+		b.restoreMode = mode
+	}
+}
+
+// Change is a metric or metadata that a restore sets, metadata has the type "meta".
+// Current is empty when the metric does not exist.
+type Change struct {
+	Tenant   string `json:"tenant"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Current  string `json:"current,omitempty"`
+	Restored string `json:"restored"`
+}
+
+// metaType marks metadata changes.
+const metaType = "meta"
+
+type values struct {
+	gauges   map[string]float64
+	counters map[string]int64
+	meta     map[string]models.MetricMeta
+}
+
+func newValues() *values {
+	return &values{
+		gauges:   make(map[string]float64),
+		counters: make(map[string]int64),
+		meta:     make(map[string]models.MetricMeta),
+	}
+}
+
+// plan is the outcome of a restore: per tenant the values to set, which differ from current.
+type plan struct {
+	target   map[string]*values
+	current  map[string]*values
+	replayed uint64
+}
+
+// Restore loads the newest snapshot that passes verification, older ones are tried when
+// newer are corrupted. WAL segments written after the snapshot are applied on top, and the
+// result is combined with stored values according to the restore mode. Restoring twice
+// changes nothing in replace and merge-max modes, while add mode adds the counters twice.
+// Metadata is restored after the values; merge-max keeps stored metadata, the other modes
+// overwrite it.
+func (b *MetricsBackup) Restore() error {
+	defer selfmetrics.Default.Time("backup.restore")()
+	p, err := b.plan()
+	if err != nil {
+		return err
+	}
+	for id, target := range p.target {
+		ctx := withReplay(tenant.WithTenant(context.Background(), id))
+		if err = b.mgp.SetMetrics(ctx, gaugeList(target.gauges), counterList(target.counters)); err != nil {
+			return fmt.Errorf("failed to restore tenant %s: %w", id, err)
+		}
+		for _, meta := range target.meta {
+			if err = b.mgp.PutMetricMeta(ctx, &meta); err != nil {
+				return fmt.Errorf("failed to restore tenant %s: %w", id, err)
+			}
+		}
+	}
+	b.replayed = p.replayed
+	logger.Log.Infof("Restored %d changed metrics in %s mode", len(p.changes()), b.restoreMode)
+	return nil
+}
+
+// Diff returns what Restore would change without changing anything.
+func (b *MetricsBackup) Diff() ([]Change, error) {
+	p, err := b.plan()
+	if err != nil {
+		return nil, err
+	}
+	return p.changes(), nil
+}
+
+func (b *MetricsBackup) plan() (*plan, error) {
+	fp, _ := b.settings()
+	backed, covered, err := loadBase(fp)
+	if err != nil {
+		return nil, err
+	}
+	p := &plan{target: make(map[string]*values), current: make(map[string]*values)}
+	if p.replayed, err = b.applyWAL(fp, covered, backed); err != nil {
+		return nil, err
+	}
+
+	for id, bv := range backed {
+		cur, err := b.currentValues(id)
+		if err != nil {
+			return nil, err
+		}
+		target := newValues()
+		for nm, v := range bv.gauges {
+			if c, ok := cur.gauges[nm]; ok && b.restoreMode == RestoreMergeMax {
+				v = c
+			}
+			if c, ok := cur.gauges[nm]; !ok || c != v {
+				target.gauges[nm] = v
+			}
+		}
+		for nm, v := range bv.counters {
+			c, ok := cur.counters[nm]
+			switch b.restoreMode {
+			case RestoreMergeMax:
+				v = max(v, c)
+			case RestoreAdd:
+				v += c
+			}
+			if !ok || c != v {
+				target.counters[nm] = v
+			}
+		}
+		for nm, meta := range bv.meta {
+			c, ok := cur.meta[nm]
+			if ok && b.restoreMode == RestoreMergeMax {
+				continue
+			}
+			if !ok || c != meta {
+				target.meta[nm] = meta
+			}
+		}
+		p.target[id], p.current[id] = target, cur
+	}
+	return p, nil
+}
+
+func (b *MetricsBackup) currentValues(id string) (*values, error) {
+	ctx := tenant.WithTenant(context.Background(), id)
+	cur := newValues()
+	gauges, err := b.mgp.GetAllGaugeMetrics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant %s: %w", id, err)
+	}
+	for _, g := range gauges {
+		cur.gauges[g.Name] = g.Value
+	}
+	counters, err := b.mgp.GetAllCounterMetrics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant %s: %w", id, err)
+	}
+	for _, c := range counters {
+		cur.counters[c.Name] = c.Value
+	}
+	metas, err := b.mgp.GetAllMetricMeta(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant %s: %w", id, err)
+	}
+	for nm, meta := range metas {
+		cur.meta[nm] = *meta
+	}
+	return cur, nil
+}
+
+func (p *plan) changes() []Change {
+	var changes []Change
+	for id, target := range p.target {
+		cur := p.current[id]
+		for nm, v := range target.gauges {
+			ch := Change{Tenant: id, Type: string(handlers.GaugeType), Name: nm, Restored: formatGauge(v)}
+			if c, ok := cur.gauges[nm]; ok {
+				ch.Current = formatGauge(c)
+			}
+			changes = append(changes, ch)
+		}
+		for nm, v := range target.counters {
+			ch := Change{Tenant: id, Type: string(handlers.CounterType), Name: nm, Restored: strconv.FormatInt(v, 10)}
+			if c, ok := cur.counters[nm]; ok {
+				ch.Current = strconv.FormatInt(c, 10)
+			}
+			changes = append(changes, ch)
+		}
+		for nm, meta := range target.meta {
+			ch := Change{Tenant: id, Type: metaType, Name: nm, Restored: formatMeta(meta)}
+			if c, ok := cur.meta[nm]; ok {
+				ch.Current = formatMeta(c)
+			}
+			changes = append(changes, ch)
+		}
+	}
+	slices.SortFunc(changes, func(a, b Change) int {
+		return cmp.Or(cmp.Compare(a.Tenant, b.Tenant), cmp.Compare(a.Type, b.Type), cmp.Compare(a.Name, b.Name))
+	})
+	return changes
+}
+
+func formatGauge(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatMeta lists the set fields of meta, like type=counter unit=bytes.
+func formatMeta(meta models.MetricMeta) string {
+	var fields []string
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, key+"="+value)
+		}
+	}
+	add("type", meta.Type)
+	add("unit", meta.Unit)
+	if meta.Help != "" {
+		add("help", strconv.Quote(meta.Help))
+	}
+	add("owner", meta.Owner)
+	return strings.Join(fields, " ")
+}
+
+// loadBase reads the newest valid snapshot, or the plain file of earlier releases when
+// there is none, and returns the last WAL segment it covers.
+func loadBase(fp string) (map[string]*values, uint64, error) {
+	names, err := listSnapshots(fp)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(names) == 0 {
+		backed, err := loadLegacy(fp)
+		return backed, 0, err
+	}
+
+	errs := make([]error, 0, len(names))
+	for _, nm := range names {
+		s, err := readSnapshot(nm)
+		if err != nil {
+			logger.Log.Warnf("Skipping backup %s: %s", nm, err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", nm, err))
+			continue
+		}
+		backed := make(map[string]*values, len(s.Tenants))
+		for id, m := range s.Tenants {
+			if !tenant.Valid(id) {
+				return nil, 0, fmt.Errorf("%s: invalid tenant %q", nm, id)
+			}
+			backed[id] = m.values()
+		}
+		logger.Log.Infof("Restoring metrics from %s", nm)
+		return backed, s.WALSegment, nil
+	}
+	return nil, 0, fmt.Errorf("no valid backup: %w", errors.Join(errs...))
+}
+
+// applyWAL adds writes of the segments after covered to backed and returns the last
+// segment applied.
+func (b *MetricsBackup) applyWAL(fp string, covered uint64, backed map[string]*values) (uint64, error) {
+	var (
+		segments []walSegment
+		err      error
+	)
+	if b.wal != nil {
+		segments, err = b.wal.closedSegments()
+	} else {
+		segments, err = listSegments(fp)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	last := covered
+	for _, seg := range segments {
+		if seg.seq <= covered {
+			continue
+		}
+		if seg.seq != last+1 {
+			logger.Log.Warnf("WAL segments %d to %d are missing, writes in them are lost", last+1, seg.seq-1)
+		}
+		records, err := readSegment(seg.path)
+		if err != nil {
+			return 0, err
+		}
+		for _, rec := range records {
+			if !tenant.Valid(rec.Tenant) {
+				return 0, fmt.Errorf("%s: invalid tenant %q", seg.path, rec.Tenant)
+			}
+			v, ok := backed[rec.Tenant]
+			if !ok {
+				v = newValues()
+				backed[rec.Tenant] = v
+			}
+			if err = v.apply(rec); err != nil {
+				return 0, fmt.Errorf("%s: %w", seg.path, err)
+			}
+		}
+		last = seg.seq
+		logger.Log.Infof("Replaying %d writes from %s", len(records), seg.path)
+	}
+	return last, nil
+}
+
+// apply replays a WAL record. Admin changes of missing metrics failed when they were made
+// and change nothing.
+func (v *values) apply(rec walRecord) error {
+	gauge := rec.Type == string(handlers.GaugeType)
+	switch rec.Op {
+	case "":
+		for nm, g := range rec.Gauges {
+			v.gauges[nm] = g
+		}
+		for nm, c := range rec.Counters {
+			if rec.Set {
+				v.counters[nm] = c
+			} else {
+				v.counters[nm] += c
+			}
+		}
+	case walDelete:
+		if gauge {
+			delete(v.gauges, rec.Name)
+		} else {
+			delete(v.counters, rec.Name)
+		}
+	case walReset:
+		if _, ok := v.counters[rec.Name]; ok {
+			v.counters[rec.Name] = 0
+		}
+	case walMeta:
+		if rec.Meta == nil {
+			return fmt.Errorf("%w: meta record without metadata", ErrCorrupted)
+		}
+		v.meta[rec.Meta.Name] = *rec.Meta
+	case walRename:
+		if gauge {
+			if g, ok := v.gauges[rec.Name]; ok {
+				delete(v.gauges, rec.Name)
+				v.gauges[rec.To] = g
+			}
+		} else if c, ok := v.counters[rec.Name]; ok {
+			delete(v.counters, rec.Name)
+			v.counters[rec.To] += c
+		}
+	default:
+		return fmt.Errorf("%w: unknown operation %q", ErrCorrupted, rec.Op)
+	}
+	return nil
+}
+
+// values converts backed up metrics. Server metrics are skipped: clients may not write
+// them and the server starts counting anew.
+func (m *metrics) values() *values {
+	v := newValues()
+	for _, g := range m.Gauges {
+		if !selfmetrics.Reserved(g.Name) {
+			v.gauges[g.Name] = g.Value
+		}
+	}
+	for _, c := range m.Counters {
+		if !selfmetrics.Reserved(c.Name) {
+			v.counters[c.Name] = c.Value
+		}
+	}
+	for _, meta := range m.Meta {
+		if !selfmetrics.Reserved(meta.Name) {
+			v.meta[meta.Name] = *meta
+		}
+	}
+	return v
+}
+
+func gaugeList(gauges map[string]float64) []*models.GaugeMetric {
+	list := make([]*models.GaugeMetric, 0, len(gauges))
+	for nm, v := range gauges {
+		list = append(list, &models.GaugeMetric{Name: nm, Value: v})
+	}
+	return list
+}
+
+func counterList(counters map[string]int64) []*models.CounterMetric {
+	list := make([]*models.CounterMetric, 0, len(counters))
+	for nm, v := range counters {
+		list = append(list, &models.CounterMetric{Name: nm, Value: v})
+	}
+	return list
+}
+
+func readSnapshot(fp string) (s snapshot, err error) {
+	file, err := os.Open(fp)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if errClose := file.Close(); errClose != nil {
+			err = errors.Join(err, errClose)
+		}
+	}()
+	return decodeSnapshot(file)
+}
+
+// loadLegacy reads the plain JSON file that releases before snapshots wrote at fp. It held
+// the metrics of the default tenant only. A missing or empty file restores nothing.
+func loadLegacy(fp string) (map[string]*values, error) {
+	backed := make(map[string]*values)
+	m, err := readLegacyFile(fp)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to restore %s: %w", fp, err)
+	}
+	if m != nil {
+		logger.Log.Infof("Restoring metrics from %s written before snapshots", fp)
+		backed[tenant.Default] = m.values()
+	}
+	return backed, nil
+}
+
+// readLegacyFile returns nil metrics when the file does not exist.
+func readLegacyFile(fp string) (m *metrics, err error) {
+	file, err := os.Open(fp)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return
+	}
+	defer func() {
+		if errClose := file.Close(); errClose != nil {
+			err = errors.Join(err, errClose)
+		}
+	}()
+	m = new(metrics)
+	if err = json.NewDecoder(file).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,241 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var Compressions = []string{CompressionNone, CompressionGzip, CompressionZstd}
+
+// snapshotHeader starts every snapshot, followed by the compression and the sha256 of the
+// payload that comes after the header line.
+const snapshotHeader = "# metrics-backup v1"
+
+// snapshotTimeLayout sorts lexicographically in time order.
+const snapshotTimeLayout = "20060102T150405.000000000"
+
+var ErrCorrupted = errors.New("backup is corrupted")
+
+// snapshot holds metrics and their metadata of all tenants, so that a dump is consistent
+// across tenants.
+// WALSegment is the last WAL segment whose writes are included. Snapshots replace the
+// plain JSON file of earlier releases at the configured path, which is restored into the
+// default tenant until the first snapshot is written.
+type snapshot struct {
+	Tenants    map[string]*metrics `json:"tenants"`
+	WALSegment uint64              `json:"wal_segment,omitempty"`
+}
+
+func compressionSuffix(compression string) string {
+	switch compression {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	default:
+		return ""
+	}
+}
+
+// snapshotName inserts the time before the extension of fp: metrics.json becomes
+// metrics.20250101T000000.000000000.json.gz for gzip.
+func snapshotName(fp string, t time.Time, compression string) string {
+	ext := filepath.Ext(fp)
+	return strings.TrimSuffix(fp, ext) + "." + t.UTC().Format(snapshotTimeLayout) + ext + compressionSuffix(compression)
+}
+
+// listSnapshots returns snapshot files of fp in any compression, newest first.
+func listSnapshots(fp string) ([]string, error) {
+	dir := filepath.Dir(fp)
+	ext := filepath.Ext(fp)
+	prefix := strings.TrimSuffix(filepath.Base(fp), ext) + "."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		nm := e.Name()
+		rest, ok := strings.CutPrefix(nm, prefix)
+		if e.IsDir() || !ok {
+			continue
+		}
+		for _, c := range Compressions {
+			ts, ok := strings.CutSuffix(rest, ext+compressionSuffix(c))
+			if !ok {
+				continue
+			}
+			if _, err := time.Parse(snapshotTimeLayout, ts); err == nil {
+				names = append(names, nm)
+				break
+			}
+		}
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return strings.Compare(strings.TrimPrefix(b, prefix), strings.TrimPrefix(a, prefix))
+	})
+	for i, nm := range names {
+		names[i] = filepath.Join(dir, nm)
+	}
+	return names, nil
+}
+
+func encodeSnapshot(w io.Writer, s snapshot, compression string) error {
+	var payload bytes.Buffer
+	var zw io.WriteCloser
+	switch compression {
+	case CompressionGzip:
+		zw = gzip.NewWriter(&payload)
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(&payload)
+		if err != nil {
+			return err
+		}
+		zw = enc
+	default:
+		compression = CompressionNone
+		zw = nopCloser{&payload}
+	}
+	if err := json.NewEncoder(zw).Encode(s); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(payload.Bytes())
+	if _, err := fmt.Fprintf(w, "%s compression=%s sha256=%s\n", snapshotHeader, compression, hex.EncodeToString(sum[:])); err != nil {
+		return err
+	}
+	_, err := w.Write(payload.Bytes())
+	return err
+}
+
+func decodeSnapshot(r io.Reader) (snapshot, error) {
+	var s snapshot
+	br := bufio.NewReader(r)
+	header, err := br.ReadString('\n')
+	if err != nil {
+		return s, fmt.Errorf("%w: no header", ErrCorrupted)
+	}
+	compression, sum, err := parseHeader(strings.TrimSuffix(header, "\n"))
+	if err != nil {
+		return s, err
+	}
+	payload, err := io.ReadAll(br)
+	if err != nil {
+		return s, err
+	}
+	if got := sha256.Sum256(payload); hex.EncodeToString(got[:]) != sum {
+		return s, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+
+	var zr io.Reader = bytes.NewReader(payload)
+	switch compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(zr)
+		if err != nil {
+			return s, err
+		}
+		defer gz.Close()
+		zr = gz
+	case CompressionZstd:
+		dec, err := zstd.NewReader(zr)
+		if err != nil {
+			return s, err
+		}
+		defer dec.Close()
+		zr = dec
+	}
+	err = json.NewDecoder(zr).Decode(&s)
+	return s, err
+}
+
+func parseHeader(line string) (compression, sum string, err error) {
+	fields, ok := strings.CutPrefix(line, snapshotHeader+" ")
+	if !ok {
+		return "", "", fmt.Errorf("%w: unknown header %q", ErrCorrupted, line)
+	}
+	for _, f := range strings.Fields(fields) {
+		k, v, _ := strings.Cut(f, "=")
+		switch k {
+		case "compression":
+			compression = v
+		case "sha256":
+			sum = v
+		}
+	}
+	if !slices.Contains(Compressions, compression) || sum == "" {
+		return "", "", fmt.Errorf("%w: bad header %q", ErrCorrupted, line)
+	}
+	return compression, sum, nil
+}
+
+// writeFileAtomic writes to a temporary file in the target directory, syncs it and renames
+// it over fp, so readers see either the old or the complete new file.
+func writeFileAtomic(fp string, write func(io.Writer) error) (err error) {
+	dir := filepath.Dir(fp)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fp)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if errRemove := os.Remove(tmp.Name()); errRemove != nil && !errors.Is(errRemove, os.ErrNotExist) {
+			err = errors.Join(err, errRemove)
+		}
+	}()
+
+	if err = write(tmp); err != nil {
+		return errors.Join(err, tmp.Close())
+	}
+	if err = tmp.Sync(); err != nil {
+		return errors.Join(err, tmp.Close())
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), fp); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(d.Sync(), d.Close())
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
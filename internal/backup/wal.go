@@ -0,0 +1,339 @@
+package backup
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/handlers"
+	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
+)
+
+const walExt = ".wal"
+
+// WAL record operations besides writes, which have none.
+const (
+	walDelete = "delete"
+	walReset  = "reset"
+	walRename = "rename"
+	walMeta   = "meta"
+)
+
+// walRecord is one accepted change. A write has no Op, its counters hold deltas unless
+// Set is true. Admin changes name the metric of type Type, rename moves it to To. A meta
+// record stores Meta.
+type walRecord struct {
+	Tenant   string             `json:"t"`
+	Gauges   map[string]float64 `json:"g,omitempty"`
+	Counters map[string]int64   `json:"c,omitempty"`
+	Set      bool               `json:"s,omitempty"`
+	Op       string             `json:"o,omitempty"`
+	Type     string             `json:"y,omitempty"`
+	Name     string             `json:"n,omitempty"`
+	To       string             `json:"to,omitempty"`
+	Meta     *models.MetricMeta `json:"m,omitempty"`
+}
+
+type walSegment struct {
+	seq  uint64
+	path string
+}
+
+// WAL is an append-only log of writes accepted since the last snapshot, split into
+// numbered segments next to the backup path (metrics.json -> metrics.00000001.wal).
+// Appends are group committed: records that arrive while an fsync runs are written
+// and synced together by the next one.
+type WAL struct {
+	fp string
+
+	mu      sync.Mutex
+	pending []byte
+	waiters []chan error
+
+	fileMu sync.Mutex
+	file   *os.File
+	seq    uint64
+
+	kick chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// OpenWAL starts a new segment after the existing ones, which are left for replay.
+func OpenWAL(fp string) (*WAL, error) {
+	segments, err := listSegments(fp)
+	if err != nil {
+		return nil, err
+	}
+	w := &WAL{
+		fp:   fp,
+		kick: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	if len(segments) > 0 {
+		w.seq = segments[len(segments)-1].seq
+	}
+	if err = w.openNext(); err != nil {
+		return nil, err
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+func segmentPath(fp string, seq uint64) string {
+	ext := filepath.Ext(fp)
+	return fmt.Sprintf("%s.%08d%s", strings.TrimSuffix(fp, ext), seq, walExt)
+}
+
+// listSegments returns WAL segments of fp in order.
+func listSegments(fp string) ([]walSegment, error) {
+	dir := filepath.Dir(fp)
+	prefix := strings.TrimSuffix(filepath.Base(fp), filepath.Ext(fp)) + "."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var segments []walSegment
+	for _, e := range entries {
+		rest, ok := strings.CutPrefix(e.Name(), prefix)
+		if e.IsDir() || !ok {
+			continue
+		}
+		num, ok := strings.CutSuffix(rest, walExt)
+		if !ok {
+			continue
+		}
+		if seq, err := strconv.ParseUint(num, 10, 64); err == nil {
+			segments = append(segments, walSegment{seq: seq, path: filepath.Join(dir, e.Name())})
+		}
+	}
+	slices.SortFunc(segments, func(a, b walSegment) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	return segments, nil
+}
+
+// openNext must be called with fileMu held or before run starts.
+func (w *WAL) openNext() error {
+	file, err := os.OpenFile(segmentPath(w.fp, w.seq+1), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err = syncDir(filepath.Dir(w.fp)); err != nil {
+		return errors.Join(err, file.Close())
+	}
+	w.file = file
+	w.seq++
+	return nil
+}
+
+type replayKey struct{}
+
+// withReplay marks writes restored from snapshots or the log itself, they are durable
+// already and must not be logged again.
+func withReplay(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replayKey{}, true)
+}
+
+// Append logs counter deltas of a write applied to storage and returns once the record
+// is synced to disk. It does not give up on a canceled context: a record that is already
+// queued is synced regardless.
+func (w *WAL) Append(ctx context.Context, tenant string, gauges map[string]float64, counters map[string]int64) error {
+	return w.append(ctx, walRecord{Tenant: tenant, Gauges: gauges, Counters: counters})
+}
+
+// AppendSet logs absolute counter values, see Append.
+func (w *WAL) AppendSet(ctx context.Context, tenant string, gauges map[string]float64, counters map[string]int64) error {
+	return w.append(ctx, walRecord{Tenant: tenant, Gauges: gauges, Counters: counters, Set: true})
+}
+
+// AppendDelete logs the deletion of a metric of type tp, see Append.
+func (w *WAL) AppendDelete(ctx context.Context, tenant, tp, name string) error {
+	return w.append(ctx, walRecord{Tenant: tenant, Op: walDelete, Type: tp, Name: name})
+}
+
+// AppendReset logs a counter reset, see Append.
+func (w *WAL) AppendReset(ctx context.Context, tenant, name string) error {
+	return w.append(ctx, walRecord{Tenant: tenant, Op: walReset, Type: string(handlers.CounterType), Name: name})
+}
+
+// AppendRename logs a rename of a metric of type tp, see Append.
+func (w *WAL) AppendRename(ctx context.Context, tenant, tp, from, to string) error {
+	return w.append(ctx, walRecord{Tenant: tenant, Op: walRename, Type: tp, Name: from, To: to})
+}
+
+// AppendMeta logs declared metadata, see Append.
+func (w *WAL) AppendMeta(ctx context.Context, tenant string, meta *models.MetricMeta) error {
+	return w.append(ctx, walRecord{Tenant: tenant, Op: walMeta, Meta: meta})
+}
+
+func (w *WAL) append(ctx context.Context, rec walRecord) error {
+	if ctx.Value(replayKey{}) != nil {
+		return nil
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	w.mu.Lock()
+	w.pending = fmt.Appendf(w.pending, "%08x %s\n", crc32.ChecksumIEEE(data), data)
+	w.waiters = append(w.waiters, done)
+	w.mu.Unlock()
+
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+	return <-done
+}
+
+func (w *WAL) run() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.kick:
+			w.commit()
+		case <-w.done:
+			w.commit()
+			return
+		}
+	}
+}
+
+func (w *WAL) commit() {
+	w.mu.Lock()
+	batch, waiters := w.pending, w.waiters
+	w.pending, w.waiters = nil, nil
+	w.mu.Unlock()
+	if len(waiters) == 0 {
+		return
+	}
+
+	done := selfmetrics.Default.Time("wal.fsync")
+	w.fileMu.Lock()
+	_, err := w.file.Write(batch)
+	if err == nil {
+		err = w.file.Sync()
+	}
+	w.fileMu.Unlock()
+	done()
+	selfmetrics.Default.Add("wal.commits", 1)
+	selfmetrics.Default.Add("wal.records", int64(len(waiters)))
+
+	for _, c := range waiters {
+		c <- err
+	}
+}
+
+// Rotate closes the current segment and returns its number. Callers make sure no
+// Append is in progress, so the closed segment holds every write made before.
+func (w *WAL) Rotate() (uint64, error) {
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+	seq := w.seq
+	if err := w.file.Close(); err != nil {
+		return 0, err
+	}
+	if err := w.openNext(); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// closedSegments returns the segments before the one being written.
+func (w *WAL) closedSegments() ([]walSegment, error) {
+	segments, err := listSegments(w.fp)
+	if err != nil {
+		return nil, err
+	}
+	w.fileMu.Lock()
+	current := w.seq
+	w.fileMu.Unlock()
+	return slices.DeleteFunc(segments, func(s walSegment) bool {
+		return s.seq >= current
+	}), nil
+}
+
+func (w *WAL) Close() error {
+	close(w.done)
+	w.wg.Wait()
+	w.fileMu.Lock()
+	defer w.fileMu.Unlock()
+	return w.file.Close()
+}
+
+// removeSegments deletes segments of fp up to and including seq, they are in a snapshot.
+func removeSegments(fp string, seq uint64) error {
+	segments, err := listSegments(fp)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, s := range segments {
+		if s.seq <= seq {
+			errs = append(errs, os.Remove(s.path))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readSegment returns the records of a segment. A broken last line is a write that was
+// never acknowledged and is dropped; a broken line before it means corruption.
+func readSegment(path string) ([]walRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []walRecord
+	lines := bytes.Split(data, []byte{'\n'})
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		rec, err := parseRecord(line)
+		if err != nil {
+			if i == len(lines)-1 {
+				logger.Log.Warnf("Dropping incomplete last record of %s", path)
+				break
+			}
+			return nil, fmt.Errorf("%w: %s line %d: %s", ErrCorrupted, path, i+1, err.Error())
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func parseRecord(line []byte) (walRecord, error) {
+	var rec walRecord
+	sum, data, ok := bytes.Cut(line, []byte{' '})
+	if !ok {
+		return rec, errors.New("no checksum")
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil {
+		return rec, err
+	}
+	if crc32.ChecksumIEEE(data) != uint32(want) {
+		return rec, errors.New("checksum mismatch")
+	}
+	err = json.Unmarshal(data, &rec)
+	return rec, err
+}
@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/services"
+	"github.com/volchkovski/go-practicum-metrics/internal/storage/mem"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+// startWAL opens the log at fp with a service writing through it, like the server does.
+func startWAL(t *testing.T, fp string) (*WAL, *services.MetricService, *MetricsBackup) {
+	t.Helper()
+	wal, err := OpenWAL(fp)
+	require.NoError(t, err)
+	service := services.NewMetricService(mem.NewMemStorage(), services.WithWriteLog(wal))
+	b := NewMetricsBackup(service, fp, 0, WithWAL(wal, time.Minute))
+	require.NoError(t, b.Restore())
+	return wal, service, b
+}
+
+func TestWALReplay(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "metrics.json")
+	ctx := context.Background()
+
+	wal, service, b := startWAL(t, fp)
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, service.PushCounterMetric(ctx, &models.CounterMetric{Name: "c", Value: 1}))
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, service.PushMetrics(tenant.WithTenant(ctx, "team"),
+		[]*models.GaugeMetric{{Name: "g", Value: 2.5}}, []*models.CounterMetric{{Name: "c", Value: 7}}))
+
+	require.NoError(t, b.dumpMetrics(), "checkpoint")
+	require.NoError(t, service.PushCounterMetric(ctx, &models.CounterMetric{Name: "c", Value: 10}))
+	require.NoError(t, wal.Close(), "crash after the write is acknowledged")
+
+	segments, err := listSegments(fp)
+	require.NoError(t, err)
+	require.Len(t, segments, 1, "segments in the snapshot are removed")
+
+	wal, service, _ = startWAL(t, fp)
+	defer wal.Close()
+	counter, err := service.GetCounterMetric(ctx, "c")
+	require.NoError(t, err)
+	assert.Equal(t, int64(60), counter.Value)
+	gauge, err := service.GetGaugeMetric(tenant.WithTenant(ctx, "team"), "g")
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, gauge.Value)
+
+	records, err := readSegment(segmentPath(fp, wal.seq))
+	require.NoError(t, err)
+	assert.Empty(t, records, "restored writes are not logged again")
+}
+
+func TestWALTornRecord(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "metrics.json")
+	ctx := context.Background()
+
+	wal, service, _ := startWAL(t, fp)
+	require.NoError(t, service.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 1}))
+	require.NoError(t, wal.Close())
+
+	path := segmentPath(fp, 1)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = file.WriteString(`1234abcd {"t":"default","g":{"g":`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	wal, service, _ = startWAL(t, fp)
+	defer wal.Close()
+	gauge, err := service.GetGaugeMetric(ctx, "g")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, gauge.Value)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, append([]byte("garbage\n"), data...), 0o600))
+	_, err = readSegment(path)
+	assert.ErrorIs(t, err, ErrCorrupted)
+}
+
+func TestWALReplayAdminChanges(t *testing.T) {
+	fp := filepath.Join(t.TempDir(), "metrics.json")
+	ctx := context.Background()
+
+	wal, service, _ := startWAL(t, fp)
+	require.NoError(t, service.PushMetrics(ctx,
+		[]*models.GaugeMetric{{Name: "g1", Value: 1}, {Name: "g2", Value: 2}},
+		[]*models.CounterMetric{{Name: "c1", Value: 3}, {Name: "c2", Value: 4}, {Name: "c3", Value: 5}},
+	))
+	require.NoError(t, service.DeleteGaugeMetric(ctx, "g1"))
+	require.NoError(t, service.RenameGaugeMetric(ctx, "g2", "g3"))
+	require.NoError(t, service.ResetCounterMetric(ctx, "c1"))
+	require.NoError(t, service.RenameCounterMetric(ctx, "c2", "c3"))
+	require.ErrorIs(t, service.DeleteCounterMetric(ctx, "c2"), models.ErrMetricNotFound, "gone after the rename")
+	require.NoError(t, service.PutMetricMeta(ctx, &models.MetricMeta{Name: "c3", Type: "counter", Unit: "requests"}))
+	require.NoError(t, wal.Close())
+
+	wal, service, _ = startWAL(t, fp)
+	defer wal.Close()
+	gauges, err := service.GetAllGaugeMetrics(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*models.GaugeMetric{{Name: "g3", Value: 2}}, gauges)
+	counters, err := service.GetAllCounterMetrics(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*models.CounterMetric{{Name: "c1", Value: 0}, {Name: "c3", Value: 9}}, counters)
+	meta, err := service.GetMetricMeta(ctx, "c3")
+	require.NoError(t, err)
+	assert.Equal(t, &models.MetricMeta{Name: "c3", Type: "counter", Unit: "requests"}, meta)
+}
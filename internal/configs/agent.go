@@ -1,30 +1,174 @@
 package configs
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net"
+	"os"
+	"path"
+	"slices"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
 
+var (
+	AgentModes        = []string{"failover", "fanout"}
+	AgentCollectors   = []string{"runtime", "random", "pollcount"}
+	AgentCompressions = []string{"gzip", "none"}
+)
+
 type AgentConfig struct {
-	ServerAddr string `env:"ADDRESS"`
-	ReportIntr int    `env:"REPORT_INTERVAL"`
-	PollIntr   int    `env:"POLL_INTERVAL"`
+	ConfigFile string           `json:"-" yaml:"-" env:"CONFIG"`
+	Servers    List             `json:"servers" yaml:"servers" env:"ADDRESS" envSeparator:","`
+	Mode       string           `json:"mode" yaml:"mode" env:"SERVERS_MODE"`
+	ProbeIntr  Duration         `json:"probe_interval" yaml:"probe_interval" env:"PROBE_INTERVAL"`
+	SpoolSize  int              `json:"spool_size" yaml:"spool_size" env:"SPOOL_SIZE"`
+	ReportIntr Duration         `json:"report_interval" yaml:"report_interval" env:"REPORT_INTERVAL"`
+	PollIntr   Duration         `json:"poll_interval" yaml:"poll_interval" env:"POLL_INTERVAL"`
+	Jitter     Duration         `json:"jitter" yaml:"jitter" env:"JITTER"`
+	BackoffMax Duration         `json:"backoff_max" yaml:"backoff_max" env:"BACKOFF_MAX"`
+	Collectors List             `json:"collectors" yaml:"collectors" env:"COLLECTORS" envSeparator:","`
+	Allow      List             `json:"allow" yaml:"allow" env:"ALLOW" envSeparator:","`
+	Deny       List             `json:"deny" yaml:"deny" env:"DENY" envSeparator:","`
+	Relabel    []RelabelRule    `json:"relabel" yaml:"relabel"`
+	DryRun     bool             `json:"dry_run" yaml:"dry_run" env:"DRY_RUN"`
+	HTTP       HTTPClientConfig `json:"http" yaml:"http"`
+	Token      string           `json:"token" yaml:"token" env:"TOKEN"`
+	TLSCA      string           `json:"tls_ca" yaml:"tls_ca" env:"TLS_CA"`
+	TLSCert    string           `json:"tls_cert" yaml:"tls_cert" env:"TLS_CERT"`
+	TLSKey     string           `json:"tls_key" yaml:"tls_key" env:"TLS_KEY"`
+	Listen     string           `json:"listen" yaml:"listen" env:"LISTEN"`
+	Prefixes   Prefixes         `json:"source_prefixes" yaml:"source_prefixes" env:"SOURCE_PREFIXES"`
+}
+
+type HTTPClientConfig struct {
+	Timeout     Duration `json:"timeout" yaml:"timeout" env:"HTTP_TIMEOUT"`
+	Retries     int      `json:"retries" yaml:"retries" env:"HTTP_RETRIES"`
+	Compression string   `json:"compression" yaml:"compression" env:"HTTP_COMPRESSION"`
 }
 
+// NewAgentConfig builds the config from defaults, the config file, env and flags,
+// each source overriding the previous one.
 func NewAgentConfig() (*AgentConfig, error) {
 	cfg := new(AgentConfig)
-	parseAgentFlags(cfg)
+	explicit := parseAgentFlags(cfg)
+
+	if cfg.ConfigFile == "" {
+		cfg.ConfigFile = os.Getenv("CONFIG")
+	}
+	if cfg.ConfigFile != "" {
+		if err := loadFile(cfg.ConfigFile, cfg); err != nil {
+			return nil, fmt.Errorf("agent config error: %w", err)
+		}
+	}
 	if err := env.Parse(cfg); err != nil {
 		return nil, fmt.Errorf("agent config error: %w", err)
 	}
+	if err := explicit.apply(flag.CommandLine); err != nil {
+		return nil, fmt.Errorf("agent config error: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("agent config error: %w", err)
+	}
 	return cfg, nil
 }
 
-func parseAgentFlags(cfg *AgentConfig) {
-	flag.StringVar(&cfg.ServerAddr, "a", "localhost:8080", "server address and port to push")
-	flag.IntVar(&cfg.ReportIntr, "r", 10, "each time to report metrics")
-	flag.IntVar(&cfg.PollIntr, "p", 2, "each time to poll metrics")
+// Validate reports every invalid setting at once.
+func (cfg *AgentConfig) Validate() error {
+	var errs []error
+	if len(cfg.Servers) == 0 {
+		errs = append(errs, errors.New("servers: at least one server address is required"))
+	}
+	for _, addr := range cfg.Servers {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			errs = append(errs, fmt.Errorf("servers: %q must be host:port: %w", addr, err))
+		}
+	}
+	if !slices.Contains(AgentModes, cfg.Mode) {
+		errs = append(errs, fmt.Errorf("mode: unknown %q, known: %v", cfg.Mode, AgentModes))
+	}
+	if cfg.ProbeIntr <= 0 {
+		errs = append(errs, fmt.Errorf("probe_interval: must be positive, got %s", cfg.ProbeIntr.Duration()))
+	}
+	if cfg.SpoolSize < 0 {
+		errs = append(errs, fmt.Errorf("spool_size: must not be negative, got %d", cfg.SpoolSize))
+	}
+	if cfg.ReportIntr <= 0 {
+		errs = append(errs, fmt.Errorf("report_interval: must be positive, got %s", cfg.ReportIntr.Duration()))
+	}
+	if cfg.PollIntr <= 0 {
+		errs = append(errs, fmt.Errorf("poll_interval: must be positive, got %s", cfg.PollIntr.Duration()))
+	}
+	if cfg.Jitter < 0 {
+		errs = append(errs, fmt.Errorf("jitter: must not be negative, got %s", cfg.Jitter.Duration()))
+	}
+	if cfg.BackoffMax <= 0 {
+		errs = append(errs, fmt.Errorf("backoff_max: must be positive, got %s", cfg.BackoffMax.Duration()))
+	}
+	for _, c := range cfg.Collectors {
+		if !slices.Contains(AgentCollectors, c) {
+			errs = append(errs, fmt.Errorf("collectors: unknown collector %q, known: %v", c, AgentCollectors))
+		}
+	}
+	for _, p := range append(slices.Clone(cfg.Allow), cfg.Deny...) {
+		if _, err := path.Match(p, ""); err != nil {
+			errs = append(errs, fmt.Errorf("allow/deny: bad pattern %q: %w", p, err))
+		}
+	}
+	for i, rule := range cfg.Relabel {
+		errs = append(errs, rule.validate(i)...)
+	}
+	if cfg.HTTP.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("http.timeout: must not be negative, got %s", cfg.HTTP.Timeout.Duration()))
+	}
+	if cfg.HTTP.Retries < 0 {
+		errs = append(errs, fmt.Errorf("http.retries: must not be negative, got %d", cfg.HTTP.Retries))
+	}
+	if !slices.Contains(AgentCompressions, cfg.HTTP.Compression) {
+		errs = append(errs, fmt.Errorf("http.compression: unknown %q, known: %v", cfg.HTTP.Compression, AgentCompressions))
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key must be set together"))
+	}
+	return errors.Join(errs...)
+}
+
+func parseAgentFlags(cfg *AgentConfig) explicitFlags {
+	cfg.Servers = List{"localhost:8080"}
+	cfg.Mode = "failover"
+	cfg.ProbeIntr = Duration(30 * time.Second)
+	cfg.SpoolSize = 100
+	cfg.ReportIntr = Duration(10 * time.Second)
+	cfg.PollIntr = Duration(2 * time.Second)
+	cfg.Jitter = Duration(1 * time.Second)
+	cfg.BackoffMax = Duration(5 * time.Minute)
+	cfg.Collectors = slices.Clone(AgentCollectors)
+	cfg.HTTP = HTTPClientConfig{Timeout: Duration(10 * time.Second), Retries: 3, Compression: "gzip"}
+
+	flag.StringVar(&cfg.ConfigFile, "c", "", "JSON or YAML config file")
+	flag.Var(&cfg.Servers, "a", "server addresses and ports to push, comma-separated")
+	flag.StringVar(&cfg.Mode, "mode", cfg.Mode, "delivery to multiple servers: failover, fanout")
+	flag.Var(&cfg.ProbeIntr, "probe-interval", "each time to probe preferred servers in failover mode")
+	flag.IntVar(&cfg.SpoolSize, "spool-size", cfg.SpoolSize, "undelivered batches kept per server, 0 is unlimited")
+	flag.Var(&cfg.ReportIntr, "r", "each time to report metrics, duration or seconds")
+	flag.Var(&cfg.PollIntr, "p", "each time to poll metrics, duration or seconds")
+	flag.Var(&cfg.Jitter, "jitter", "random delay up to this added on start and to every report, 0 disables")
+	flag.Var(&cfg.BackoffMax, "backoff-max", "longest wait between retries and reports after repeated failures")
+	flag.Var(&cfg.Collectors, "collectors", "enabled collectors, comma-separated: runtime, random, pollcount")
+	flag.Var(&cfg.Allow, "allow", "glob patterns of metric names to send, comma-separated, all when empty")
+	flag.Var(&cfg.Deny, "deny", "glob patterns of metric names not to send, comma-separated")
+	flag.BoolVar(&cfg.DryRun, "dry-run", false, "print metrics that would be sent instead of sending them")
+	flag.Var(&cfg.HTTP.Timeout, "http-timeout", "timeout of a single request to the server")
+	flag.IntVar(&cfg.HTTP.Retries, "http-retries", cfg.HTTP.Retries, "retries of a failed request to the server")
+	flag.StringVar(&cfg.HTTP.Compression, "http-compression", cfg.HTTP.Compression, "request body compression: gzip, none")
+	flag.StringVar(&cfg.Token, "token", "", "bearer token for server authentication")
+	flag.StringVar(&cfg.TLSCA, "tls-ca", "", "CA bundle to pin the server certificate to, enables HTTPS")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", "", "client certificate file for mutual TLS, enables HTTPS")
+	flag.StringVar(&cfg.TLSKey, "tls-key", "", "client private key file")
+	flag.StringVar(&cfg.Listen, "listen", "", "local address (host:port or unix:/path) accepting custom metrics, disabled when empty")
+	flag.Var(&cfg.Prefixes, "source-prefixes", "metric name prefixes per source as source=prefix, comma-separated")
 	flag.Parse()
+	return visitedFlags(flag.CommandLine)
 }
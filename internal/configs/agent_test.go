@@ -0,0 +1,119 @@
+package configs
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAgentConfig(t *testing.T, args ...string) (*AgentConfig, error) {
+	t.Helper()
+	oldArgs, oldFlags := os.Args, flag.CommandLine
+	t.Cleanup(func() {
+		os.Args, flag.CommandLine = oldArgs, oldFlags
+	})
+	os.Args = append([]string{"agent"}, args...)
+	flag.CommandLine = flag.NewFlagSet("agent", flag.ContinueOnError)
+	return NewAgentConfig()
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+	return p
+}
+
+func TestAgentConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "agent.yaml",
+			content: `
+servers: [metrics-1:8080, metrics-2:8080]
+report_interval: 30s
+poll_interval: 5
+collectors: [runtime]
+deny: ["Heap*"]
+http:
+  timeout: 2s
+  compression: none
+`,
+		},
+		{
+			name: "json",
+			file: "agent.json",
+			content: `{
+				"servers": ["metrics-1:8080", "metrics-2:8080"],
+				"report_interval": "30s",
+				"poll_interval": 5,
+				"collectors": ["runtime"],
+				"deny": ["Heap*"],
+				"http": {"timeout": "2s", "compression": "none"}
+			}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := newTestAgentConfig(t, "-c", writeConfig(t, tc.file, tc.content))
+			require.NoError(t, err)
+			assert.Equal(t, List{"metrics-1:8080", "metrics-2:8080"}, cfg.Servers)
+			assert.Equal(t, 30*time.Second, cfg.ReportIntr.Duration())
+			assert.Equal(t, 5*time.Second, cfg.PollIntr.Duration())
+			assert.Equal(t, List{"runtime"}, cfg.Collectors)
+			assert.Equal(t, List{"Heap*"}, cfg.Deny)
+			assert.Equal(t, 2*time.Second, cfg.HTTP.Timeout.Duration())
+			assert.Equal(t, 3, cfg.HTTP.Retries)
+			assert.Equal(t, "none", cfg.HTTP.Compression)
+		})
+	}
+}
+
+func TestAgentConfigPrecedence(t *testing.T) {
+	path := writeConfig(t, "agent.yaml", "servers: [file:8080]\nreport_interval: 30s\npoll_interval: 3s\n")
+	t.Setenv("CONFIG", path)
+	t.Setenv("REPORT_INTERVAL", "20s")
+	t.Setenv("POLL_INTERVAL", "4s")
+
+	cfg, err := newTestAgentConfig(t, "-r", "15")
+	require.NoError(t, err)
+	assert.Equal(t, List{"file:8080"}, cfg.Servers)
+	assert.Equal(t, 15*time.Second, cfg.ReportIntr.Duration())
+	assert.Equal(t, 4*time.Second, cfg.PollIntr.Duration())
+}
+
+func TestAgentConfigValidation(t *testing.T) {
+	path := writeConfig(t, "agent.yaml", `
+servers: [no-port]
+report_interval: -1s
+collectors: [runtime, disk]
+allow: ["["]
+relabel:
+  - {action: move}
+  - {action: rename, regex: "("}
+http:
+  compression: brotli
+`)
+	_, err := newTestAgentConfig(t, "-c", path)
+	require.Error(t, err)
+	for _, part := range []string{"servers", "report_interval", "collectors", "allow/deny", "http.compression",
+		"relabel[0].action", "relabel[1].regex", "relabel[1].replacement"} {
+		assert.ErrorContains(t, err, part)
+	}
+}
+
+func TestAgentConfigUnknownKey(t *testing.T) {
+	path := writeConfig(t, "agent.json", `{"server": "localhost:8080"}`)
+	_, err := newTestAgentConfig(t, "-c", path)
+	assert.ErrorContains(t, err, "unknown field")
+}
@@ -0,0 +1,97 @@
+package configs
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+)
+
+var CtlOutputs = []string{"table", "json"}
+
+type CtlConfig struct {
+	ConfigFile string           `json:"-" yaml:"-" env:"CONFIG"`
+	Addr       string           `json:"address" yaml:"address" env:"ADDRESS"`
+	Tenant     string           `json:"tenant" yaml:"tenant" env:"TENANT"`
+	Output     string           `json:"output" yaml:"output" env:"OUTPUT"`
+	HTTP       HTTPClientConfig `json:"http" yaml:"http"`
+	Token      string           `json:"token" yaml:"token" env:"TOKEN"`
+	TLSCA      string           `json:"tls_ca" yaml:"tls_ca" env:"TLS_CA"`
+	TLSCert    string           `json:"tls_cert" yaml:"tls_cert" env:"TLS_CERT"`
+	TLSKey     string           `json:"tls_key" yaml:"tls_key" env:"TLS_KEY"`
+}
+
+// NewCtlConfig builds the config from defaults, the config file, env and flags,
+// each source overriding the previous one. Arguments after the flags are left in flag.Args.
+func NewCtlConfig() (*CtlConfig, error) {
+	cfg := new(CtlConfig)
+	explicit := parseCtlFlags(cfg)
+
+	if cfg.ConfigFile == "" {
+		cfg.ConfigFile = os.Getenv("CONFIG")
+	}
+	if cfg.ConfigFile != "" {
+		if err := loadFile(cfg.ConfigFile, cfg); err != nil {
+			return nil, fmt.Errorf("metricsctl config error: %w", err)
+		}
+	}
+	if err := env.Parse(cfg); err != nil {
+		return nil, fmt.Errorf("metricsctl config error: %w", err)
+	}
+	if err := explicit.apply(flag.CommandLine); err != nil {
+		return nil, fmt.Errorf("metricsctl config error: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("metricsctl config error: %w", err)
+	}
+	return cfg, nil
+}
+
+// Validate reports every invalid setting at once.
+func (cfg *CtlConfig) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("address: %q must be host:port: %w", cfg.Addr, err))
+	}
+	if !slices.Contains(CtlOutputs, cfg.Output) {
+		errs = append(errs, fmt.Errorf("output: unknown %q, known: %v", cfg.Output, CtlOutputs))
+	}
+	if cfg.HTTP.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("http.timeout: must be positive, got %s", cfg.HTTP.Timeout.Duration()))
+	}
+	if cfg.HTTP.Retries < 0 {
+		errs = append(errs, fmt.Errorf("http.retries: must not be negative, got %d", cfg.HTTP.Retries))
+	}
+	if !slices.Contains(AgentCompressions, cfg.HTTP.Compression) {
+		errs = append(errs, fmt.Errorf("http.compression: unknown %q, known: %v", cfg.HTTP.Compression, AgentCompressions))
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key must be set together"))
+	}
+	return errors.Join(errs...)
+}
+
+func parseCtlFlags(cfg *CtlConfig) explicitFlags {
+	cfg.Addr = "localhost:8080"
+	cfg.Output = "table"
+	cfg.HTTP = HTTPClientConfig{Timeout: Duration(10 * time.Second), Retries: 3, Compression: "gzip"}
+
+	flag.StringVar(&cfg.ConfigFile, "c", "", "JSON or YAML config file")
+	flag.StringVar(&cfg.Addr, "a", cfg.Addr, "server address and port")
+	flag.StringVar(&cfg.Tenant, "tenant", "", "tenant to operate on, the token tenant or the default one when empty; others need a token with the any-tenant scope")
+	flag.StringVar(&cfg.Output, "o", cfg.Output, "output format: table, json")
+	flag.Var(&cfg.HTTP.Timeout, "http-timeout", "timeout of a single request to the server")
+	flag.IntVar(&cfg.HTTP.Retries, "http-retries", cfg.HTTP.Retries, "retries of a failed request to the server")
+	flag.StringVar(&cfg.HTTP.Compression, "http-compression", cfg.HTTP.Compression, "request body compression: gzip, none")
+	flag.StringVar(&cfg.Token, "token", "", "bearer token for server authentication")
+	flag.StringVar(&cfg.TLSCA, "tls-ca", "", "CA bundle to pin the server certificate to, enables HTTPS")
+	flag.StringVar(&cfg.TLSCert, "tls-cert", "", "client certificate file for mutual TLS, enables HTTPS")
+	flag.StringVar(&cfg.TLSKey, "tls-key", "", "client private key file")
+	flag.Parse()
+	return visitedFlags(flag.CommandLine)
+}
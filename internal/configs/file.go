@@ -0,0 +1,63 @@
+package configs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// loadFile decodes a JSON or YAML config file, chosen by extension, into cfg.
+// Unknown keys are rejected so that typos do not pass silently.
+func loadFile(path string, cfg any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(cfg)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(cfg); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported format %q, use .json, .yaml or .yml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// explicitFlags remembers flags given on the command line by name, so that they can be
+// re-applied over values from the config file and env, also to a freshly built config.
+type explicitFlags map[string]string
+
+func visitedFlags(fs *flag.FlagSet) explicitFlags {
+	explicit := explicitFlags{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+	return explicit
+}
+
+func (e explicitFlags) apply(fs *flag.FlagSet) error {
+	for name, v := range e {
+		if err := fs.Set(name, v); err != nil {
+			return fmt.Errorf("flag -%s: %w", name, err)
+		}
+	}
+	return nil
+}
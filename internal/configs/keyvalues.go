@@ -0,0 +1,75 @@
+package configs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Prefixes maps metric sources to the prefix of their metric names, written as "billing=billing.,web=web.".
+type Prefixes map[string]string
+
+func (p *Prefixes) String() string {
+	if p == nil {
+		return ""
+	}
+	return formatKeyValues(*p)
+}
+
+func (p *Prefixes) Set(s string) error {
+	return p.UnmarshalText([]byte(s))
+}
+
+func (p *Prefixes) UnmarshalText(text []byte) error {
+	kv, err := parseKeyValues(string(text), "source=prefix")
+	if err != nil {
+		return err
+	}
+	*p = kv
+	return nil
+}
+
+// UnmarshalJSON accepts both an object and the "source=prefix,..." string form.
+func (p *Prefixes) UnmarshalJSON(data []byte) error {
+	kv, err := unmarshalKeyValuesJSON(data, "source=prefix")
+	if err != nil {
+		return err
+	}
+	*p = kv
+	return nil
+}
+
+func formatKeyValues(kv map[string]string) string {
+	parts := make([]string, 0, len(kv))
+	for k, v := range kv {
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseKeyValues(s, form string) (map[string]string, error) {
+	kv := map[string]string{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(part, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%q must be in %s form", part, form)
+		}
+		kv[k] = v
+	}
+	return kv, nil
+}
+
+func unmarshalKeyValuesJSON(data []byte, form string) (map[string]string, error) {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return parseKeyValues(s, form)
+	}
+	kv := map[string]string{}
+	if err := json.Unmarshal(data, &kv); err != nil {
+		return nil, err
+	}
+	return kv, nil
+}
@@ -0,0 +1,24 @@
+package configs
+
+import "strings"
+
+// List is a comma-separated flag value.
+type List []string
+
+func (l *List) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *List) Set(s string) error {
+	items := List{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*l = items
+	return nil
+}
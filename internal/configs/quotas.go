@@ -0,0 +1,60 @@
+package configs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Quotas maps tenants to their series limit, written as "team-a=100,team-b=500".
+type Quotas map[string]int
+
+func (q *Quotas) String() string {
+	if q == nil {
+		return ""
+	}
+	parts := make([]string, 0, len(*q))
+	for id, v := range *q {
+		parts = append(parts, id+"="+strconv.Itoa(v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (q *Quotas) Set(s string) error {
+	return q.UnmarshalText([]byte(s))
+}
+
+func (q *Quotas) UnmarshalText(text []byte) error {
+	quotas := Quotas{}
+	for _, part := range strings.Split(string(text), ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, v, ok := strings.Cut(part, "=")
+		if !ok {
+			return fmt.Errorf("quota %q must be in tenant=limit form", part)
+		}
+		limit, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("quota for tenant %s must be integer: %w", id, err)
+		}
+		quotas[id] = limit
+	}
+	*q = quotas
+	return nil
+}
+
+// UnmarshalJSON accepts both an object and the "tenant=limit,..." string form.
+func (q *Quotas) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return q.UnmarshalText([]byte(s))
+	}
+	quotas := map[string]int{}
+	if err := json.Unmarshal(data, &quotas); err != nil {
+		return err
+	}
+	*q = quotas
+	return nil
+}
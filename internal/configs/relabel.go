@@ -0,0 +1,49 @@
+package configs
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+var RelabelActions = []string{"keep", "drop", "rename", "prefix"}
+
+// RelabelRule changes metrics whose name fully matches Regex, an empty regex matches all:
+// keep drops everything else, drop removes matches, rename replaces the name with
+// Replacement ($1 refers to groups) and prefix prepends Prefix. Rules run after the allow
+// and deny lists, which are keep and drop rules with glob patterns.
+type RelabelRule struct {
+	Action      string `json:"action" yaml:"action"`
+	Regex       string `json:"regex" yaml:"regex"`
+	Replacement string `json:"replacement" yaml:"replacement"`
+	Prefix      string `json:"prefix" yaml:"prefix"`
+}
+
+// Compile returns the anchored regex of the rule.
+func (r RelabelRule) Compile() (*regexp.Regexp, error) {
+	if r.Regex == "" {
+		return regexp.Compile(".*")
+	}
+	return regexp.Compile("^(?:" + r.Regex + ")$")
+}
+
+func (r RelabelRule) validate(i int) []error {
+	var errs []error
+	if !slices.Contains(RelabelActions, r.Action) {
+		errs = append(errs, fmt.Errorf("relabel[%d].action: unknown %q, known: %v", i, r.Action, RelabelActions))
+	}
+	if _, err := r.Compile(); err != nil {
+		errs = append(errs, fmt.Errorf("relabel[%d].regex: %w", i, err))
+	}
+	switch r.Action {
+	case "rename":
+		if r.Replacement == "" {
+			errs = append(errs, fmt.Errorf("relabel[%d].replacement: required for rename", i))
+		}
+	case "prefix":
+		if r.Prefix == "" {
+			errs = append(errs, fmt.Errorf("relabel[%d].prefix: required for prefix", i))
+		}
+	}
+	return errs
+}
@@ -1,38 +1,309 @@
 package configs
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"slices"
+	"time"
 
 	"github.com/caarlos0/env/v6"
+	"go.uber.org/zap"
 )
 
+var (
+	ServerEnvs               = []string{"prod", "local"}
+	ServerRateLimitBy        = []string{"ip", "token"}
+	ServerBackupCompressions = []string{"none", "gzip", "zstd"}
+	ServerRestoreModes       = []string{"replace", "merge-max", "add"}
+	ServerDBStatementCaches  = []string{"statement", "describe", "disabled"}
+)
+
+// serverReloadable lists settings that are re-applied on SIGHUP without a restart.
+var serverReloadable = []string{"LogLevel", "StoreIntr", "FileStoragePath"}
+
 type ServerConfig struct {
-	Addr            string `env:"ADDRESS"`
-	StoreIntr       int    `env:"STORE_INTERVAL"`
-	FileStoragePath string `env:"FILE_STORAGE_PATH"`
-	Restore         bool   `env:"RESTORE"`
-	LogLevel        string `env:"LOG_LEVEL"`
-	Env             string `env:"ENVIRONMENT"`
-	DSN             string `env:"DATABASE_DSN"`
+	ConfigFile        string   `json:"-" yaml:"-" env:"CONFIG"`
+	Addr              string   `json:"address" yaml:"address" env:"ADDRESS"`
+	StoreIntr         Duration `json:"store_interval" yaml:"store_interval" env:"STORE_INTERVAL"`
+	FileStoragePath   string   `json:"file_storage_path" yaml:"file_storage_path" env:"FILE_STORAGE_PATH"`
+	Restore           bool     `json:"restore" yaml:"restore" env:"RESTORE"`
+	RestoreMode       string   `json:"restore_mode" yaml:"restore_mode" env:"RESTORE_MODE"`
+	RestoreDryRun     bool     `json:"-" yaml:"-" env:"RESTORE_DRY_RUN"`
+	BackupKeep        int      `json:"backup_keep" yaml:"backup_keep" env:"BACKUP_KEEP"`
+	BackupCompression string   `json:"backup_compression" yaml:"backup_compression" env:"BACKUP_COMPRESSION"`
+	WALCheckpoint     Duration `json:"wal_checkpoint_interval" yaml:"wal_checkpoint_interval" env:"WAL_CHECKPOINT_INTERVAL"`
+	LogLevel          string   `json:"log_level" yaml:"log_level" env:"LOG_LEVEL"`
+	Env               string   `json:"environment" yaml:"environment" env:"ENVIRONMENT"`
+	DSN               string   `json:"database_dsn" yaml:"database_dsn" env:"DATABASE_DSN"`
+	AllowNewerSchema  bool     `json:"allow_newer_schema" yaml:"allow_newer_schema" env:"ALLOW_NEWER_SCHEMA"`
+	DBMaxConns        int      `json:"db_max_conns" yaml:"db_max_conns" env:"DB_MAX_CONNS"`
+	DBMinConns        int      `json:"db_min_conns" yaml:"db_min_conns" env:"DB_MIN_CONNS"`
+	DBConnLifetime    Duration `json:"db_conn_lifetime" yaml:"db_conn_lifetime" env:"DB_CONN_LIFETIME"`
+	DBConnIdleTime    Duration `json:"db_conn_idle_time" yaml:"db_conn_idle_time" env:"DB_CONN_IDLE_TIME"`
+	DBHealthCheck     Duration `json:"db_health_check_period" yaml:"db_health_check_period" env:"DB_HEALTH_CHECK_PERIOD"`
+	DBStatementCache  string   `json:"db_statement_cache" yaml:"db_statement_cache" env:"DB_STATEMENT_CACHE"`
+	SampleRetention   Duration `json:"sample_retention" yaml:"sample_retention" env:"SAMPLE_RETENTION"`
+	TokensFile        string   `json:"tokens_file" yaml:"tokens_file" env:"TOKENS_FILE"`
+	TokensDB          bool     `json:"tokens_db" yaml:"tokens_db" env:"TOKENS_DB"`
+	TenantMaxSeries   int      `json:"tenant_max_series" yaml:"tenant_max_series" env:"TENANT_MAX_SERIES"`
+	TenantQuotas      Quotas   `json:"tenant_quotas" yaml:"tenant_quotas" env:"TENANT_QUOTAS"`
+	TLSCert           string   `json:"tls_cert" yaml:"tls_cert" env:"TLS_CERT"`
+	TLSKey            string   `json:"tls_key" yaml:"tls_key" env:"TLS_KEY"`
+	TLSClientCA       string   `json:"tls_client_ca" yaml:"tls_client_ca" env:"TLS_CLIENT_CA"`
+	RateLimit         float64  `json:"rate_limit" yaml:"rate_limit" env:"RATE_LIMIT"`
+	RateBurst         int      `json:"rate_burst" yaml:"rate_burst" env:"RATE_BURST"`
+	RateLimitBy       string   `json:"rate_limit_by" yaml:"rate_limit_by" env:"RATE_LIMIT_BY"`
+	MaxBodySize       int64    `json:"max_body_size" yaml:"max_body_size" env:"MAX_BODY_SIZE"`
+	MaxDecodedSize    int64    `json:"max_decoded_size" yaml:"max_decoded_size" env:"MAX_DECODED_SIZE"`
+	MaxBatch          int      `json:"max_batch" yaml:"max_batch" env:"MAX_BATCH"`
+	IdempotencyWindow Duration `json:"idempotency_window" yaml:"idempotency_window" env:"IDEMPOTENCY_WINDOW"`
+	EnableAdmin       bool     `json:"enable_admin" yaml:"enable_admin" env:"ENABLE_ADMIN"`
+	InternalAddr      string   `json:"internal_address" yaml:"internal_address" env:"INTERNAL_ADDRESS"`
+	SelfMetricsIntr   Duration `json:"self_metrics_interval" yaml:"self_metrics_interval" env:"SELF_METRICS_INTERVAL"`
+	ShutdownDrain     Duration `json:"shutdown_drain" yaml:"shutdown_drain" env:"SHUTDOWN_DRAIN"`
+
+	flags explicitFlags
 }
 
+// NewServerConfig builds the config from defaults, the config file, flags and env,
+// each source overriding the previous one.
 func NewServerConfig() (*ServerConfig, error) {
-	cfg := new(ServerConfig)
-	parseServerFlags(cfg)
-	if err := env.Parse(cfg); err != nil {
+	cfg := newServerDefaults()
+	defineServerFlags(flag.CommandLine, cfg)
+	flag.Parse()
+	cfg.flags = visitedFlags(flag.CommandLine)
+
+	if err := cfg.load(flag.CommandLine); err != nil {
 		return nil, fmt.Errorf("server config error: %w", err)
 	}
 	return cfg, nil
 }
 
-func parseServerFlags(cfg *ServerConfig) {
-	flag.StringVar(&cfg.Addr, "a", ":8080", "address and port to run server")
-	flag.IntVar(&cfg.StoreIntr, "i", 300, "metrics saves to file each time after this interval")
-	flag.StringVar(&cfg.FileStoragePath, "f", `./metrics.json`, "file path for metrics saving")
-	flag.BoolVar(&cfg.Restore, "r", false, "load dumped metrics at server start")
-	flag.StringVar(&cfg.LogLevel, "l", "info", "level of logging")
-	flag.StringVar(&cfg.Env, "e", "local", "environment: prod, local")
-	flag.StringVar(&cfg.DSN, "d", "", "postgres data source name")
-	flag.Parse()
+// Reload builds the config again from the same sources, picking up config file changes.
+// Flags given on the command line and env keep overriding the file.
+func (cfg *ServerConfig) Reload() (*ServerConfig, error) {
+	fresh := newServerDefaults()
+	fresh.ConfigFile = cfg.ConfigFile
+	fresh.flags = cfg.flags
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	defineServerFlags(fs, fresh)
+
+	if err := fresh.load(fs); err != nil {
+		return nil, fmt.Errorf("server config error: %w", err)
+	}
+	return fresh, nil
+}
+
+// RestartRequired names settings that differ from next but cannot be applied on reload.
+func (cfg *ServerConfig) RestartRequired(next *ServerConfig) []string {
+	var changed []string
+	cur, nxt := reflect.ValueOf(cfg).Elem(), reflect.ValueOf(next).Elem()
+	for i := range cur.NumField() {
+		f := cur.Type().Field(i)
+		if !f.IsExported() || slices.Contains(serverReloadable, f.Name) {
+			continue
+		}
+		if !reflect.DeepEqual(cur.Field(i).Interface(), nxt.Field(i).Interface()) {
+			changed = append(changed, f.Name)
+		}
+	}
+	return changed
+}
+
+func (cfg *ServerConfig) load(fs *flag.FlagSet) error {
+	if cfg.ConfigFile == "" {
+		cfg.ConfigFile = os.Getenv("CONFIG")
+	}
+	if cfg.ConfigFile != "" {
+		if err := loadFile(cfg.ConfigFile, cfg); err != nil {
+			return err
+		}
+	}
+	if err := cfg.flags.apply(fs); err != nil {
+		return err
+	}
+	// Env is applied last as it always was for the server, unlike the agent.
+	if err := env.Parse(cfg); err != nil {
+		return err
+	}
+	return cfg.Validate()
+}
+
+// Validate reports every invalid setting at once.
+func (cfg *ServerConfig) Validate() error {
+	var errs []error
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("address: %q must be host:port: %w", cfg.Addr, err))
+	}
+	if cfg.StoreIntr < 0 {
+		errs = append(errs, fmt.Errorf("store_interval: must not be negative, got %s", cfg.StoreIntr.Duration()))
+	}
+	if cfg.FileStoragePath == "" {
+		errs = append(errs, errors.New("file_storage_path: must not be empty"))
+	}
+	if !slices.Contains(ServerRestoreModes, cfg.RestoreMode) {
+		errs = append(errs, fmt.Errorf("restore_mode: unknown %q, known: %v", cfg.RestoreMode, ServerRestoreModes))
+	}
+	if cfg.BackupKeep < 1 {
+		errs = append(errs, fmt.Errorf("backup_keep: must be positive, got %d", cfg.BackupKeep))
+	}
+	if !slices.Contains(ServerBackupCompressions, cfg.BackupCompression) {
+		errs = append(errs, fmt.Errorf("backup_compression: unknown %q, known: %v", cfg.BackupCompression, ServerBackupCompressions))
+	}
+	if cfg.WALCheckpoint <= 0 {
+		errs = append(errs, fmt.Errorf("wal_checkpoint_interval: must be positive, got %s", cfg.WALCheckpoint.Duration()))
+	}
+	if _, err := zap.ParseAtomicLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+	if !slices.Contains(ServerEnvs, cfg.Env) {
+		errs = append(errs, fmt.Errorf("environment: unknown %q, known: %v", cfg.Env, ServerEnvs))
+	}
+	if cfg.DBMaxConns < 1 {
+		errs = append(errs, fmt.Errorf("db_max_conns: must be positive, got %d", cfg.DBMaxConns))
+	}
+	if cfg.DBMinConns < 0 || cfg.DBMinConns > cfg.DBMaxConns {
+		errs = append(errs, fmt.Errorf("db_min_conns: must be between 0 and db_max_conns, got %d", cfg.DBMinConns))
+	}
+	if cfg.DBConnLifetime <= 0 {
+		errs = append(errs, fmt.Errorf("db_conn_lifetime: must be positive, got %s", cfg.DBConnLifetime.Duration()))
+	}
+	if cfg.DBConnIdleTime <= 0 {
+		errs = append(errs, fmt.Errorf("db_conn_idle_time: must be positive, got %s", cfg.DBConnIdleTime.Duration()))
+	}
+	if cfg.DBHealthCheck <= 0 {
+		errs = append(errs, fmt.Errorf("db_health_check_period: must be positive, got %s", cfg.DBHealthCheck.Duration()))
+	}
+	if !slices.Contains(ServerDBStatementCaches, cfg.DBStatementCache) {
+		errs = append(errs, fmt.Errorf("db_statement_cache: unknown %q, known: %v", cfg.DBStatementCache, ServerDBStatementCaches))
+	}
+	if cfg.SampleRetention < 0 {
+		errs = append(errs, fmt.Errorf("sample_retention: must not be negative, got %s", cfg.SampleRetention.Duration()))
+	}
+	if cfg.TokensFile != "" && cfg.TokensDB {
+		errs = append(errs, errors.New("tokens_file and tokens_db are mutually exclusive"))
+	}
+	if cfg.TokensDB && cfg.DSN == "" {
+		errs = append(errs, errors.New("tokens_db: requires database_dsn"))
+	}
+	if cfg.TenantMaxSeries < 0 {
+		errs = append(errs, fmt.Errorf("tenant_max_series: must not be negative, got %d", cfg.TenantMaxSeries))
+	}
+	for id, quota := range cfg.TenantQuotas {
+		if quota < 0 {
+			errs = append(errs, fmt.Errorf("tenant_quotas: quota of %s must not be negative, got %d", id, quota))
+		}
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert and tls_key must be set together"))
+	}
+	if cfg.TLSClientCA != "" && cfg.TLSCert == "" {
+		errs = append(errs, errors.New("tls_client_ca: requires tls_cert and tls_key"))
+	}
+	if cfg.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rate_limit: must not be negative, got %g", cfg.RateLimit))
+	}
+	if cfg.RateLimit > 0 && cfg.RateBurst < 1 {
+		errs = append(errs, fmt.Errorf("rate_burst: must be positive, got %d", cfg.RateBurst))
+	}
+	if !slices.Contains(ServerRateLimitBy, cfg.RateLimitBy) {
+		errs = append(errs, fmt.Errorf("rate_limit_by: unknown %q, known: %v", cfg.RateLimitBy, ServerRateLimitBy))
+	}
+	if cfg.MaxBodySize < 0 {
+		errs = append(errs, fmt.Errorf("max_body_size: must not be negative, got %d", cfg.MaxBodySize))
+	}
+	if cfg.MaxDecodedSize < 0 {
+		errs = append(errs, fmt.Errorf("max_decoded_size: must not be negative, got %d", cfg.MaxDecodedSize))
+	}
+	if cfg.InternalAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.InternalAddr); err != nil {
+			errs = append(errs, fmt.Errorf("internal_address: %q must be host:port: %w", cfg.InternalAddr, err))
+		}
+	}
+	if cfg.SelfMetricsIntr < 0 {
+		errs = append(errs, fmt.Errorf("self_metrics_interval: must not be negative, got %s", cfg.SelfMetricsIntr.Duration()))
+	}
+	if cfg.ShutdownDrain < 0 {
+		errs = append(errs, fmt.Errorf("shutdown_drain: must not be negative, got %s", cfg.ShutdownDrain.Duration()))
+	}
+	if cfg.MaxBatch < 0 {
+		errs = append(errs, fmt.Errorf("max_batch: must not be negative, got %d", cfg.MaxBatch))
+	}
+	if cfg.IdempotencyWindow < 0 {
+		errs = append(errs, fmt.Errorf("idempotency_window: must not be negative, got %s", cfg.IdempotencyWindow.Duration()))
+	}
+	return errors.Join(errs...)
+}
+
+func newServerDefaults() *ServerConfig {
+	return &ServerConfig{
+		Addr:              ":8080",
+		StoreIntr:         Duration(300 * time.Second),
+		FileStoragePath:   `./metrics.json`,
+		RestoreMode:       "replace",
+		BackupKeep:        5,
+		BackupCompression: "none",
+		WALCheckpoint:     Duration(time.Minute),
+		LogLevel:          "info",
+		Env:               "local",
+		DBMaxConns:        5,
+		DBConnLifetime:    Duration(5 * time.Minute),
+		DBConnIdleTime:    Duration(10 * time.Minute),
+		DBHealthCheck:     Duration(time.Minute),
+		DBStatementCache:  "statement",
+		SampleRetention:   Duration(30 * 24 * time.Hour),
+		RateBurst:         20,
+		RateLimitBy:       "ip",
+		MaxBodySize:       8 << 20,
+		MaxDecodedSize:    32 << 20,
+		MaxBatch:          10000,
+		IdempotencyWindow: Duration(10 * time.Minute),
+		SelfMetricsIntr:   Duration(10 * time.Second),
+	}
+}
+
+func defineServerFlags(fs *flag.FlagSet, cfg *ServerConfig) {
+	fs.StringVar(&cfg.ConfigFile, "c", cfg.ConfigFile, "JSON or YAML config file")
+	fs.StringVar(&cfg.Addr, "a", cfg.Addr, "address and port to run server")
+	fs.Var(&cfg.StoreIntr, "i", "metrics saves to file each time after this interval, duration or seconds")
+	fs.StringVar(&cfg.FileStoragePath, "f", cfg.FileStoragePath, "file path for metrics saving")
+	fs.BoolVar(&cfg.Restore, "r", cfg.Restore, "load dumped metrics at server start")
+	fs.StringVar(&cfg.RestoreMode, "restore-mode", cfg.RestoreMode, "how restored values combine with stored ones: replace, merge-max, add (adds counters on every restore)")
+	fs.BoolVar(&cfg.RestoreDryRun, "restore-dry-run", cfg.RestoreDryRun, "print what restore would change in storage and exit")
+	fs.IntVar(&cfg.BackupKeep, "backup-keep", cfg.BackupKeep, "number of timestamped backup snapshots to keep")
+	fs.StringVar(&cfg.BackupCompression, "backup-compression", cfg.BackupCompression, "backup snapshot compression: none, gzip, zstd")
+	fs.Var(&cfg.WALCheckpoint, "wal-checkpoint-interval", "each time to fold the write-ahead log into a snapshot when the store interval is 0")
+	fs.StringVar(&cfg.LogLevel, "l", cfg.LogLevel, "level of logging")
+	fs.StringVar(&cfg.Env, "e", cfg.Env, "environment: prod, local")
+	fs.StringVar(&cfg.DSN, "d", cfg.DSN, "postgres data source name")
+	fs.BoolVar(&cfg.AllowNewerSchema, "allow-newer-schema", cfg.AllowNewerSchema, "run on a schema migrated by a newer server instead of refusing to start")
+	fs.IntVar(&cfg.DBMaxConns, "db-max-conns", cfg.DBMaxConns, "maximum number of postgres connections")
+	fs.IntVar(&cfg.DBMinConns, "db-min-conns", cfg.DBMinConns, "number of postgres connections kept open when idle")
+	fs.Var(&cfg.DBConnLifetime, "db-conn-lifetime", "time after which a postgres connection is closed and replaced")
+	fs.Var(&cfg.DBConnIdleTime, "db-conn-idle-time", "time after which an idle postgres connection is closed")
+	fs.Var(&cfg.DBHealthCheck, "db-health-check-period", "each time to check idle postgres connections")
+	fs.StringVar(&cfg.DBStatementCache, "db-statement-cache", cfg.DBStatementCache, "what postgres connections cache: statement, describe, disabled for poolers in transaction mode")
+	fs.Var(&cfg.SampleRetention, "sample-retention", "time postgres keeps metric samples for, rounded up to days, 0 keeps them forever")
+	fs.StringVar(&cfg.TokensFile, "tokens", cfg.TokensFile, "JSON file with API tokens, enables authentication")
+	fs.BoolVar(&cfg.TokensDB, "tokens-db", cfg.TokensDB, "load API tokens from the postgres tokens table, enables authentication")
+	fs.IntVar(&cfg.TenantMaxSeries, "tenant-max-series", cfg.TenantMaxSeries, "maximum number of series per tenant, 0 is unlimited")
+	fs.Var(&cfg.TenantQuotas, "tenant-quotas", "per-tenant series limits overriding the default: tenant=limit,...")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "TLS certificate file, enables HTTPS together with -tls-key")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "TLS private key file")
+	fs.StringVar(&cfg.TLSClientCA, "tls-client-ca", cfg.TLSClientCA, "CA bundle to verify client certificates against, enables mutual TLS")
+	fs.Float64Var(&cfg.RateLimit, "rate-limit", cfg.RateLimit, "ingest requests per second per client, 0 disables rate limiting")
+	fs.IntVar(&cfg.RateBurst, "rate-burst", cfg.RateBurst, "ingest requests a client may burst above the rate limit")
+	fs.StringVar(&cfg.RateLimitBy, "rate-limit-by", cfg.RateLimitBy, "identify rate limited clients by: ip, token (falls back to ip without authentication)")
+	fs.Int64Var(&cfg.MaxBodySize, "max-body-size", cfg.MaxBodySize, "maximum ingest request body size in bytes as sent, 0 is unlimited")
+	fs.Int64Var(&cfg.MaxDecodedSize, "max-decoded-size", cfg.MaxDecodedSize, "maximum ingest request body size in bytes after decompression, 0 is unlimited")
+	fs.IntVar(&cfg.MaxBatch, "max-batch", cfg.MaxBatch, "maximum number of metrics in a batch, 0 is unlimited")
+	fs.Var(&cfg.IdempotencyWindow, "idempotency-window", "time a succeeded Idempotency-Key is remembered to drop resent batches, 0 disables")
+	fs.BoolVar(&cfg.EnableAdmin, "admin", cfg.EnableAdmin, "enable admin endpoints to delete, reset and rename metrics")
+	fs.StringVar(&cfg.InternalAddr, "internal-addr", cfg.InternalAddr, "address of the listener exposing server metrics, disabled when empty")
+	fs.Var(&cfg.SelfMetricsIntr, "self-metrics-interval", "each time to store server metrics with the others, 0 disables")
+	fs.Var(&cfg.ShutdownDrain, "shutdown-drain", "time /readyz fails before the server stops on shutdown, for load balancers to notice")
 }
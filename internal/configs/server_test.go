@@ -0,0 +1,79 @@
+package configs
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServerConfig(t *testing.T, args ...string) (*ServerConfig, error) {
+	t.Helper()
+	oldArgs, oldFlags := os.Args, flag.CommandLine
+	t.Cleanup(func() {
+		os.Args, flag.CommandLine = oldArgs, oldFlags
+	})
+	os.Args = append([]string{"server"}, args...)
+	flag.CommandLine = flag.NewFlagSet("server", flag.ContinueOnError)
+	return NewServerConfig()
+}
+
+func TestServerConfigDefaults(t *testing.T) {
+	cfg, err := newTestServerConfig(t, "-i", "10")
+	require.NoError(t, err)
+	assert.Equal(t, ":8080", cfg.Addr)
+	assert.Equal(t, 10*time.Second, cfg.StoreIntr.Duration())
+	assert.Equal(t, "info", cfg.LogLevel)
+}
+
+func TestServerConfigEnvOverridesFlags(t *testing.T) {
+	path := writeConfig(t, "server.json", `{"address": ":8081", "log_level": "debug", "max_batch": 5}`)
+	t.Setenv("ADDRESS", ":9090")
+	cfg, err := newTestServerConfig(t, "-c", path, "-a", ":8082", "-l", "warn")
+	require.NoError(t, err)
+	assert.Equal(t, ":9090", cfg.Addr)
+	assert.Equal(t, "warn", cfg.LogLevel)
+	assert.Equal(t, 5, cfg.MaxBatch)
+}
+
+func TestServerConfigValidation(t *testing.T) {
+	path := writeConfig(t, "server.yaml", `
+store_interval: -5s
+log_level: loud
+environment: staging
+tokens_db: true
+rate_limit_by: user
+tenant_quotas: {team-a: -1}
+db_max_conns: 2
+db_min_conns: 3
+db_statement_cache: always
+sample_retention: -1h
+`)
+	_, err := newTestServerConfig(t, "-c", path)
+	require.Error(t, err)
+	for _, part := range []string{"store_interval", "log_level", "environment", "tokens_db", "rate_limit_by", "tenant_quotas", "db_min_conns", "db_statement_cache", "sample_retention"} {
+		assert.ErrorContains(t, err, part)
+	}
+}
+
+func TestServerConfigReload(t *testing.T) {
+	path := writeConfig(t, "server.json", `{"log_level": "info", "store_interval": "1m", "address": ":8081"}`)
+	cfg, err := newTestServerConfig(t, "-c", path, "-l", "warn")
+	require.NoError(t, err)
+	assert.Equal(t, "warn", cfg.LogLevel)
+	assert.Equal(t, ":8081", cfg.Addr)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"log_level": "debug", "store_interval": "30s", "address": ":9090"}`), 0o600))
+	next, err := cfg.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, "warn", next.LogLevel)
+	assert.Equal(t, 30*time.Second, next.StoreIntr.Duration())
+	assert.Equal(t, []string{"Addr"}, cfg.RestartRequired(next))
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"store_interval": "soon"}`), 0o600))
+	_, err = cfg.Reload()
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+package configs
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+
+	"github.com/caarlos0/env/v6"
+)
+
+type StorageMigrateConfig struct {
+	From        string `env:"MIGRATE_FROM"`
+	To          string `env:"MIGRATE_TO"`
+	BatchSize   int    `env:"MIGRATE_BATCH_SIZE"`
+	StateFile   string `env:"MIGRATE_STATE_FILE"`
+	Resume      bool   `env:"MIGRATE_RESUME"`
+	Compression string `env:"BACKUP_COMPRESSION"`
+}
+
+// NewStorageMigrateConfig builds the config from defaults, env and flags, each source
+// overriding the previous one.
+func NewStorageMigrateConfig() (*StorageMigrateConfig, error) {
+	cfg := &StorageMigrateConfig{
+		BatchSize:   1000,
+		StateFile:   "./storagemigrate.state.json",
+		Compression: "none",
+	}
+	flag.StringVar(&cfg.From, "from", cfg.From, "source: postgres DSN (postgres://...) or backup file path")
+	flag.StringVar(&cfg.To, "to", cfg.To, "destination: postgres DSN (postgres://...) or backup file path")
+	flag.IntVar(&cfg.BatchSize, "batch-size", cfg.BatchSize, "metrics written in one transaction")
+	flag.StringVar(&cfg.StateFile, "state", cfg.StateFile, "file recording progress for -resume")
+	flag.BoolVar(&cfg.Resume, "resume", cfg.Resume, "continue an interrupted migration from the state file")
+	flag.StringVar(&cfg.Compression, "backup-compression", cfg.Compression, "compression of a destination backup file: none, gzip, zstd")
+	flag.Parse()
+	explicit := visitedFlags(flag.CommandLine)
+
+	if err := env.Parse(cfg); err != nil {
+		return nil, fmt.Errorf("storage migrate config error: %w", err)
+	}
+	if err := explicit.apply(flag.CommandLine); err != nil {
+		return nil, fmt.Errorf("storage migrate config error: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("storage migrate config error: %w", err)
+	}
+	return cfg, nil
+}
+
+// Validate reports every invalid setting at once.
+func (cfg *StorageMigrateConfig) Validate() error {
+	var errs []error
+	if cfg.From == "" {
+		errs = append(errs, errors.New("from: source is required"))
+	}
+	if cfg.To == "" {
+		errs = append(errs, errors.New("to: destination is required"))
+	}
+	if cfg.From != "" && cfg.From == cfg.To {
+		errs = append(errs, errors.New("to: destination must differ from the source"))
+	}
+	if cfg.BatchSize <= 0 {
+		errs = append(errs, fmt.Errorf("batch_size: must be positive, got %d", cfg.BatchSize))
+	}
+	if cfg.Resume && cfg.StateFile == "" {
+		errs = append(errs, errors.New("state: resume requires a state file"))
+	}
+	if !slices.Contains(ServerBackupCompressions, cfg.Compression) {
+		errs = append(errs, fmt.Errorf("backup_compression: unknown %q, known: %v", cfg.Compression, ServerBackupCompressions))
+	}
+	return errors.Join(errs...)
+}
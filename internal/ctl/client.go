@@ -0,0 +1,233 @@
+package ctl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/volchkovski/go-practicum-metrics/internal/agent"
+	"github.com/volchkovski/go-practicum-metrics/internal/configs"
+	"github.com/volchkovski/go-practicum-metrics/internal/handlers"
+	"github.com/volchkovski/go-practicum-metrics/internal/health"
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+	"github.com/volchkovski/go-practicum-metrics/internal/tlsutil"
+)
+
+const (
+	// retryMaxWait caps waits between retries, an operator waits for the command.
+	retryMaxWait = 10 * time.Second
+	// batchSize keeps imports below the default batch limit of the server.
+	batchSize = 1000
+)
+
+// StatusError is a response the server refused the request with.
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server responded with %d: %s", e.Code, strings.TrimSpace(e.Body))
+}
+
+// Client calls the HTTP API of the server with the retries of the agent client.
+type Client struct {
+	client   *resty.Client
+	compress bool
+}
+
+func NewClient(cfg *configs.CtlConfig) (*Client, error) {
+	if cfg.Tenant != "" && !tenant.Valid(cfg.Tenant) {
+		return nil, fmt.Errorf("invalid tenant %q", cfg.Tenant)
+	}
+	client := agent.NewRestyClient(retryMaxWait)
+	// Bodies are compressed per request, GET requests have none to decode.
+	client.Header.Del("Content-Encoding")
+	client.SetTimeout(cfg.HTTP.Timeout.Duration()).SetRetryCount(cfg.HTTP.Retries)
+	if cfg.Token != "" {
+		client.SetAuthToken(cfg.Token)
+	}
+	if cfg.Tenant != "" {
+		client.SetHeader(tenant.Header, cfg.Tenant)
+	}
+
+	scheme := "http"
+	if cfg.TLSCA != "" || cfg.TLSCert != "" || cfg.TLSKey != "" {
+		certs, err := tlsutil.NewReloader(cfg.TLSCert, cfg.TLSKey, cfg.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("TLS setup failed: %w", err)
+		}
+		client.SetTLSClientConfig(certs.ClientConfig())
+		scheme = "https"
+	}
+	client.SetBaseURL(scheme + "://" + cfg.Addr)
+	return &Client{client: client, compress: cfg.HTTP.Compression == "gzip"}, nil
+}
+
+// request prepares a request with body encoded as JSON, gzipped unless compression is off.
+func (c *Client) request(ctx context.Context, body any) (*resty.Request, error) {
+	req := c.client.R().SetContext(ctx)
+	if body == nil {
+		return req, nil
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if !c.compress {
+		return req.SetBody(data), nil
+	}
+
+	var buff bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buff, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return req.SetHeader("Content-Encoding", "gzip").SetBody(buff.Bytes()), nil
+}
+
+func checkResponse(res *resty.Response, err error) (*resty.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	if res.IsError() {
+		return nil, &StatusError{Code: res.StatusCode(), Body: string(res.Body())}
+	}
+	return res, nil
+}
+
+// Get returns a metric, models.ErrMetricNotFound when the server does not have it.
+func (c *Client) Get(ctx context.Context, tp, nm string) (models.Metrics, error) {
+	var metric models.Metrics
+	req, err := c.request(ctx, models.Metrics{ID: nm, MType: tp})
+	if err != nil {
+		return metric, err
+	}
+	res, err := checkResponse(req.Post("/value/"))
+	if statusErr := (*StatusError)(nil); errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+		return metric, fmt.Errorf("%s %s: %w", tp, nm, models.ErrMetricNotFound)
+	}
+	if err != nil {
+		return metric, err
+	}
+	err = json.Unmarshal(res.Body(), &metric)
+	return metric, err
+}
+
+// Set sets a gauge or adds the delta to a counter.
+func (c *Client) Set(ctx context.Context, metric models.Metrics) error {
+	req, err := c.request(ctx, metric)
+	if err != nil {
+		return err
+	}
+	_, err = checkResponse(req.Post("/update/"))
+	return err
+}
+
+// Push sends metrics in batches, counters are added.
+func (c *Client) Push(ctx context.Context, metrics []models.Metrics) error {
+	for start := 0; start < len(metrics); start += batchSize {
+		req, err := c.request(ctx, metrics[start:min(start+batchSize, len(metrics))])
+		if err != nil {
+			return err
+		}
+		if _, err = checkResponse(req.Post("/updates/")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) List(ctx context.Context) ([]models.Metrics, error) {
+	req, err := c.request(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := checkResponse(req.Get("/values/"))
+	if err != nil {
+		return nil, err
+	}
+	var metrics []models.Metrics
+	err = json.Unmarshal(res.Body(), &metrics)
+	return metrics, err
+}
+
+// Prometheus returns the metrics in the Prometheus text format, with metadata.
+func (c *Client) Prometheus(ctx context.Context) ([]byte, error) {
+	req, err := c.request(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := checkResponse(req.Get("/metrics"))
+	if err != nil {
+		return nil, err
+	}
+	return res.Body(), nil
+}
+
+func (c *Client) Delete(ctx context.Context, tp, nm string) error {
+	req, err := c.request(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = checkResponse(req.SetPathParams(map[string]string{"tp": tp, "nm": nm}).Delete("/value/{tp}/{nm}"))
+	return err
+}
+
+// DeleteMatching deletes metrics whose names match the glob and returns how many.
+func (c *Client) DeleteMatching(ctx context.Context, pattern string) (int, error) {
+	req, err := c.request(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := checkResponse(req.SetQueryParam("match", pattern).Delete("/admin/metrics"))
+	if err != nil {
+		return 0, err
+	}
+	var result handlers.DeleteResult
+	err = json.Unmarshal(res.Body(), &result)
+	return result.Deleted, err
+}
+
+// Backup requests a backup, it is written in the background.
+func (c *Client) Backup(ctx context.Context) error {
+	req, err := c.request(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = checkResponse(req.Post("/admin/backup"))
+	return err
+}
+
+// Health returns the readiness report. It is not retried: a check reports the state now.
+func (c *Client) Health(ctx context.Context) (health.Report, error) {
+	var report health.Report
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.client.BaseURL+"/readyz", nil)
+	if err != nil {
+		return report, err
+	}
+	res, err := c.client.GetClient().Do(req)
+	if err != nil {
+		return report, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusServiceUnavailable {
+		return report, &StatusError{Code: res.StatusCode, Body: res.Status}
+	}
+	err = json.NewDecoder(res.Body).Decode(&report)
+	return report, err
+}
@@ -0,0 +1,296 @@
+package ctl
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/configs"
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+)
+
+const Usage = `Usage: metricsctl [flags] <command> [arguments]
+
+Commands:
+  get <type> <name>                  print a metric
+  set <type> <name> <value>          set a gauge or add the value to a counter
+  list [-match glob]                 print metrics
+  delete <type> <name>               delete a metric
+  delete -match <glob>               delete metrics with matching names
+  export [-format f] [-file path]    write metrics as json, csv or prom
+  import [-format f] [-file path]    send metrics, counters are added to stored ones
+  backup                             request a backup of the server
+  health                             print readiness checks, fail when not ready
+
+Flags:
+`
+
+var (
+	ErrUsage    = errors.New("invalid usage, see metricsctl -h")
+	ErrNotReady = errors.New("server is not ready")
+)
+
+type command func(ctx context.Context, c *Client, p *printer, args []string) error
+
+var commands = map[string]command{
+	"get":    runGet,
+	"set":    runSet,
+	"list":   runList,
+	"delete": runDelete,
+	"export": runExport,
+	"import": runImport,
+	"backup": runBackup,
+	"health": runHealth,
+}
+
+// Run executes the command in args against the server of cfg and prints the result to out.
+func Run(ctx context.Context, cfg *configs.CtlConfig, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return ErrUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %q: %w", args[0], ErrUsage)
+	}
+	c, err := NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	return cmd(ctx, c, &printer{out: out, json: cfg.Output == "json"}, args[1:])
+}
+
+// printer writes results as a table or as JSON.
+type printer struct {
+	out  io.Writer
+	json bool
+}
+
+func (p *printer) print(v any, table func(w io.Writer)) error {
+	if p.json {
+		enc := json.NewEncoder(p.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	tw := tabwriter.NewWriter(p.out, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+func (p *printer) metrics(metrics []models.Metrics) error {
+	return p.print(metrics, func(w io.Writer) {
+		fmt.Fprintln(w, "TYPE\tNAME\tVALUE")
+		for _, metric := range metrics {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", metric.MType, metric.ID, formatValue(metric))
+		}
+	})
+}
+
+// parseFlags parses the flags of a command and checks the number of its arguments.
+func parseFlags(fs *flag.FlagSet, args []string, nargs int) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != nargs {
+		return fmt.Errorf("%s takes %d arguments: %w", fs.Name(), nargs, ErrUsage)
+	}
+	return nil
+}
+
+func runGet(ctx context.Context, c *Client, p *printer, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 2); err != nil {
+		return err
+	}
+	metric, err := c.Get(ctx, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	if p.json {
+		return p.print(metric, nil)
+	}
+	return p.metrics([]models.Metrics{metric})
+}
+
+func runSet(ctx context.Context, c *Client, p *printer, args []string) error {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 3); err != nil {
+		return err
+	}
+	metric, err := newMetric(fs.Arg(0), fs.Arg(1), fs.Arg(2))
+	if err != nil {
+		return err
+	}
+	return c.Set(ctx, metric)
+}
+
+func runList(ctx context.Context, c *Client, p *printer, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	match := fs.String("match", "", "glob pattern of metric names to print, all when empty")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	metrics, err := c.List(ctx)
+	if err != nil {
+		return err
+	}
+	if *match != "" {
+		if _, err = path.Match(*match, ""); err != nil {
+			return err
+		}
+		metrics = slices.DeleteFunc(metrics, func(metric models.Metrics) bool {
+			ok, _ := path.Match(*match, metric.ID)
+			return !ok
+		})
+	}
+	slices.SortFunc(metrics, func(a, b models.Metrics) int {
+		return cmp.Or(cmp.Compare(a.ID, b.ID), cmp.Compare(a.MType, b.MType))
+	})
+	return p.metrics(metrics)
+}
+
+func runDelete(ctx context.Context, c *Client, p *printer, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	match := fs.String("match", "", "glob pattern of metric names to delete instead of a single metric")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *match == "" {
+		if fs.NArg() != 2 {
+			return fmt.Errorf("delete takes a type and a name or -match: %w", ErrUsage)
+		}
+		return c.Delete(ctx, fs.Arg(0), fs.Arg(1))
+	}
+	if fs.NArg() != 0 {
+		return fmt.Errorf("delete -match takes no arguments: %w", ErrUsage)
+	}
+	n, err := c.DeleteMatching(ctx, *match)
+	if err != nil {
+		return err
+	}
+	return p.print(map[string]int{"deleted": n}, func(w io.Writer) {
+		fmt.Fprintf(w, "%d metrics deleted\n", n)
+	})
+}
+
+func runExport(ctx context.Context, c *Client, p *printer, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", FormatJSON, "export format: json, csv, prom")
+	file := fs.String("file", "-", "file to write, - is the standard output")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if !slices.Contains(Formats, *format) {
+		return fmt.Errorf("unknown format %q, known: %v", *format, Formats)
+	}
+
+	var buff bytes.Buffer
+	if *format == FormatProm {
+		data, err := c.Prometheus(ctx)
+		if err != nil {
+			return err
+		}
+		buff.Write(data)
+	} else {
+		metrics, err := c.List(ctx)
+		if err != nil {
+			return err
+		}
+		if err = Encode(&buff, *format, metrics); err != nil {
+			return err
+		}
+	}
+
+	if *file == "-" {
+		_, err := buff.WriteTo(p.out)
+		return err
+	}
+	return os.WriteFile(*file, buff.Bytes(), 0o644)
+}
+
+func runImport(ctx context.Context, c *Client, p *printer, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", FormatJSON, "import format: json, csv, prom")
+	file := fs.String("file", "-", "file to read, - is the standard input")
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if *file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		return err
+	}
+	metrics, err := Decode(bytes.NewReader(data), *format)
+	if err != nil {
+		return err
+	}
+	if err = c.Push(ctx, metrics); err != nil {
+		return err
+	}
+	return p.print(map[string]int{"imported": len(metrics)}, func(w io.Writer) {
+		fmt.Fprintf(w, "%d metrics imported\n", len(metrics))
+	})
+}
+
+func runBackup(ctx context.Context, c *Client, p *printer, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	if err := c.Backup(ctx); err != nil {
+		return err
+	}
+	return p.print(map[string]bool{"requested": true}, func(w io.Writer) {
+		fmt.Fprintln(w, "Backup requested")
+	})
+}
+
+func runHealth(ctx context.Context, c *Client, p *printer, args []string) error {
+	fs := flag.NewFlagSet("health", flag.ContinueOnError)
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+	report, err := c.Health(ctx)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(report.Checks))
+	for nm := range report.Checks {
+		names = append(names, nm)
+	}
+	slices.Sort(names)
+	err = p.print(report, func(w io.Writer) {
+		fmt.Fprintf(w, "Status: %s, phase: %s\n\n", report.Status, report.Phase)
+		fmt.Fprintln(w, "CHECK\tSTATUS\tDURATION\tERROR")
+		for _, nm := range names {
+			check := report.Checks[nm]
+			d := time.Duration(check.Duration * float64(time.Second)).Round(time.Microsecond)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", nm, check.Status, d, check.Error)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	if !report.Ready() {
+		return ErrNotReady
+	}
+	return nil
+}
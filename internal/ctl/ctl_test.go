@@ -0,0 +1,189 @@
+package ctl
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volchkovski/go-practicum-metrics/internal/configs"
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/routers"
+	"github.com/volchkovski/go-practicum-metrics/internal/services"
+	"github.com/volchkovski/go-practicum-metrics/internal/storage/mem"
+)
+
+func startServer(t *testing.T, backup func()) *configs.CtlConfig {
+	t.Helper()
+	service := services.NewMetricService(mem.NewMemStorage())
+	ts := httptest.NewServer(routers.NewMetricRouter(service, routers.WithAdmin(nil), routers.WithBackup(backup)))
+	t.Cleanup(ts.Close)
+	return &configs.CtlConfig{
+		Addr:   ts.Listener.Addr().(*net.TCPAddr).String(),
+		Output: "table",
+		HTTP:   configs.HTTPClientConfig{Timeout: configs.Duration(time.Second), Compression: "gzip"},
+	}
+}
+
+func run(t *testing.T, cfg *configs.CtlConfig, args ...string) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	err := Run(context.Background(), cfg, args, &out)
+	return out.String(), err
+}
+
+func TestRunCommands(t *testing.T) {
+	backups := 0
+	cfg := startServer(t, func() { backups++ })
+
+	_, err := run(t, cfg, "set", "gauge", "Alloc", "1.5")
+	require.NoError(t, err)
+	_, err = run(t, cfg, "set", "counter", "PollCount", "3")
+	require.NoError(t, err)
+	_, err = run(t, cfg, "set", "counter", "PollCount", "1.5")
+	assert.Error(t, err)
+
+	out, err := run(t, cfg, "get", "counter", "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, "TYPE     NAME       VALUE\ncounter  PollCount  3\n", out)
+
+	_, err = run(t, cfg, "get", "gauge", "Missing")
+	assert.ErrorIs(t, err, models.ErrMetricNotFound)
+
+	out, err = run(t, cfg, "list", "-match", "Poll*")
+	require.NoError(t, err)
+	assert.Equal(t, "TYPE     NAME       VALUE\ncounter  PollCount  3\n", out)
+
+	cfg.Output = "json"
+	out, err = run(t, cfg, "list")
+	require.NoError(t, err)
+	assert.JSONEq(t, `[
+		{"id": "Alloc", "type": "gauge", "value": 1.5},
+		{"id": "PollCount", "type": "counter", "delta": 3}
+	]`, out)
+
+	out, err = run(t, cfg, "backup")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"requested": true}`, out)
+	assert.Equal(t, 1, backups)
+
+	out, err = run(t, cfg, "health")
+	require.NoError(t, err)
+	assert.Contains(t, out, `"status": "ok"`)
+
+	out, err = run(t, cfg, "delete", "-match", "*")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"deleted": 2}`, out)
+
+	_, err = run(t, cfg, "unknown")
+	assert.ErrorIs(t, err, ErrUsage)
+	_, err = run(t, cfg, "get", "gauge")
+	assert.ErrorIs(t, err, ErrUsage)
+}
+
+func TestExportImport(t *testing.T) {
+	for _, format := range Formats {
+		t.Run(format, func(t *testing.T) {
+			src := startServer(t, func() {})
+			_, err := run(t, src, "set", "gauge", "Alloc", "1.5")
+			require.NoError(t, err)
+			_, err = run(t, src, "set", "counter", "PollCount", "3")
+			require.NoError(t, err)
+
+			fp := filepath.Join(t.TempDir(), "metrics."+format)
+			_, err = run(t, src, "export", "-format", format, "-file", fp)
+			require.NoError(t, err)
+
+			dst := startServer(t, func() {})
+			dst.HTTP.Compression = "none"
+			out, err := run(t, dst, "import", "-format", format, "-file", fp)
+			require.NoError(t, err)
+			assert.Equal(t, "2 metrics imported\n", out)
+
+			out, err = run(t, dst, "list")
+			require.NoError(t, err)
+			assert.Equal(t, "TYPE     NAME       VALUE\ngauge    Alloc      1.5\ncounter  PollCount  3\n", out)
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		input   string
+		want    int
+		wantErr string
+	}{
+		{
+			name:   "csv without header",
+			format: FormatCSV,
+			input:  "gauge,Alloc,1\ncounter,PollCount,2\n",
+			want:   2,
+		},
+		{
+			name:    "csv invalid counter",
+			format:  FormatCSV,
+			input:   "type,name,value\ncounter,PollCount,2.5\n",
+			wantErr: "line 2",
+		},
+		{
+			name:   "prometheus untyped sample",
+			format: FormatProm,
+			input:  "# HELP Alloc Bytes allocated\nAlloc 12 1700000000000\n",
+			want:   1,
+		},
+		{
+			name:    "prometheus labels",
+			format:  FormatProm,
+			input:   "# TYPE requests counter\nrequests{code=\"200\"} 5\n",
+			wantErr: "labels",
+		},
+		{
+			name:    "prometheus histogram",
+			format:  FormatProm,
+			input:   "# TYPE latency histogram\nlatency 5\n",
+			wantErr: "unsupported metric type",
+		},
+		{
+			name:    "json unknown type",
+			format:  FormatJSON,
+			input:   `[{"id": "Alloc", "type": "summary"}]`,
+			wantErr: "allowed metric types",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := Decode(strings.NewReader(tt.input), tt.format)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, metrics, tt.want)
+		})
+	}
+}
+
+func TestExportToStdout(t *testing.T) {
+	cfg := startServer(t, func() {})
+	_, err := run(t, cfg, "set", "gauge", "Alloc", "2")
+	require.NoError(t, err)
+
+	out, err := run(t, cfg, "export", "-format", "csv")
+	require.NoError(t, err)
+	assert.Equal(t, "type,name,value\ngauge,Alloc,2\n", out)
+
+	_, err = run(t, cfg, "export", "-format", "xml")
+	assert.Error(t, err)
+	_, err = os.Stat("-")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
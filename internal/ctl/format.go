@@ -0,0 +1,172 @@
+package ctl
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/handlers"
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+)
+
+// Export and import formats. Prometheus names are sanitized by the server, so metrics
+// with dots or dashes in names come back under other names from a prom export.
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+	FormatProm = "prom"
+)
+
+var Formats = []string{FormatJSON, FormatCSV, FormatProm}
+
+var csvHeader = []string{"type", "name", "value"}
+
+// newMetric builds a metric from its text value: a float for gauges, an integer for counters.
+func newMetric(tp, nm, val string) (models.Metrics, error) {
+	metric := models.Metrics{ID: nm, MType: tp}
+	switch handlers.MetricType(tp) {
+	case handlers.GaugeType:
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return metric, fmt.Errorf("gauge %s: invalid value %q", nm, val)
+		}
+		metric.Value = &v
+	case handlers.CounterType:
+		v, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return metric, fmt.Errorf("counter %s: invalid value %q", nm, val)
+		}
+		metric.Delta = &v
+	default:
+		return metric, fmt.Errorf("%s: %w", nm, handlers.ErrInvalidType)
+	}
+	return metric, nil
+}
+
+func formatValue(metric models.Metrics) string {
+	switch {
+	case metric.Value != nil:
+		return strconv.FormatFloat(*metric.Value, 'g', -1, 64)
+	case metric.Delta != nil:
+		return strconv.FormatInt(*metric.Delta, 10)
+	default:
+		return ""
+	}
+}
+
+// Encode writes metrics as JSON or CSV, the Prometheus format comes from the server.
+func Encode(w io.Writer, format string, metrics []models.Metrics) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(metrics)
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		for _, metric := range metrics {
+			if err := cw.Write([]string{metric.MType, metric.ID, formatValue(metric)}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("unknown format %q, known: %v", format, Formats)
+	}
+}
+
+// Decode reads metrics in one of Formats.
+func Decode(r io.Reader, format string) ([]models.Metrics, error) {
+	switch format {
+	case FormatJSON:
+		var metrics []models.Metrics
+		if err := json.NewDecoder(r).Decode(&metrics); err != nil {
+			return nil, err
+		}
+		for _, metric := range metrics {
+			if _, err := newMetric(metric.MType, metric.ID, formatValue(metric)); err != nil {
+				return nil, err
+			}
+		}
+		return metrics, nil
+	case FormatCSV:
+		return decodeCSV(r)
+	case FormatProm:
+		return decodePrometheus(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q, known: %v", format, Formats)
+	}
+}
+
+func decodeCSV(r io.Reader) ([]models.Metrics, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+	var metrics []models.Metrics
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return metrics, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == 1 && record[0] == csvHeader[0] {
+			continue
+		}
+		metric, err := newMetric(record[0], record[1], record[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		metrics = append(metrics, metric)
+	}
+}
+
+// decodePrometheus reads samples without labels. The type comes from the # TYPE line,
+// samples of untyped metrics are gauges.
+func decodePrometheus(r io.Reader) ([]models.Metrics, error) {
+	types := make(map[string]string)
+	var metrics []models.Metrics
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.Fields(text)
+		if fields[0] == "#" {
+			if len(fields) == 4 && fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+			continue
+		}
+		if strings.Contains(text, "{") {
+			return nil, fmt.Errorf("line %d: samples with labels are not supported", line)
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: sample has no value", line)
+		}
+
+		tp := types[fields[0]]
+		switch tp {
+		case "", "untyped":
+			tp = string(handlers.GaugeType)
+		case string(handlers.GaugeType), string(handlers.CounterType):
+		default:
+			return nil, fmt.Errorf("line %d: unsupported metric type %q", line, tp)
+		}
+		metric, err := newMetric(tp, fields[0], fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, sc.Err()
+}
@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+)
+
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+type family struct {
+	name  string
+	tp    string
+	value string
+	meta  *models.MetricMeta
+}
+
+// WritePrometheus writes metrics in the Prometheus text format. Metadata, when known,
+// is emitted as # HELP and # UNIT lines before the # TYPE line of the metric.
+func WritePrometheus(w io.Writer, gauges []*models.GaugeMetric, counters []*models.CounterMetric, metas map[string]*models.MetricMeta) error {
+	families := make([]family, 0, len(gauges)+len(counters))
+	for _, gm := range gauges {
+		families = append(families, family{gm.Name, "gauge", strconv.FormatFloat(gm.Value, 'g', -1, 64), metas[gm.Name]})
+	}
+	for _, cm := range counters {
+		families = append(families, family{cm.Name, "counter", strconv.FormatInt(cm.Value, 10), metas[cm.Name]})
+	}
+	slices.SortFunc(families, func(a, b family) int {
+		return cmp.Or(cmp.Compare(a.name, b.name), cmp.Compare(a.tp, b.tp))
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		nm := SanitizeName(f.name)
+		if f.meta != nil && f.meta.Help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", nm, escapeHelp(f.meta.Help))
+		}
+		if f.meta != nil && f.meta.Unit != "" {
+			fmt.Fprintf(bw, "# UNIT %s %s\n", nm, f.meta.Unit)
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", nm, f.tp)
+		fmt.Fprintf(bw, "%s %s\n", nm, f.value)
+	}
+	return bw.Flush()
+}
+
+// SanitizeName maps a metric name to the Prometheus name charset [a-zA-Z_:][a-zA-Z0-9_:]*.
+func SanitizeName(nm string) string {
+	var b strings.Builder
+	for i, r := range nm {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
+	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+var ErrInvalidRename = errors.New("from and to must be different non-empty names")
+
+type MetricsMatchDeleter interface {
+	AllMetricsGetter
+	MetricsAdmin
+}
+
+type RenameRequest struct {
+	MType string `json:"type"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+type DeleteResult struct {
+	Deleted int `json:"deleted"`
+}
+
+// adminError writes the response for errors of admin operations.
+func adminError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, m.ErrMetricNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrInvalidType):
+		http.Error(w, AllowedMetricTypesMsg, http.StatusBadRequest)
+	case errors.Is(err, ErrForbidden), errors.Is(err, m.ErrReservedName):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, m.ErrTypeConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, tenant.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	case errors.Is(err, ErrInvalidRename), errors.Is(err, path.ErrBadPattern):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// DeleteMetricHandler deletes a single metric. onChange is called after every successful change.
+func DeleteMetricHandler(s MetricsAdmin, onChange func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tp := chi.URLParam(r, "tp")
+		nm := chi.URLParam(r, "nm")
+
+		ctx := r.Context()
+		errs := make(chan error, 1)
+
+		go func() {
+			errs <- deleteMetric(ctx, s, tp, nm)
+			close(errs)
+		}()
+
+		select {
+		case <-ctx.Done():
+			http.Error(w, CanceledReqMsg, http.StatusRequestTimeout)
+			return
+		case err := <-errs:
+			if err != nil {
+				adminError(w, err)
+				return
+			}
+			onChange()
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+func deleteMetric(ctx context.Context, s MetricsAdmin, tp, nm string) error {
+	if !auth.Permits(ctx, nm) {
+		return ErrForbidden
+	}
+	switch MetricType(tp) {
+	case GaugeType:
+		return s.DeleteGaugeMetric(ctx, nm)
+	case CounterType:
+		return s.DeleteCounterMetric(ctx, nm)
+	default:
+		return ErrInvalidType
+	}
+}
+
+func ResetCounterHandler(s MetricsAdmin, onChange func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nm := chi.URLParam(r, "nm")
+
+		ctx := r.Context()
+		errs := make(chan error, 1)
+
+		go func() {
+			errs <- resetCounter(ctx, s, nm)
+			close(errs)
+		}()
+
+		select {
+		case <-ctx.Done():
+			http.Error(w, CanceledReqMsg, http.StatusRequestTimeout)
+			return
+		case err := <-errs:
+			if err != nil {
+				adminError(w, err)
+				return
+			}
+			onChange()
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+func resetCounter(ctx context.Context, s MetricsAdmin, nm string) error {
+	if !auth.Permits(ctx, nm) {
+		return ErrForbidden
+	}
+	return s.ResetCounterMetric(ctx, nm)
+}
+
+// DeleteMatchingHandler deletes every metric whose name matches the glob in the match
+// query parameter, skipping names the token is not allowed to access.
+func DeleteMatchingHandler(s MetricsMatchDeleter, onChange func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pattern := r.URL.Query().Get("match")
+		if pattern == "" {
+			http.Error(w, "match query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		type result struct {
+			deleted int
+			err     error
+		}
+
+		ctx := r.Context()
+		resultChan := make(chan result, 1)
+
+		go func() {
+			deleted, err := deleteMatching(ctx, s, pattern)
+			resultChan <- result{deleted: deleted, err: err}
+			close(resultChan)
+		}()
+
+		select {
+		case <-ctx.Done():
+			http.Error(w, CanceledReqMsg, http.StatusRequestTimeout)
+			return
+		case res := <-resultChan:
+			if res.deleted > 0 {
+				onChange()
+			}
+			if res.err != nil {
+				adminError(w, res.err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			if err := json.NewEncoder(w).Encode(DeleteResult{Deleted: res.deleted}); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+func deleteMatching(ctx context.Context, s MetricsMatchDeleter, pattern string) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+	matches := func(nm string) bool {
+		ok, _ := path.Match(pattern, nm)
+		return ok && auth.Permits(ctx, nm)
+	}
+
+	gauges, err := s.GetAllGaugeMetrics(ctx)
+	if err != nil {
+		return 0, err
+	}
+	counters, err := s.GetAllCounterMetrics(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, gm := range gauges {
+		if !matches(gm.Name) {
+			continue
+		}
+		err = s.DeleteGaugeMetric(ctx, gm.Name)
+		if errors.Is(err, m.ErrMetricNotFound) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	for _, cm := range counters {
+		if !matches(cm.Name) {
+			continue
+		}
+		err = s.DeleteCounterMetric(ctx, cm.Name)
+		if errors.Is(err, m.ErrMetricNotFound) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// RenameMetricHandler moves a metric to a new name, merging it into an existing one:
+// a gauge overwrites the target value, a counter is added to it.
+func RenameMetricHandler(s MetricsAdmin, onChange func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req RenameRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ctx := r.Context()
+		errs := make(chan error, 1)
+
+		go func() {
+			errs <- renameMetric(ctx, s, req)
+			close(errs)
+		}()
+
+		select {
+		case <-ctx.Done():
+			http.Error(w, CanceledReqMsg, http.StatusRequestTimeout)
+			return
+		case err := <-errs:
+			if err != nil {
+				adminError(w, err)
+				return
+			}
+			onChange()
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+func renameMetric(ctx context.Context, s MetricsAdmin, req RenameRequest) error {
+	if req.From == "" || req.To == "" || req.From == req.To {
+		return ErrInvalidRename
+	}
+	if !auth.Permits(ctx, req.From) || !auth.Permits(ctx, req.To) {
+		return ErrForbidden
+	}
+	switch MetricType(req.MType) {
+	case GaugeType:
+		return s.RenameGaugeMetric(ctx, req.From, req.To)
+	case CounterType:
+		return s.RenameCounterMetric(ctx, req.From, req.To)
+	default:
+		return ErrInvalidType
+	}
+}
+
+// BackupHandler requests a backup ahead of the interval. The backup runs in the background,
+// so the request is only accepted.
+func BackupHandler(trigger func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		trigger()
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
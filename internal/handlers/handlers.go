@@ -1,28 +1,36 @@
 package handlers
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
 	"github.com/volchkovski/go-practicum-metrics/internal/logger"
 	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
 
 	"github.com/go-chi/chi/v5"
 )
 
 var (
-	ErrInvalidType    = fmt.Errorf("allowed metric types: %s, %s", GaugeType, CounterType)
-	ErrMetricNotFound = errors.New("metric is not found")
+	ErrInvalidType   = fmt.Errorf("allowed metric types: %s, %s", GaugeType, CounterType)
+	ErrForbidden     = errors.New("metric name is not allowed for token")
+	ErrBatchTooLarge = errors.New("too many metrics in batch")
 )
 
 var (
 	AllowedMetricTypesMsg = fmt.Sprintf("Allowed metric types: %s, %s", GaugeType, CounterType)
 	CanceledReqMsg        = "Request is canceled"
+	StorageUnavailableMsg = "Storage is not available"
 )
 
 func CollectMetricHandler(s MetricPusher) http.HandlerFunc {
@@ -46,6 +54,22 @@ func CollectMetricHandler(s MetricPusher) http.HandlerFunc {
 			return
 		case err := <-errs:
 			if err != nil {
+				if errors.Is(err, ErrForbidden) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
+				if errors.Is(err, tenant.ErrQuotaExceeded) {
+					http.Error(w, err.Error(), http.StatusInsufficientStorage)
+					return
+				}
+				if errors.Is(err, m.ErrTypeConflict) {
+					http.Error(w, err.Error(), http.StatusConflict)
+					return
+				}
+				if errors.Is(err, m.ErrReservedName) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
@@ -57,6 +81,9 @@ func CollectMetricHandler(s MetricPusher) http.HandlerFunc {
 }
 
 func collectMetric(ctx context.Context, s MetricPusher, tp, nm, val string) error {
+	if !auth.Permits(ctx, nm) {
+		return ErrForbidden
+	}
 	switch MetricType(tp) {
 	case GaugeType:
 		v, err := strconv.ParseFloat(val, 64)
@@ -106,10 +133,14 @@ func MetricHandler(s MetricGetter) http.HandlerFunc {
 			return
 		case res := <-resultChan:
 			if res.err != nil {
-				if errors.Is(res.err, ErrMetricNotFound) {
+				if errors.Is(res.err, m.ErrMetricNotFound) {
 					http.Error(w, res.err.Error(), http.StatusNotFound)
 					return
 				}
+				if errors.Is(res.err, ErrForbidden) {
+					http.Error(w, res.err.Error(), http.StatusForbidden)
+					return
+				}
 				http.Error(w, AllowedMetricTypesMsg, http.StatusBadRequest)
 				return
 			}
@@ -124,25 +155,28 @@ func MetricHandler(s MetricGetter) http.HandlerFunc {
 }
 
 func metricValue(ctx context.Context, s MetricGetter, tp, nm string) (string, error) {
+	if !auth.Permits(ctx, nm) {
+		return "", ErrForbidden
+	}
 	switch MetricType(tp) {
 	case GaugeType:
-		m, err := s.GetGaugeMetric(ctx, nm)
+		gm, err := s.GetGaugeMetric(ctx, nm)
 		if err != nil {
-			return "", ErrMetricNotFound
+			return "", m.ErrMetricNotFound
 		}
-		return strconv.FormatFloat(m.Value, 'f', -1, 64), nil
+		return strconv.FormatFloat(gm.Value, 'f', -1, 64), nil
 	case CounterType:
-		m, err := s.GetCounterMetric(ctx, nm)
+		cm, err := s.GetCounterMetric(ctx, nm)
 		if err != nil {
-			return "", ErrMetricNotFound
+			return "", m.ErrMetricNotFound
 		}
-		return strconv.FormatInt(m.Value, 10), nil
+		return strconv.FormatInt(cm.Value, 10), nil
 	default:
 		return "", ErrInvalidType
 	}
 }
 
-func AllMetricsHandler(s AllMetricsGetter) http.HandlerFunc {
+func AllMetricsHandler(s MetricsPageGetter) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		type result struct {
 			metrics []Metric
@@ -167,33 +201,67 @@ func AllMetricsHandler(s AllMetricsGetter) http.HandlerFunc {
 				http.Error(w, res.err.Error(), http.StatusInternalServerError)
 				return
 			}
-			t, err := template.New("AllMetrics").Parse(HTMLAllMetrics)
-			if err != nil {
-				msg := fmt.Sprintf("Failed to parse html template: %s", err.Error())
-				http.Error(w, msg, http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "text/html")
-			w.WriteHeader(http.StatusOK)
-			err = t.Execute(w, res.metrics)
-			if err != nil {
-				msg := fmt.Sprintf("Failed to put metrics into html template: %s", err.Error())
-				http.Error(w, msg, http.StatusInternalServerError)
-				return
-			}
+			writeMetricsPage(w, r, res.metrics)
 		}
 	}
 }
 
-func allMetrics(ctx context.Context, s AllMetricsGetter) ([]Metric, error) {
+// DashboardHandler serves the metrics page without metrics. A browser cannot send a bearer
+// token when it opens a page, so with authentication enabled the page script fetches the
+// values with a token entered on the page.
+func DashboardHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeMetricsPage(w, r, nil)
+	}
+}
+
+func writeMetricsPage(w http.ResponseWriter, r *http.Request, metrics []Metric) {
+	t, err := template.New("AllMetrics").Parse(HTMLAllMetrics)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to parse html template: %s", err.Error())
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+	page := struct {
+		Metrics   []Metric
+		ValuesURL string
+	}{
+		Metrics:   metrics,
+		ValuesURL: strings.TrimSuffix(r.URL.Path, "/") + "/values/",
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	err = t.Execute(w, page)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to put metrics into html template: %s", err.Error())
+		http.Error(w, msg, http.StatusInternalServerError)
+		return
+	}
+}
+
+func allMetrics(ctx context.Context, s MetricsPageGetter) ([]Metric, error) {
 	metrics := make([]Metric, 0, 50)
 
+	metas, err := s.GetAllMetricMeta(ctx)
+	if err != nil {
+		return nil, err
+	}
+	describe := func(metric *Metric) {
+		if meta, ok := metas[metric.Name]; ok {
+			metric.Unit, metric.Help = meta.Unit, meta.Help
+		}
+	}
+
 	gaugeMetrics, err := s.GetAllGaugeMetrics(ctx)
 	if err != nil {
 		return nil, err
 	}
 	for _, gm := range gaugeMetrics {
-		metric := Metric{gm.Name, strconv.FormatFloat(gm.Value, 'f', 2, 64)}
+		if !auth.Permits(ctx, gm.Name) {
+			continue
+		}
+		metric := Metric{Name: gm.Name, Type: string(GaugeType), Value: strconv.FormatFloat(gm.Value, 'f', -1, 64)}
+		describe(&metric)
 		metrics = append(metrics, metric)
 	}
 
@@ -202,9 +270,82 @@ func allMetrics(ctx context.Context, s AllMetricsGetter) ([]Metric, error) {
 		return nil, err
 	}
 	for _, cm := range counterMetrics {
-		metric := Metric{cm.Name, strconv.FormatInt(cm.Value, 10)}
+		if !auth.Permits(ctx, cm.Name) {
+			continue
+		}
+		metric := Metric{Name: cm.Name, Type: string(CounterType), Value: strconv.FormatInt(cm.Value, 10)}
+		describe(&metric)
 		metrics = append(metrics, metric)
 	}
+
+	slices.SortFunc(metrics, func(a, b Metric) int {
+		return cmp.Or(cmp.Compare(a.Name, b.Name), cmp.Compare(a.Type, b.Type))
+	})
+	return metrics, nil
+}
+
+func AllMetricsHandlerJSON(s AllMetricsGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type result struct {
+			metrics []m.Metrics
+			err     error
+		}
+
+		ctx := r.Context()
+		resultChan := make(chan result, 1)
+
+		go func() {
+			metrics, err := allMetricsJSON(ctx, s)
+			resultChan <- result{metrics: metrics, err: err}
+			close(resultChan)
+		}()
+
+		select {
+		case <-ctx.Done():
+			http.Error(w, CanceledReqMsg, http.StatusRequestTimeout)
+			return
+		case res := <-resultChan:
+			if res.err != nil {
+				http.Error(w, res.err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			if err := json.NewEncoder(w).Encode(res.metrics); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+func allMetricsJSON(ctx context.Context, s AllMetricsGetter) ([]m.Metrics, error) {
+	gaugeMetrics, err := s.GetAllGaugeMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counterMetrics, err := s.GetAllCounterMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]m.Metrics, 0, len(gaugeMetrics)+len(counterMetrics))
+	for _, gm := range gaugeMetrics {
+		if !auth.Permits(ctx, gm.Name) {
+			continue
+		}
+		metrics = append(metrics, m.Metrics{ID: gm.Name, MType: string(GaugeType), Value: &gm.Value})
+	}
+	for _, cm := range counterMetrics {
+		if !auth.Permits(ctx, cm.Name) {
+			continue
+		}
+		metrics = append(metrics, m.Metrics{ID: cm.Name, MType: string(CounterType), Delta: &cm.Value})
+	}
+
+	slices.SortFunc(metrics, func(a, b m.Metrics) int {
+		return cmp.Or(cmp.Compare(a.ID, b.ID), cmp.Compare(a.MType, b.MType))
+	})
 	return metrics, nil
 }
 
@@ -213,7 +354,7 @@ func CollectMetricHandlerJSON(s MetricPusher) http.HandlerFunc {
 		var metric m.Metrics
 
 		if err := json.NewDecoder(r.Body).Decode(&metric); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), decodeErrorStatus(err, http.StatusBadRequest))
 			return
 		}
 
@@ -235,6 +376,22 @@ func CollectMetricHandlerJSON(s MetricPusher) http.HandlerFunc {
 					http.Error(w, AllowedMetricTypesMsg, http.StatusBadRequest)
 					return
 				}
+				if errors.Is(err, ErrForbidden) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
+				if errors.Is(err, tenant.ErrQuotaExceeded) {
+					http.Error(w, err.Error(), http.StatusInsufficientStorage)
+					return
+				}
+				if errors.Is(err, m.ErrTypeConflict) {
+					http.Error(w, err.Error(), http.StatusConflict)
+					return
+				}
+				if errors.Is(err, m.ErrReservedName) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
@@ -249,6 +406,9 @@ func CollectMetricHandlerJSON(s MetricPusher) http.HandlerFunc {
 }
 
 func collectMetricJSON(ctx context.Context, s MetricPusher, metric m.Metrics) error {
+	if !auth.Permits(ctx, metric.ID) {
+		return ErrForbidden
+	}
 	switch MetricType(metric.MType) {
 	case GaugeType:
 		if err := s.PushGaugeMetric(ctx, &m.GaugeMetric{Name: metric.ID, Value: *metric.Value}); err != nil {
@@ -269,7 +429,7 @@ func MetricHandlerJSON(s MetricGetter) http.HandlerFunc {
 		metric := new(m.Metrics)
 
 		if err := json.NewDecoder(r.Body).Decode(metric); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), decodeErrorStatus(err, http.StatusBadRequest))
 			return
 		}
 
@@ -293,7 +453,7 @@ func MetricHandlerJSON(s MetricGetter) http.HandlerFunc {
 			return
 		case res := <-resultChan:
 			if res.err != nil {
-				if errors.Is(res.err, ErrMetricNotFound) {
+				if errors.Is(res.err, m.ErrMetricNotFound) {
 					http.Error(w, res.err.Error(), http.StatusNotFound)
 					return
 				}
@@ -301,6 +461,10 @@ func MetricHandlerJSON(s MetricGetter) http.HandlerFunc {
 					http.Error(w, res.err.Error(), http.StatusBadRequest)
 					return
 				}
+				if errors.Is(res.err, ErrForbidden) {
+					http.Error(w, res.err.Error(), http.StatusForbidden)
+					return
+				}
 				http.Error(w, "Internal server error", http.StatusInternalServerError)
 				return
 			}
@@ -315,17 +479,20 @@ func MetricHandlerJSON(s MetricGetter) http.HandlerFunc {
 }
 
 func metricJSON(ctx context.Context, s MetricGetter, metric *m.Metrics) (*m.Metrics, error) {
+	if !auth.Permits(ctx, metric.ID) {
+		return nil, ErrForbidden
+	}
 	switch MetricType(metric.MType) {
 	case GaugeType:
 		gm, err := s.GetGaugeMetric(ctx, metric.ID)
 		if err != nil {
-			return nil, ErrMetricNotFound
+			return nil, m.ErrMetricNotFound
 		}
 		metric.Value = &gm.Value
 	case CounterType:
 		cm, err := s.GetCounterMetric(ctx, metric.ID)
 		if err != nil {
-			return nil, ErrMetricNotFound
+			return nil, m.ErrMetricNotFound
 		}
 		metric.Delta = &cm.Value
 	default:
@@ -350,8 +517,8 @@ func PingDB(s DBPinger) http.HandlerFunc {
 			return
 		case err := <-errs:
 			if err != nil {
-				logger.Log.Infof("PingDB error: %s", err.Error())
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				logger.Log.Errorf("PingDB error: %s", err.Error())
+				http.Error(w, StorageUnavailableMsg, http.StatusInternalServerError)
 				return
 			}
 			w.WriteHeader(http.StatusOK)
@@ -359,12 +526,18 @@ func PingDB(s DBPinger) http.HandlerFunc {
 	}
 }
 
-func CollectMetricsHandlerJSON(s MetricsPusher) http.HandlerFunc {
+// CollectMetricsHandlerJSON accepts a batch of metrics. Batches longer than maxBatch
+// are rejected, zero means unlimited.
+func CollectMetricsHandlerJSON(s MetricsPusher, maxBatch int) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var metrics []m.Metrics
-		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+		metrics, err := decodeBatch(r.Body, maxBatch)
+		if err != nil {
+			if errors.Is(err, ErrBatchTooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
 			logger.Log.Errorf("collectMetricsHandlerJson - failed to decode request body: %s", err.Error())
-			http.Error(w, "Failed to decode request body", http.StatusInternalServerError)
+			http.Error(w, "Failed to decode request body", decodeErrorStatus(err, http.StatusInternalServerError))
 			return
 		}
 		ctx := r.Context()
@@ -385,6 +558,22 @@ func CollectMetricsHandlerJSON(s MetricsPusher) http.HandlerFunc {
 					http.Error(w, AllowedMetricTypesMsg, http.StatusBadRequest)
 					return
 				}
+				if errors.Is(err, ErrForbidden) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
+				if errors.Is(err, tenant.ErrQuotaExceeded) {
+					http.Error(w, err.Error(), http.StatusInsufficientStorage)
+					return
+				}
+				if errors.Is(err, m.ErrTypeConflict) {
+					http.Error(w, err.Error(), http.StatusConflict)
+					return
+				}
+				if errors.Is(err, m.ErrReservedName) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
@@ -393,10 +582,54 @@ func CollectMetricsHandlerJSON(s MetricsPusher) http.HandlerFunc {
 	}
 }
 
+// decodeBatch decodes the array element by element to stop early on oversized batches.
+func decodeBatch(body io.Reader, maxBatch int) ([]m.Metrics, error) {
+	dec := json.NewDecoder(body)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	// A null body is an empty batch, as it always was.
+	if tok == nil {
+		return nil, nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, errors.New("request body must be a JSON array")
+	}
+
+	metrics := make([]m.Metrics, 0, 50)
+	for dec.More() {
+		if maxBatch > 0 && len(metrics) == maxBatch {
+			return nil, fmt.Errorf("%w: limit is %d metrics", ErrBatchTooLarge, maxBatch)
+		}
+		var metric m.Metrics
+		if err = dec.Decode(&metric); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+	if _, err = dec.Token(); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// decodeErrorStatus maps body read errors to 413 when the body limit was hit.
+func decodeErrorStatus(err error, fallback int) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return fallback
+}
+
 func collectMetrics(ctx context.Context, s MetricsPusher, metrics []m.Metrics) error {
 	gauges := make([]*m.GaugeMetric, 0, 50)
 	counters := make([]*m.CounterMetric, 0, 10)
 	for _, metric := range metrics {
+		if !auth.Permits(ctx, metric.ID) {
+			return ErrForbidden
+		}
 		switch MetricType(metric.MType) {
 		case GaugeType:
 			gauge := m.GaugeMetric{
@@ -415,7 +648,7 @@ func collectMetrics(ctx context.Context, s MetricsPusher, metrics []m.Metrics) e
 		}
 	}
 	if err := s.PushMetrics(ctx, gauges, counters); err != nil {
-		return fmt.Errorf("failed to push metrics: %s", err.Error())
+		return fmt.Errorf("failed to push metrics: %w", err)
 	}
 	return nil
 }
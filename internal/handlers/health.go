@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/health"
+)
+
+type ReadinessReporter interface {
+	Readiness(context.Context) health.Report
+}
+
+// HealthzHandler reports that the process is alive, it never checks dependencies.
+func HealthzHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": health.StatusOK})
+	}
+}
+
+// ReadyzHandler answers 200 when the server takes traffic and 503 otherwise,
+// with the breakdown of checks in both cases.
+func ReadyzHandler(rr ReadinessReporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := rr.Readiness(r.Context())
+		status := http.StatusOK
+		if !report.Ready() {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
+	"github.com/volchkovski/go-practicum-metrics/internal/exporter"
+	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+)
+
+// metaError writes the response for errors of metadata operations.
+func metaError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, m.ErrMetricNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, m.ErrInvalidMeta):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, m.ErrTypeConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func MetaHandler(s MetaGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nm := chi.URLParam(r, "nm")
+
+		type result struct {
+			meta *m.MetricMeta
+			err  error
+		}
+
+		ctx := r.Context()
+		resultChan := make(chan result, 1)
+
+		go func() {
+			meta, err := metricMeta(ctx, s, nm)
+			resultChan <- result{meta: meta, err: err}
+			close(resultChan)
+		}()
+
+		select {
+		case <-ctx.Done():
+			http.Error(w, CanceledReqMsg, http.StatusRequestTimeout)
+			return
+		case res := <-resultChan:
+			if res.err != nil {
+				metaError(w, res.err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			if err := json.NewEncoder(w).Encode(res.meta); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+func metricMeta(ctx context.Context, s MetaGetter, nm string) (*m.MetricMeta, error) {
+	if !auth.Permits(ctx, nm) {
+		return nil, ErrForbidden
+	}
+	return s.GetMetricMeta(ctx, nm)
+}
+
+// PutMetaHandler declares metadata of the metric named in the URL. A declared type
+// locks the metric: pushes of the other type are refused with 409.
+func PutMetaHandler(s MetaPutter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var meta m.MetricMeta
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		meta.Name = chi.URLParam(r, "nm")
+
+		ctx := r.Context()
+		errs := make(chan error, 1)
+
+		go func() {
+			errs <- putMetricMeta(ctx, s, &meta)
+			close(errs)
+		}()
+
+		select {
+		case <-ctx.Done():
+			http.Error(w, CanceledReqMsg, http.StatusRequestTimeout)
+			return
+		case err := <-errs:
+			if err != nil {
+				metaError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+func putMetricMeta(ctx context.Context, s MetaPutter, meta *m.MetricMeta) error {
+	if !auth.Permits(ctx, meta.Name) {
+		return ErrForbidden
+	}
+	return s.PutMetricMeta(ctx, meta)
+}
+
+// PrometheusHandler exposes all metrics in the Prometheus text format.
+func PrometheusHandler(s MetricsPageGetter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type result struct {
+			body []byte
+			err  error
+		}
+
+		ctx := r.Context()
+		resultChan := make(chan result, 1)
+
+		go func() {
+			body, err := prometheusMetrics(ctx, s)
+			resultChan <- result{body: body, err: err}
+			close(resultChan)
+		}()
+
+		select {
+		case <-ctx.Done():
+			http.Error(w, CanceledReqMsg, http.StatusRequestTimeout)
+			return
+		case res := <-resultChan:
+			if res.err != nil {
+				http.Error(w, res.err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", exporter.PrometheusContentType)
+			w.WriteHeader(http.StatusOK)
+			if _, err := w.Write(res.body); err != nil {
+				http.Error(w, "Failed to write body: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+}
+
+func prometheusMetrics(ctx context.Context, s MetricsPageGetter) ([]byte, error) {
+	gauges, err := s.GetAllGaugeMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counters, err := s.GetAllCounterMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metas, err := s.GetAllMetricMeta(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	permittedGauges := make([]*m.GaugeMetric, 0, len(gauges))
+	for _, gm := range gauges {
+		if auth.Permits(ctx, gm.Name) {
+			permittedGauges = append(permittedGauges, gm)
+		}
+	}
+	permittedCounters := make([]*m.CounterMetric, 0, len(counters))
+	for _, cm := range counters {
+		if auth.Permits(ctx, cm.Name) {
+			permittedCounters = append(permittedCounters, cm)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = exporter.WritePrometheus(&buf, permittedGauges, permittedCounters, metas); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
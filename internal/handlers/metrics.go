@@ -9,7 +9,10 @@ type MetricType string
 
 type Metric struct {
 	Name  string
+	Type  string
 	Value string
+	Unit  string
+	Help  string
 }
 
 const (
@@ -39,3 +42,28 @@ type AllMetricsGetter interface {
 type DBPinger interface {
 	PingDB(context.Context) error
 }
+
+type MetricsAdmin interface {
+	DeleteGaugeMetric(context.Context, string) error
+	DeleteCounterMetric(context.Context, string) error
+	ResetCounterMetric(context.Context, string) error
+	RenameGaugeMetric(ctx context.Context, from, to string) error
+	RenameCounterMetric(ctx context.Context, from, to string) error
+}
+
+type MetaGetter interface {
+	GetMetricMeta(context.Context, string) (*m.MetricMeta, error)
+}
+
+type AllMetaGetter interface {
+	GetAllMetricMeta(context.Context) (map[string]*m.MetricMeta, error)
+}
+
+type MetaPutter interface {
+	PutMetricMeta(context.Context, *m.MetricMeta) error
+}
+
+type MetricsPageGetter interface {
+	AllMetricsGetter
+	AllMetaGetter
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed static/*
+var staticFS embed.FS
+
+// StaticHandler serves the dashboard assets embedded into the binary.
+// Files are looked up by the full request path, so it must be mounted at /static/.
+func StaticHandler() http.Handler {
+	return http.FileServer(http.FS(staticFS))
+}
@@ -0,0 +1,220 @@
+(function () {
+    "use strict";
+
+    var historySize = 60;
+    var sparkWidth = 120;
+    var sparkHeight = 24;
+
+    var tbody = document.querySelector("#metrics tbody");
+    var filterInput = document.getElementById("filter");
+    var refreshSelect = document.getElementById("refresh");
+    var statusEl = document.getElementById("status");
+    var tokenInput = document.getElementById("token");
+    var headers = document.querySelectorAll("#metrics th[data-sort]");
+
+    var history = {};
+    var sortKey = "name";
+    var sortDir = 1;
+    var timer = null;
+    // With authentication enabled the values endpoint needs a read token. It is kept for
+    // the browser session only.
+    var tokenKey = "metricsToken";
+
+    function key(type, name) {
+        return type + ":" + name;
+    }
+
+    function push(type, name, value) {
+        var k = key(type, name);
+        var h = history[k] || (history[k] = []);
+        h.push(Number(value));
+        if (h.length > historySize) {
+            h.shift();
+        }
+        return h;
+    }
+
+    function sparkline(values) {
+        var ns = "http://www.w3.org/2000/svg";
+        var svg = document.createElementNS(ns, "svg");
+        svg.setAttribute("width", sparkWidth);
+        svg.setAttribute("height", sparkHeight);
+        if (values.length < 2) {
+            return svg;
+        }
+        var min = Math.min.apply(null, values);
+        var max = Math.max.apply(null, values);
+        var span = max - min || 1;
+        var step = sparkWidth / (historySize - 1);
+        var offset = sparkWidth - step * (values.length - 1);
+        var points = values.map(function (v, i) {
+            var x = offset + i * step;
+            var y = sparkHeight - 1 - ((v - min) / span) * (sparkHeight - 2);
+            return x.toFixed(1) + "," + y.toFixed(1);
+        });
+        var line = document.createElementNS(ns, "polyline");
+        line.setAttribute("points", points.join(" "));
+        svg.appendChild(line);
+        return svg;
+    }
+
+    function makeRow(type, name, value) {
+        var tr = document.createElement("tr");
+        tr.dataset.name = name;
+        tr.dataset.type = type;
+        tr.dataset.value = value;
+        tr.dataset.unit = "";
+        [name, type, value, "", ""].forEach(function (text, i) {
+            var td = document.createElement("td");
+            td.textContent = text;
+            if (i === 2) {
+                td.className = "value";
+            }
+            if (i === 4) {
+                td.className = "spark";
+            }
+            tr.appendChild(td);
+        });
+        return tr;
+    }
+
+    function drawSpark(tr) {
+        var cell = tr.querySelector("td.spark");
+        var h = history[key(tr.dataset.type, tr.dataset.name)] || [];
+        cell.replaceChildren(sparkline(h));
+    }
+
+    function compare(a, b) {
+        var av = a.dataset[sortKey];
+        var bv = b.dataset[sortKey];
+        if (sortKey === "value") {
+            return (Number(av) - Number(bv)) * sortDir;
+        }
+        return av.localeCompare(bv) * sortDir;
+    }
+
+    function sortRows() {
+        var rows = Array.prototype.slice.call(tbody.rows);
+        rows.sort(compare);
+        rows.forEach(function (tr) {
+            tbody.appendChild(tr);
+        });
+        headers.forEach(function (th) {
+            th.classList.remove("asc", "desc");
+            if (th.dataset.sort === sortKey) {
+                th.classList.add(sortDir > 0 ? "asc" : "desc");
+            }
+        });
+    }
+
+    function applyFilter() {
+        var q = filterInput.value.trim().toLowerCase();
+        Array.prototype.forEach.call(tbody.rows, function (tr) {
+            var text = (tr.dataset.name + " " + tr.dataset.type).toLowerCase();
+            tr.classList.toggle("hidden", q !== "" && text.indexOf(q) === -1);
+        });
+    }
+
+    function render(metrics) {
+        var existing = {};
+        Array.prototype.forEach.call(tbody.rows, function (tr) {
+            existing[key(tr.dataset.type, tr.dataset.name)] = tr;
+        });
+        var seen = {};
+        metrics.forEach(function (m) {
+            var value = m.type === "counter" ? String(m.delta) : String(m.value);
+            var k = key(m.type, m.id);
+            var tr = existing[k];
+            if (!tr) {
+                tr = makeRow(m.type, m.id, value);
+                tbody.appendChild(tr);
+            } else if (tr.dataset.value !== value) {
+                tr.dataset.value = value;
+                tr.querySelector("td.value").textContent = value;
+            }
+            push(m.type, m.id, value);
+            drawSpark(tr);
+            seen[k] = true;
+        });
+        Object.keys(existing).forEach(function (k) {
+            if (!seen[k]) {
+                existing[k].remove();
+                delete history[k];
+            }
+        });
+        sortRows();
+        applyFilter();
+    }
+
+    function refresh() {
+        var reqHeaders = {"Accept": "application/json"};
+        var token = sessionStorage.getItem(tokenKey);
+        if (token) {
+            reqHeaders["Authorization"] = "Bearer " + token;
+        }
+        fetch(document.body.dataset.valuesUrl || "/values/", {headers: reqHeaders, credentials: "same-origin"})
+            .then(function (resp) {
+                if (resp.status === 401 || resp.status === 403) {
+                    tokenInput.hidden = false;
+                }
+                if (!resp.ok) {
+                    throw new Error("HTTP " + resp.status);
+                }
+                return resp.json();
+            })
+            .then(function (metrics) {
+                render(metrics || []);
+                statusEl.className = "";
+                statusEl.textContent = "updated " + new Date().toLocaleTimeString();
+            })
+            .catch(function (err) {
+                statusEl.className = "error";
+                statusEl.textContent = "refresh failed: " + err.message;
+            });
+    }
+
+    function schedule() {
+        if (timer !== null) {
+            clearInterval(timer);
+            timer = null;
+        }
+        var ms = Number(refreshSelect.value);
+        if (ms > 0) {
+            timer = setInterval(refresh, ms);
+        }
+    }
+
+    headers.forEach(function (th) {
+        th.addEventListener("click", function () {
+            if (sortKey === th.dataset.sort) {
+                sortDir = -sortDir;
+            } else {
+                sortKey = th.dataset.sort;
+                sortDir = 1;
+            }
+            sortRows();
+        });
+    });
+    filterInput.addEventListener("input", applyFilter);
+    refreshSelect.addEventListener("change", schedule);
+    tokenInput.addEventListener("change", function () {
+        var token = tokenInput.value.trim();
+        if (token) {
+            sessionStorage.setItem(tokenKey, token);
+        } else {
+            sessionStorage.removeItem(tokenKey);
+        }
+        refresh();
+    });
+
+    Array.prototype.forEach.call(tbody.rows, function (tr) {
+        push(tr.dataset.type, tr.dataset.name, tr.dataset.value);
+        drawSpark(tr);
+    });
+    sortRows();
+    schedule();
+    // The page comes without values when authentication is enabled.
+    if (tbody.rows.length === 0) {
+        refresh();
+    }
+})();
@@ -7,21 +7,50 @@ const HTMLAllMetrics = `
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <title>Metrics</title>
+    <link rel="stylesheet" href="/static/dashboard.css">
 </head>
-<body>
-    <h1>Metrics</h1>
-    <table border="1">
-        <tr>
-            <th>Name</th>
-            <th>Value</th>
-        </tr>
-        {{range .}}
-        <tr>
-            <td>{{.Name}}</td>
-            <td>{{.Value}}</td>
-        </tr>
+<body data-values-url="{{.ValuesURL}}">
+    <header>
+        <h1>Metrics</h1>
+        <div class="controls">
+            <input id="filter" type="search" placeholder="Filter by name or type" autocomplete="off">
+            <label>
+                Refresh
+                <select id="refresh">
+                    <option value="0">off</option>
+                    <option value="2000">2s</option>
+                    <option value="5000" selected>5s</option>
+                    <option value="10000">10s</option>
+                    <option value="30000">30s</option>
+                </select>
+            </label>
+            <input id="token" type="password" placeholder="Bearer token" autocomplete="off" hidden>
+            <span id="status"></span>
+        </div>
+    </header>
+    <table id="metrics">
+        <thead>
+            <tr>
+                <th data-sort="name">Name</th>
+                <th data-sort="type">Type</th>
+                <th data-sort="value">Value</th>
+                <th data-sort="unit">Unit</th>
+                <th>History</th>
+            </tr>
+        </thead>
+        <tbody>
+        {{range .Metrics}}
+            <tr data-name="{{.Name}}" data-type="{{.Type}}" data-value="{{.Value}}" data-unit="{{.Unit}}">
+                <td{{if .Help}} title="{{.Help}}"{{end}}>{{.Name}}</td>
+                <td>{{.Type}}</td>
+                <td class="value">{{.Value}}</td>
+                <td>{{.Unit}}</td>
+                <td class="spark"></td>
+            </tr>
         {{end}}
+        </tbody>
     </table>
+    <script src="/static/dashboard.js"></script>
 </body>
 </html>
 `
@@ -0,0 +1,38 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckerReadiness(t *testing.T) {
+	c := NewChecker()
+	failing := errors.New("down")
+	var storageErr error
+	c.Add("storage", func(context.Context) error { return storageErr })
+	c.Add("backup", func(context.Context) error { return nil })
+
+	report := c.Readiness(context.Background())
+	assert.False(t, report.Ready(), "not ready while starting")
+	assert.Equal(t, PhaseStarting, report.Phase)
+	assert.Equal(t, StatusOK, report.Checks["storage"].Status)
+	assert.False(t, c.Started())
+
+	c.SetPhase(PhaseReady)
+	assert.True(t, c.Started())
+	assert.True(t, c.Readiness(context.Background()).Ready())
+
+	storageErr = failing
+	report = c.Readiness(context.Background())
+	assert.False(t, report.Ready())
+	assert.Equal(t, CheckResult{Status: StatusFail, Error: "down"}, CheckResult{Status: report.Checks["storage"].Status, Error: report.Checks["storage"].Error})
+	assert.Equal(t, StatusOK, report.Checks["backup"].Status)
+
+	storageErr = nil
+	c.SetPhase(PhaseDraining)
+	assert.False(t, c.Readiness(context.Background()).Ready(), "not ready while draining")
+	assert.True(t, c.Started(), "writes are still accepted while draining")
+}
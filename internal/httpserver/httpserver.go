@@ -1,28 +1,48 @@
 package httpserver
 
 import (
+	"context"
+	"crypto/tls"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 )
 
 type HTTPServer struct {
-	router chi.Router
-	addr   string
+	server *http.Server
 	notify chan error
 }
 
-func New(r chi.Router, addr string) *HTTPServer {
+type Option func(*http.Server)
+
+// WithTLS serves HTTPS using the certificates provided by cfg.
+func WithTLS(cfg *tls.Config) Option {
+	return func(s *http.Server) {
+		s.TLSConfig = cfg
+	}
+}
+
+func New(r chi.Router, addr string, opts ...Option) *HTTPServer {
+	server := &http.Server{
+		Addr:    addr,
+		Handler: r,
+	}
+	for _, opt := range opts {
+		opt(server)
+	}
 	return &HTTPServer{
-		router: r,
-		addr:   addr,
+		server: server,
 		notify: make(chan error, 1),
 	}
 }
 
 func (s *HTTPServer) Start() {
 	go func() {
-		s.notify <- http.ListenAndServe(s.addr, s.router)
+		if s.server.TLSConfig != nil {
+			s.notify <- s.server.ListenAndServeTLS("", "")
+		} else {
+			s.notify <- s.server.ListenAndServe()
+		}
 		close(s.notify)
 	}()
 }
@@ -30,3 +50,8 @@ func (s *HTTPServer) Start() {
 func (s *HTTPServer) Notify() chan error {
 	return s.notify
 }
+
+// Shutdown stops accepting connections and waits for active requests until ctx is done.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
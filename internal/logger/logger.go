@@ -13,9 +13,14 @@ const (
 
 var Log *zap.SugaredLogger = zap.NewNop().Sugar()
 
-func Initialize(level string, env string) error {
-	lvl, err := zap.ParseAtomicLevel(level)
-	if err != nil {
+// level is shared by the logger built by Initialize, so that SetLevel changes it while
+// Log is in use.
+var level = zap.NewAtomicLevel()
+
+// Initialize builds Log. It must run before Log is used concurrently, later changes of the
+// level go through SetLevel.
+func Initialize(lvl string, env string) error {
+	if err := SetLevel(lvl); err != nil {
 		return err
 	}
 
@@ -29,7 +34,7 @@ func Initialize(level string, env string) error {
 		return fmt.Errorf("logger valid environment values: %s, %s", ProductionEnv, LocalEnv)
 	}
 
-	cfg.Level = lvl
+	cfg.Level = level
 
 	zl, err := cfg.Build()
 	if err != nil {
@@ -39,3 +44,8 @@ func Initialize(level string, env string) error {
 	Log = zl.Sugar()
 	return nil
 }
+
+// SetLevel changes the level of Log, it is safe while Log is in use.
+func SetLevel(lvl string) error {
+	return level.UnmarshalText([]byte(lvl))
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
+	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+)
+
+const bearerPrefix = "Bearer "
+
+// WithAuth requires a bearer token with the given scope.
+// When the route has a {nm} parameter the token prefix is checked against it as well.
+func WithAuth(store auth.Store, scope auth.Scope) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		authFn := func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(header, bearerPrefix) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "Authorization required", http.StatusUnauthorized)
+				return
+			}
+
+			t, err := store.Lookup(r.Context(), strings.TrimPrefix(header, bearerPrefix))
+			if err != nil {
+				if !errors.Is(err, auth.ErrUnknownToken) {
+					logger.Log.Errorf("Token lookup failed: %s", err.Error())
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			if !t.HasScope(scope) {
+				http.Error(w, "Token has no "+string(scope)+" scope", http.StatusForbidden)
+				return
+			}
+			if nm := chi.URLParam(r, "nm"); nm != "" && !t.Allows(nm) {
+				http.Error(w, "Metric name is not allowed for token", http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(w, r.WithContext(auth.WithToken(r.Context(), t)))
+		}
+		return http.HandlerFunc(authFn)
+	}
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
+)
+
+func TestWithAuth(t *testing.T) {
+	tokens, err := auth.NewStaticStore([]*auth.Token{
+		{Value: "reader", Scopes: []auth.Scope{auth.ScopeRead}},
+		{Value: "app", Scopes: []auth.Scope{auth.ScopeRead}, Prefix: "app."},
+		{Value: "admin", Scopes: []auth.Scope{auth.ScopeAdmin}},
+	})
+	require.NoError(t, err)
+
+	var got *auth.Token
+	r := chi.NewRouter()
+	r.With(WithAuth(tokens, auth.ScopeRead)).Get("/value/{nm}", func(w http.ResponseWriter, r *http.Request) {
+		got, _ = auth.FromContext(r.Context())
+	})
+	r.With(WithAuth(tokens, auth.ScopeWrite)).Post("/update/{nm}", func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header string
+		status int
+	}{
+		{name: "missing", method: http.MethodGet, path: "/value/a", status: http.StatusUnauthorized},
+		{name: "not bearer", method: http.MethodGet, path: "/value/a", header: "Basic cmVhZGVy", status: http.StatusUnauthorized},
+		{name: "unknown", method: http.MethodGet, path: "/value/a", header: "Bearer nope", status: http.StatusUnauthorized},
+		{name: "scope", method: http.MethodGet, path: "/value/a", header: "Bearer reader", status: http.StatusOK},
+		{name: "missing scope", method: http.MethodPost, path: "/update/a", header: "Bearer reader", status: http.StatusForbidden},
+		{name: "admin grants scope", method: http.MethodPost, path: "/update/a", header: "Bearer admin", status: http.StatusOK},
+		{name: "within prefix", method: http.MethodGet, path: "/value/app.a", header: "Bearer app", status: http.StatusOK},
+		{name: "outside prefix", method: http.MethodGet, path: "/value/a", header: "Bearer app", status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusUnauthorized {
+				assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+	require.NotNil(t, got)
+	assert.Equal(t, "app", got.Value)
+}
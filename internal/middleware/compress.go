@@ -2,22 +2,61 @@ package middleware
 
 import (
 	"compress/gzip"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
 
 	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
 )
 
+// byteCounter counts bytes passing through a reader or a writer.
+type byteCounter struct {
+	r io.ReadCloser
+	w io.Writer
+	n int64
+}
+
+func (c *byteCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *byteCounter) Close() error {
+	return c.r.Close()
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// observeRatio records raw and compressed sizes of one body and their ratio.
+func observeRatio(kind string, raw, wire int64) {
+	if raw == 0 || wire == 0 {
+		return
+	}
+	selfmetrics.Default.Add("gzip."+kind+"_bytes_raw", raw)
+	selfmetrics.Default.Add("gzip."+kind+"_bytes_wire", wire)
+	selfmetrics.Default.Set("gzip."+kind+"_ratio_last", float64(raw)/float64(wire))
+}
+
 type compressWriter struct {
-	w  http.ResponseWriter
-	zw *gzip.Writer
+	w    http.ResponseWriter
+	zw   *gzip.Writer
+	raw  int64
+	wire *byteCounter
 }
 
 func newCompressWriter(w http.ResponseWriter) *compressWriter {
+	wire := &byteCounter{w: w}
 	return &compressWriter{
-		w:  w,
-		zw: gzip.NewWriter(w),
+		w:    w,
+		zw:   gzip.NewWriter(wire),
+		wire: wire,
 	}
 }
 
@@ -26,7 +65,9 @@ func (c *compressWriter) Header() http.Header {
 }
 
 func (c *compressWriter) Write(p []byte) (int, error) {
-	return c.zw.Write(p)
+	n, err := c.zw.Write(p)
+	c.raw += int64(n)
+	return n, err
 }
 
 func (c *compressWriter) WriteHeader(code int) {
@@ -37,70 +78,133 @@ func (c *compressWriter) WriteHeader(code int) {
 }
 
 func (c *compressWriter) Close() error {
-	return c.zw.Close()
+	err := c.zw.Close()
+	observeRatio("response", c.raw, c.wire.n)
+	return err
 }
 
 type compressReader struct {
-	r  io.ReadCloser
-	zr *gzip.Reader
+	r    io.ReadCloser
+	zr   *gzip.Reader
+	raw  int64
+	wire *byteCounter
 }
 
 func newCompressReader(r io.ReadCloser) (*compressReader, error) {
-	zr, err := gzip.NewReader(r)
+	wire := &byteCounter{r: r}
+	zr, err := gzip.NewReader(wire)
 	if err != nil {
 		return nil, err
 	}
 	return &compressReader{
-		r:  r,
-		zr: zr,
+		r:    wire,
+		zr:   zr,
+		wire: wire,
 	}, nil
 }
 
 func (c *compressReader) Read(p []byte) (int, error) {
-	return c.zr.Read(p)
+	n, err := c.zr.Read(p)
+	c.raw += int64(n)
+	return n, err
 }
 
 func (c *compressReader) Close() error {
+	observeRatio("request", c.raw, c.wire.n)
 	if err := c.r.Close(); err != nil {
 		return err
 	}
 	return c.zr.Close()
 }
 
-func WithCompress(h http.Handler) http.Handler {
-	compressFn := func(w http.ResponseWriter, r *http.Request) {
-		ow := w
-
-		acceptEncoding := r.Header.Get("Accept-Encoding")
-		if strings.Contains(acceptEncoding, "gzip") {
-			logger.Log.Debugln("Created compressWriter")
-			cw := newCompressWriter(w)
-			ow = cw
-			defer func() {
-				if err := cw.Close(); err != nil {
-					logger.Log.Errorf("Failed to close compressWriter: %s", err.Error())
-				}
-			}()
+// BodyLimits bounds request bodies: Compressed is the size on the wire, Decompressed is the
+// size handlers may read after gzip decoding. Zero means unlimited.
+type BodyLimits struct {
+	Compressed   int64
+	Decompressed int64
+}
+
+type limitedReader struct {
+	r     io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.read >= l.limit {
+		// Probe for one more byte to tell a body of exactly limit bytes from a larger one.
+		var probe [1]byte
+		n, err := l.r.Read(probe[:])
+		if n > 0 {
+			return 0, &http.MaxBytesError{Limit: l.limit}
 		}
+		return 0, err
+	}
+	if rest := l.limit - l.read; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	return n, err
+}
+
+func (l *limitedReader) Close() error {
+	return l.r.Close()
+}
+
+func WithCompress(h http.Handler) http.Handler {
+	return WithCompressLimits(BodyLimits{})(h)
+}
 
-		contentEncoding := r.Header.Get("Content-Encoding")
-		if strings.Contains(contentEncoding, "gzip") {
-			logger.Log.Debug("Created compressReader")
-			cr, err := newCompressReader(r.Body)
-			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+func WithCompressLimits(limits BodyLimits) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		compressFn := func(w http.ResponseWriter, r *http.Request) {
+			ow := w
+
+			acceptEncoding := r.Header.Get("Accept-Encoding")
+			if strings.Contains(acceptEncoding, "gzip") {
+				logger.Log.Debugln("Created compressWriter")
+				cw := newCompressWriter(w)
+				ow = cw
+				defer func() {
+					if err := cw.Close(); err != nil {
+						logger.Log.Errorf("Failed to close compressWriter: %s", err.Error())
+					}
+				}()
 			}
-			r.Body = cr
-			defer func() {
-				if err := cr.Close(); err != nil {
-					logger.Log.Errorf("Failed to close compressReader: %s", err.Error())
+
+			if limits.Compressed > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, limits.Compressed)
+			}
+
+			contentEncoding := r.Header.Get("Content-Encoding")
+			if strings.Contains(contentEncoding, "gzip") {
+				logger.Log.Debug("Created compressReader")
+				cr, err := newCompressReader(r.Body)
+				if err != nil {
+					var maxErr *http.MaxBytesError
+					if errors.As(err, &maxErr) {
+						w.WriteHeader(http.StatusRequestEntityTooLarge)
+						return
+					}
+					w.WriteHeader(http.StatusInternalServerError)
+					return
 				}
-			}()
-		}
+				r.Body = cr
+				defer func() {
+					if err := cr.Close(); err != nil {
+						logger.Log.Errorf("Failed to close compressReader: %s", err.Error())
+					}
+				}()
+			}
+
+			if limits.Decompressed > 0 {
+				r.Body = &limitedReader{r: r.Body, limit: limits.Decompressed}
+			}
 
-		h.ServeHTTP(ow, r)
+			h.ServeHTTP(ow, r)
 
+		}
+		return http.HandlerFunc(compressFn)
 	}
-	return http.HandlerFunc(compressFn)
 }
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+// IdempotencyKeyHeader identifies a request that a client may send more than once, like an
+// agent batch replayed from its spool.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Idempotency acknowledges a request whose key already succeeded without running it again.
+// Keys are remembered per tenant in memory for the window, so a request repeated on another
+// server or after a restart still runs twice.
+type Idempotency struct {
+	window    time.Duration
+	mu        sync.Mutex
+	keys      map[string]time.Time
+	lastSweep time.Time
+}
+
+// inFlight marks keys of requests that are still running.
+var inFlight time.Time
+
+func NewIdempotency(window time.Duration) *Idempotency {
+	return &Idempotency{
+		window:    window,
+		keys:      make(map[string]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// claim reserves the key for a request. It reports whether the key already succeeded and
+// whether the request may run, which it may not while another one with the key runs.
+func (i *Idempotency) claim(key string, now time.Time) (done, ok bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if now.Sub(i.lastSweep) > sweepInterval {
+		i.sweep(now)
+	}
+
+	at, seen := i.keys[key]
+	switch {
+	case seen && at.Equal(inFlight):
+		return false, false
+	case seen && now.Sub(at) <= i.window:
+		return true, false
+	}
+	i.keys[key] = inFlight
+	return false, true
+}
+
+// release records the outcome of a claimed key. A failed request forgets it so that the
+// client can retry.
+func (i *Idempotency) release(key string, succeeded bool, now time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !succeeded {
+		delete(i.keys, key)
+		return
+	}
+	i.keys[key] = now
+}
+
+// sweep drops keys that are out of the window.
+func (i *Idempotency) sweep(now time.Time) {
+	for key, at := range i.keys {
+		if !at.Equal(inFlight) && now.Sub(at) > i.window {
+			delete(i.keys, key)
+		}
+	}
+	i.lastSweep = now
+}
+
+// Handler must run after WithTenant, keys of different tenants do not collide.
+func (i *Idempotency) Handler(h http.Handler) http.Handler {
+	idempotentFn := func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(IdempotencyKeyHeader)
+		if id == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		key := tenant.FromContext(r.Context()) + "/" + id
+
+		done, ok := i.claim(key, time.Now())
+		if done {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !ok {
+			http.Error(w, "Request with the same idempotency key is in progress", http.StatusConflict)
+			return
+		}
+
+		succeeded := false
+		defer func() { i.release(key, succeeded, time.Now()) }()
+		lw := loggingResponseWriter{ResponseWriter: w}
+		h.ServeHTTP(&lw, r)
+		// Handlers that only write the body answer 200.
+		succeeded = lw.status == 0 || lw.status/100 == 2
+	}
+	return http.HandlerFunc(idempotentFn)
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+func TestIdempotency(t *testing.T) {
+	applied := 0
+	status := http.StatusOK
+	h := NewIdempotency(time.Minute).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		applied++
+		w.WriteHeader(status)
+	}))
+	send := func(key, id string) int {
+		r := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+		if key != "" {
+			r.Header.Set(IdempotencyKeyHeader, key)
+		}
+		r = r.WithContext(tenant.WithTenant(r.Context(), id))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("k1", "a"))
+	assert.Equal(t, http.StatusOK, send("k1", "a"))
+	assert.Equal(t, 1, applied, "a resent batch is not applied again")
+
+	assert.Equal(t, http.StatusOK, send("k1", "b"))
+	assert.Equal(t, 2, applied, "keys of tenants are separate")
+
+	assert.Equal(t, http.StatusOK, send("", "a"))
+	assert.Equal(t, http.StatusOK, send("", "a"))
+	assert.Equal(t, 4, applied, "requests without a key always run")
+
+	status = http.StatusInternalServerError
+	assert.Equal(t, http.StatusInternalServerError, send("k2", "a"))
+	status = http.StatusOK
+	assert.Equal(t, http.StatusOK, send("k2", "a"))
+	assert.Equal(t, 6, applied, "a failed request can be retried")
+}
+
+func TestIdempotencyClaim(t *testing.T) {
+	i := NewIdempotency(time.Minute)
+	now := time.Now()
+
+	done, ok := i.claim("k", now)
+	assert.False(t, done)
+	assert.True(t, ok)
+
+	done, ok = i.claim("k", now)
+	assert.False(t, done, "a running request is not done")
+	assert.False(t, ok, "a running request is not run twice")
+
+	i.release("k", true, now)
+	done, _ = i.claim("k", now.Add(time.Minute))
+	assert.True(t, done)
+
+	done, ok = i.claim("k", now.Add(2*time.Minute))
+	assert.False(t, done, "keys expire after the window")
+	assert.True(t, ok)
+}
@@ -4,7 +4,9 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
 )
 
 type loggingResponseWriter struct {
@@ -34,6 +36,7 @@ func WithLogging(h http.Handler) http.Handler {
 		h.ServeHTTP(&lw, r)
 
 		duration := time.Since(start)
+		observeRequest(r, lw.status, duration)
 
 		logger.Log.Infow(
 			"Got request",
@@ -46,6 +49,32 @@ func WithLogging(h http.Handler) http.Handler {
 			"status", lw.status,
 			"size", lw.size,
 		)
+		if lw.status == http.StatusUnauthorized || lw.status == http.StatusForbidden {
+			logger.Log.Warnw(
+				"Authentication failed",
+				"uri", r.RequestURI,
+				"method", r.Method,
+				"status", lw.status,
+				"remote_addr", r.RemoteAddr,
+			)
+		}
 	}
 	return http.HandlerFunc(logFn)
 }
+
+// observeRequest records latency and error responses per route. The route pattern is only
+// known once chi has routed the request, so this runs after the handler.
+func observeRequest(r *http.Request, status int, duration time.Duration) {
+	route := "unmatched"
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		route = selfmetrics.SanitizeRoute(r.Method + " " + rctx.RoutePattern())
+	}
+	name := "http." + route
+	selfmetrics.Default.Observe(name, duration)
+	switch {
+	case status >= http.StatusInternalServerError:
+		selfmetrics.Default.Add(name+".status_5xx", 1)
+	case status >= http.StatusBadRequest:
+		selfmetrics.Default.Add(name+".status_4xx", 1)
+	}
+}
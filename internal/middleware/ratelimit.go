@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
+	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+)
+
+const (
+	// AgentIDHeader names the sending agent. Clients choose it freely, so it only labels
+	// rejections in the log and never identifies a client for limiting.
+	AgentIDHeader = "X-Agent-ID"
+
+	RateLimitByIP    = "ip"
+	RateLimitByToken = "token"
+)
+
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a token bucket per client. Clients are told how long to wait via Retry-After.
+type RateLimiter struct {
+	rate      float64
+	burst     float64
+	by        string
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter allows rate requests per second with bursts of up to burst requests.
+// Clients are identified by IP, or by the authenticated token when by is "token"; requests
+// without one fall back to IP. With "token" the limiter must run after authentication.
+func NewRateLimiter(rate float64, burst int, by string) *RateLimiter {
+	return &RateLimiter{
+		rate:      rate,
+		burst:     math.Max(float64(burst), 1),
+		by:        by,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *RateLimiter) clientKey(r *http.Request) string {
+	if l.by == RateLimitByToken {
+		if t, ok := auth.FromContext(r.Context()); ok {
+			sum := sha256.Sum256([]byte(t.Value))
+			return "token:" + hex.EncodeToString(sum[:])
+		}
+	}
+	return "ip:" + clientIP(r)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allow takes a token from the client bucket or returns how long to wait for one.
+func (l *RateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have refilled completely, they are equal to new ones.
+func (l *RateLimiter) sweep(now time.Time) {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (l *RateLimiter) Handler(h http.Handler) http.Handler {
+	limitFn := func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := l.allow(l.clientKey(r), time.Now())
+		if !ok {
+			logger.Log.Debugf("Rate limited client %s, agent %q", clientIP(r), r.Header.Get(AgentIDHeader))
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(limitFn)
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
+)
+
+func TestRateLimiterKey(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		by     string
+		first  func(*http.Request) *http.Request
+		second func(*http.Request) *http.Request
+		want   int
+	}{
+		{
+			name:   "agent header does not split ip buckets",
+			by:     RateLimitByIP,
+			first:  withAgent("a"),
+			second: withAgent("b"),
+			want:   http.StatusTooManyRequests,
+		},
+		{
+			name:   "agent header does not split token buckets",
+			by:     RateLimitByToken,
+			first:  chain(withToken("t1"), withAgent("a")),
+			second: chain(withToken("t1"), withAgent("b")),
+			want:   http.StatusTooManyRequests,
+		},
+		{
+			name:   "tokens have own buckets",
+			by:     RateLimitByToken,
+			first:  withToken("t1"),
+			second: withToken("t2"),
+			want:   http.StatusOK,
+		},
+		{
+			name:   "no token falls back to ip",
+			by:     RateLimitByToken,
+			first:  withAgent("a"),
+			second: withAgent("b"),
+			want:   http.StatusTooManyRequests,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := NewRateLimiter(0.001, 1, test.by).Handler(ok)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, test.first(httptest.NewRequest(http.MethodPost, "/updates/", nil)))
+			assert.Equal(t, http.StatusOK, rec.Code)
+
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, test.second(httptest.NewRequest(http.MethodPost, "/updates/", nil)))
+			assert.Equal(t, test.want, rec.Code)
+		})
+	}
+}
+
+func withAgent(id string) func(*http.Request) *http.Request {
+	return func(r *http.Request) *http.Request {
+		r.Header.Set(AgentIDHeader, id)
+		return r
+	}
+}
+
+func withToken(value string) func(*http.Request) *http.Request {
+	return func(r *http.Request) *http.Request {
+		tok := &auth.Token{Value: value, Scopes: []auth.Scope{auth.ScopeWrite}}
+		return r.WithContext(auth.WithToken(r.Context(), tok))
+	}
+}
+
+func chain(fs ...func(*http.Request) *http.Request) func(*http.Request) *http.Request {
+	return func(r *http.Request) *http.Request {
+		for _, f := range fs {
+			r = f(r)
+		}
+		return r
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import "net/http"
+
+// WithStarted answers 503 while started reports false, so that writes cannot interleave
+// with startup work like restoring a backup, which would overwrite them.
+func WithStarted(started func() bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		startedFn := func(w http.ResponseWriter, r *http.Request) {
+			if !started() {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Server is starting", http.StatusServiceUnavailable)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(startedFn)
+	}
+}
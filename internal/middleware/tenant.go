@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+// WithTenant resolves the request tenant from the /t/{tenant} URL prefix, the X-Tenant header
+// or the token. A token accesses only its own tenant, the default one when it names none,
+// unless it has the any-tenant scope.
+func WithTenant(h http.Handler) http.Handler {
+	tenantFn := func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "tenant")
+		if id == "" {
+			id = r.Header.Get(tenant.Header)
+		}
+
+		if t, ok := auth.FromContext(r.Context()); ok {
+			own := t.Tenant
+			if own == "" {
+				own = tenant.Default
+			}
+			if id != "" && id != own && !t.AnyTenant() {
+				http.Error(w, "Tenant is not allowed for token", http.StatusForbidden)
+				return
+			}
+			if id == "" {
+				id = own
+			}
+		}
+
+		if id == "" {
+			id = tenant.Default
+		}
+		if !tenant.Valid(id) {
+			http.Error(w, "Invalid tenant", http.StatusBadRequest)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(tenant.WithTenant(r.Context(), id)))
+	}
+	return http.HandlerFunc(tenantFn)
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+func TestWithTenant(t *testing.T) {
+	var got string
+	r := chi.NewRouter()
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		got = tenant.FromContext(r.Context())
+	}
+	r.With(WithTenant).Get("/value", echo)
+	r.With(WithTenant).Get("/t/{tenant}/value", echo)
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		token  *auth.Token
+		status int
+		want   string
+	}{
+		{name: "no auth default", path: "/value", status: http.StatusOK, want: tenant.Default},
+		{name: "no auth header", path: "/value", header: "team-a", status: http.StatusOK, want: "team-a"},
+		{name: "no auth path", path: "/t/team-b/value", status: http.StatusOK, want: "team-b"},
+		{name: "invalid", path: "/value", header: "../etc", status: http.StatusBadRequest},
+		{
+			name:   "token tenant",
+			path:   "/value",
+			token:  &auth.Token{Scopes: []auth.Scope{auth.ScopeRead}, Tenant: "team-a"},
+			status: http.StatusOK,
+			want:   "team-a",
+		},
+		{
+			name:   "token tenant in path",
+			path:   "/t/team-a/value",
+			token:  &auth.Token{Scopes: []auth.Scope{auth.ScopeRead}, Tenant: "team-a"},
+			status: http.StatusOK,
+			want:   "team-a",
+		},
+		{
+			name:   "other tenant in path",
+			path:   "/t/team-b/value",
+			token:  &auth.Token{Scopes: []auth.Scope{auth.ScopeRead}, Tenant: "team-a"},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "token without tenant",
+			path:   "/value",
+			token:  &auth.Token{Scopes: []auth.Scope{auth.ScopeRead}},
+			status: http.StatusOK,
+			want:   tenant.Default,
+		},
+		{
+			name:   "token without tenant in other path",
+			path:   "/t/team-b/value",
+			token:  &auth.Token{Scopes: []auth.Scope{auth.ScopeRead}},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "token without tenant in other header",
+			path:   "/value",
+			header: "team-b",
+			token:  &auth.Token{Scopes: []auth.Scope{auth.ScopeAdmin}},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "any tenant",
+			path:   "/t/team-b/value",
+			token:  &auth.Token{Scopes: []auth.Scope{auth.ScopeRead, auth.ScopeAnyTenant}, Tenant: "team-a"},
+			status: http.StatusOK,
+			want:   "team-b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = ""
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set(tenant.Header, tt.header)
+			}
+			if tt.token != nil {
+				req = req.WithContext(auth.WithToken(req.Context(), tt.token))
+			}
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, req)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,8 @@
+package models
+
+import "errors"
+
+var (
+	ErrMetricNotFound = errors.New("metric not found")
+	ErrReservedName   = errors.New("metric name uses the reserved prefix of server metrics")
+)
@@ -0,0 +1,16 @@
+package models
+
+import "errors"
+
+var (
+	ErrTypeConflict = errors.New("metric type conflicts with declared type")
+	ErrInvalidMeta  = errors.New("invalid metric metadata")
+)
+
+type MetricMeta struct {
+	Name  string `json:"name"`
+	Type  string `json:"type,omitempty"` // объявленный тип: gauge или counter, пустой не ограничивает запись
+	Unit  string `json:"unit,omitempty"`
+	Help  string `json:"help,omitempty"`
+	Owner string `json:"owner,omitempty"`
+}
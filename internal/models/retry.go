@@ -0,0 +1,23 @@
+package models
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTransient wraps a storage failure after which the operation had no effect and may
+// run again. Storage returns it instead of retrying for contexts from WithoutRetries.
+var ErrTransient = errors.New("transient storage failure")
+
+type noRetriesKey struct{}
+
+// WithoutRetries asks storage to run operations once, so that callers holding a lock can
+// release it before they wait and retry.
+func WithoutRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetriesKey{}, true)
+}
+
+// RetriesDisabled reports whether ctx comes from WithoutRetries.
+func RetriesDisabled(ctx context.Context) bool {
+	return ctx.Value(noRetriesKey{}) != nil
+}
@@ -0,0 +1,38 @@
+package routers
+
+import (
+	"context"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/volchkovski/go-practicum-metrics/internal/handlers"
+	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
+)
+
+// registrySource serves the server's own metrics through the read handlers.
+type registrySource struct {
+	reg *selfmetrics.Registry
+}
+
+func (s registrySource) GetAllGaugeMetrics(context.Context) ([]*m.GaugeMetric, error) {
+	gauges, _ := s.reg.Metrics()
+	return gauges, nil
+}
+
+func (s registrySource) GetAllCounterMetrics(context.Context) ([]*m.CounterMetric, error) {
+	_, counters := s.reg.Metrics()
+	return counters, nil
+}
+
+func (s registrySource) GetAllMetricMeta(context.Context) (map[string]*m.MetricMeta, error) {
+	return nil, nil
+}
+
+// NewInternalRouter exposes the server's own metrics, meant for a listener that is not public.
+func NewInternalRouter(reg *selfmetrics.Registry) chi.Router {
+	src := registrySource{reg: reg}
+	r := chi.NewRouter()
+	r.Get(`/metrics`, handlers.PrometheusHandler(src))
+	r.Get(`/values/`, handlers.AllMetricsHandlerJSON(src))
+	return r
+}
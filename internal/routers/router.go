@@ -4,7 +4,9 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
 	"github.com/volchkovski/go-practicum-metrics/internal/handlers"
+	"github.com/volchkovski/go-practicum-metrics/internal/health"
 	mw "github.com/volchkovski/go-practicum-metrics/internal/middleware"
 )
 
@@ -14,24 +16,188 @@ type metricsProcessor interface {
 	handlers.AllMetricsGetter
 	handlers.MetricsPusher
 	handlers.DBPinger
+	handlers.MetricsAdmin
+	handlers.MetaGetter
+	handlers.AllMetaGetter
+	handlers.MetaPutter
 }
 
-func NewMetricRouter(s metricsProcessor) chi.Router {
+type options struct {
+	tokens     auth.Store
+	limiter    *mw.RateLimiter
+	idempotent *mw.Idempotency
+	bodyLimits mw.BodyLimits
+	maxBatch   int
+	admin      bool
+	onChange   func()
+	backup     func()
+	readiness  handlers.ReadinessReporter
+	started    func() bool
+}
+
+type Option func(*options)
+
+// WithTokens enables bearer-token authentication for metric endpoints.
+func WithTokens(store auth.Store) Option {
+	return func(o *options) {
+		o.tokens = store
+	}
+}
+
+// WithRateLimit applies the limiter to ingest endpoints.
+func WithRateLimit(l *mw.RateLimiter) Option {
+	return func(o *options) {
+		o.limiter = l
+	}
+}
+
+// WithIdempotency acknowledges ingest requests repeating a succeeded idempotency key
+// without applying them again.
+func WithIdempotency(i *mw.Idempotency) Option {
+	return func(o *options) {
+		o.idempotent = i
+	}
+}
+
+// WithIngestLimits bounds request bodies, on reads as well, and the number of metrics in
+// a batch.
+func WithIngestLimits(limits mw.BodyLimits, maxBatch int) Option {
+	return func(o *options) {
+		o.bodyLimits = limits
+		o.maxBatch = maxBatch
+	}
+}
+
+// WithAdmin enables endpoints that delete, reset and rename metrics.
+// onChange is called after every successful change, it may be nil.
+func WithAdmin(onChange func()) Option {
+	return func(o *options) {
+		o.admin = true
+		o.onChange = onChange
+	}
+}
+
+// WithBackup enables an admin endpoint requesting a backup through trigger.
+// It takes effect together with WithAdmin.
+func WithBackup(trigger func()) Option {
+	return func(o *options) {
+		o.backup = trigger
+	}
+}
+
+// WithReadiness makes /readyz report rr instead of only pinging the storage.
+func WithReadiness(rr handlers.ReadinessReporter) Option {
+	return func(o *options) {
+		o.readiness = rr
+	}
+}
+
+// WithStartGate answers writes with 503 until started reports true, e.g. while a backup
+// is restored.
+func WithStartGate(started func() bool) Option {
+	return func(o *options) {
+		o.started = started
+	}
+}
+
+func (o *options) startGate() func(http.Handler) http.Handler {
+	if o.started == nil {
+		return func(h http.Handler) http.Handler { return h }
+	}
+	return mw.WithStarted(o.started)
+}
+
+func NewMetricRouter(s metricsProcessor, opts ...Option) chi.Router {
+	o := &options{onChange: func() {}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.onChange == nil {
+		o.onChange = func() {}
+	}
+	if o.readiness == nil {
+		checker := health.NewChecker()
+		checker.Add("storage", s.PingDB)
+		checker.SetPhase(health.PhaseReady)
+		o.readiness = checker
+	}
+
+	requireScope := func(scope auth.Scope) func(http.Handler) http.Handler {
+		if o.tokens == nil {
+			return func(h http.Handler) http.Handler { return h }
+		}
+		return mw.WithAuth(o.tokens, scope)
+	}
+
 	r := chi.NewRouter()
 	r.Use(mw.WithLogging)
-	r.With(mw.WithCompress).Get(`/`, handlers.AllMetricsHandler(s))
 	r.Get(`/ping`, handlers.PingDB(s))
-	r.With(mw.WithCompress).Post(`/updates/`, handlers.CollectMetricsHandlerJSON(s))
-	r.Route(`/update`, func(r chi.Router) {
-		r.With(mw.WithCompress).Post(`/`, handlers.CollectMetricHandlerJSON(s))
-		r.Route(`/{tp}`, func(r chi.Router) {
-			r.Post(`/`, http.NotFound)
-			r.Post(`/{nm}/{val}`, handlers.CollectMetricHandler(s))
-		})
-	})
-	r.Route(`/value`, func(r chi.Router) {
-		r.With(mw.WithCompress).Post(`/`, handlers.MetricHandlerJSON(s))
-		r.Get(`/{tp}/{nm}`, handlers.MetricHandler(s))
-	})
+	r.Get(`/healthz`, handlers.HealthzHandler())
+	r.Get(`/readyz`, handlers.ReadyzHandler(o.readiness))
+	r.Handle(`/static/*`, handlers.StaticHandler())
+
+	// A backup covers every tenant, so it is not requested through a tenant prefix.
+	if o.admin && o.backup != nil {
+		r.With(o.startGate(), requireScope(auth.ScopeAdmin)).Post(`/admin/backup`, handlers.BackupHandler(o.backup))
+	}
+
+	routes := metricRoutes(s, o, requireScope)
+	routes(r)
+	r.Route(`/t/{tenant}`, routes)
 	return r
 }
+
+// metricRoutes registers tenant-aware metric endpoints. They are kept flat so that {nm}
+// is already resolved when the auth middleware runs.
+func metricRoutes(s metricsProcessor, o *options, requireScope func(auth.Scope) func(http.Handler) http.Handler) func(chi.Router) {
+	rateLimit := func(h http.Handler) http.Handler { return h }
+	if o.limiter != nil {
+		rateLimit = o.limiter.Handler
+	}
+	idempotent := func(h http.Handler) http.Handler { return h }
+	if o.idempotent != nil {
+		idempotent = o.idempotent.Handler
+	}
+	startGate := o.startGate()
+	compress := mw.WithCompressLimits(o.bodyLimits)
+
+	return func(r chi.Router) {
+		if o.tokens != nil {
+			r.With(compress).Get(`/`, handlers.DashboardHandler())
+		}
+		r.Group(func(r chi.Router) {
+			r.Use(requireScope(auth.ScopeRead), mw.WithTenant)
+			if o.tokens == nil {
+				r.With(compress).Get(`/`, handlers.AllMetricsHandler(s))
+			}
+			r.With(compress).Get(`/values/`, handlers.AllMetricsHandlerJSON(s))
+			r.With(compress).Post(`/value`, handlers.MetricHandlerJSON(s))
+			r.With(compress).Post(`/value/`, handlers.MetricHandlerJSON(s))
+			r.Get(`/value/{tp}/{nm}`, handlers.MetricHandler(s))
+			r.Get(`/meta/{nm}`, handlers.MetaHandler(s))
+			r.With(compress).Get(`/metrics`, handlers.PrometheusHandler(s))
+		})
+
+		r.Group(func(r chi.Router) {
+			// Rate limiting follows authentication so that clients can be told apart by token.
+			r.Use(startGate, requireScope(auth.ScopeWrite), rateLimit, mw.WithTenant, idempotent)
+			r.With(compress).Post(`/updates/`, handlers.CollectMetricsHandlerJSON(s, o.maxBatch))
+			r.With(compress).Post(`/update`, handlers.CollectMetricHandlerJSON(s))
+			r.With(compress).Post(`/update/`, handlers.CollectMetricHandlerJSON(s))
+			r.Post(`/update/{tp}/`, http.NotFound)
+			r.Post(`/update/{tp}/{nm}/{val}`, handlers.CollectMetricHandler(s))
+			r.Put(`/meta/{nm}`, handlers.PutMetaHandler(s))
+		})
+
+		if !o.admin {
+			return
+		}
+		r.Group(func(r chi.Router) {
+			r.Use(startGate, requireScope(auth.ScopeAdmin), mw.WithTenant)
+			r.Delete(`/value/{tp}/{nm}`, handlers.DeleteMetricHandler(s, o.onChange))
+			r.Post(`/admin/reset/{nm}`, handlers.ResetCounterHandler(s, o.onChange))
+			r.Delete(`/admin/metrics`, handlers.DeleteMatchingHandler(s, o.onChange))
+			r.Post(`/admin/rename`, handlers.RenameMetricHandler(s, o.onChange))
+		})
+	}
+}
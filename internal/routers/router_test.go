@@ -1,6 +1,9 @@
 package routers
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"io"
 	"net/http"
@@ -10,7 +13,12 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
+	"github.com/volchkovski/go-practicum-metrics/internal/health"
+	mw "github.com/volchkovski/go-practicum-metrics/internal/middleware"
 	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
 	"go.uber.org/mock/gomock"
 )
 
@@ -52,7 +60,7 @@ func testRequest(t *testing.T, ts *httptest.Server, method, path string, body io
 func testIter(ts *httptest.Server, tc test) func(*testing.T) {
 	return func(t *testing.T) {
 		tc.mock()
-		resp, body := testRequest(t, ts, tc.method, tc.path, strings.NewReader(tc.body), nil)
+		resp, body := testRequest(t, ts, tc.method, tc.path, strings.NewReader(tc.body), tc.headers)
 		defer func() {
 			require.NoError(t, resp.Body.Close())
 		}()
@@ -240,6 +248,8 @@ func TestRouterAllMetricsHTML(t *testing.T) {
 					Return([]*m.CounterMetric{
 						{Name: "test", Value: int64(123)},
 					}, nil)
+				service.EXPECT().GetAllMetricMeta(gomock.Any()).
+					Return(map[string]*m.MetricMeta{"test": {Name: "test", Unit: "bytes"}}, nil)
 			},
 			expected: expected{
 				contentType: "text/html",
@@ -254,6 +264,58 @@ func TestRouterAllMetricsHTML(t *testing.T) {
 	}
 }
 
+func TestRouterAllMetricsJSON(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+
+	service := NewMockmetricsProcessor(mockCtl)
+	r := NewMetricRouter(service)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []test{
+		{
+			name:   "get all metrics sorted by name",
+			path:   "/values/",
+			method: http.MethodGet,
+			mock: func() {
+				service.EXPECT().GetAllGaugeMetrics(gomock.Any()).
+					Return([]*m.GaugeMetric{
+						{Name: "zeta", Value: float64(0.123456)},
+						{Name: "alpha", Value: float64(1)},
+					}, nil)
+				service.EXPECT().GetAllCounterMetrics(gomock.Any()).
+					Return([]*m.CounterMetric{
+						{Name: "beta", Value: int64(5)},
+					}, nil)
+			},
+			expected: expected{
+				contentType: "application/json",
+				status:      http.StatusOK,
+				body: `[
+					{"id": "alpha", "type": "gauge", "value": 1},
+					{"id": "beta", "type": "counter", "delta": 5},
+					{"id": "zeta", "type": "gauge", "value": 0.123456}
+				]`,
+			},
+		},
+		{
+			name:   "get dashboard script",
+			path:   "/static/dashboard.js",
+			method: http.MethodGet,
+			mock:   func() {},
+			expected: expected{
+				contentType: "javascript",
+				status:      http.StatusOK,
+				body:        "",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, testIter(ts, tc))
+	}
+}
+
 func TestRouterMetricJSON(t *testing.T) {
 	mockCtl := gomock.NewController(t)
 
@@ -375,9 +437,777 @@ func TestRouterMetricsJSON(t *testing.T) {
 				body:        "",
 			},
 		},
+		{
+			name:    "post null batch",
+			path:    "/updates/",
+			method:  http.MethodPost,
+			body:    `null`,
+			headers: headers,
+			mock: func() {
+				service.EXPECT().PushMetrics(gomock.Any(), []*m.GaugeMetric{}, []*m.CounterMetric{}).Return(nil)
+			},
+			expected: expected{
+				status: http.StatusOK,
+			},
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, testIter(ts, tc))
 	}
 }
+
+func TestRouterAuth(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+
+	tokens, err := auth.NewStaticStore([]*auth.Token{
+		{Value: "reader", Scopes: []auth.Scope{auth.ScopeRead}},
+		{Value: "writer", Scopes: []auth.Scope{auth.ScopeWrite}, Prefix: "app."},
+		{Value: "app-reader", Scopes: []auth.Scope{auth.ScopeRead}, Prefix: "app."},
+		{Value: "app-admin", Scopes: []auth.Scope{auth.ScopeAdmin}, Prefix: "app."},
+	})
+	require.NoError(t, err)
+
+	service := NewMockmetricsProcessor(mockCtl)
+	r := NewMetricRouter(service, WithTokens(tokens), WithAdmin(func() {}))
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	bearer := func(token string) http.Header {
+		h := make(http.Header)
+		h.Set("Authorization", "Bearer "+token)
+		h.Set("Content-Type", "application/json")
+		return h
+	}
+
+	tests := []test{
+		{
+			name:   "missing token",
+			path:   "/value/gauge/app.test",
+			method: http.MethodGet,
+			mock:   func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusUnauthorized,
+			},
+		},
+		{
+			name:    "unknown token",
+			path:    "/value/gauge/app.test",
+			method:  http.MethodGet,
+			headers: bearer("unknown"),
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusUnauthorized,
+			},
+		},
+		{
+			name:    "read with read scope",
+			path:    "/value/gauge/app.test",
+			method:  http.MethodGet,
+			headers: bearer("reader"),
+			mock: func() {
+				service.EXPECT().GetGaugeMetric(gomock.Any(), "app.test").
+					Return(&m.GaugeMetric{Name: "app.test", Value: float64(1)}, nil)
+			},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusOK,
+				body:        "1",
+			},
+		},
+		{
+			name:    "read outside of prefix",
+			path:    "/value/counter/other",
+			method:  http.MethodGet,
+			headers: bearer("app-reader"),
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusForbidden,
+			},
+		},
+		{
+			name:    "read meta outside of prefix",
+			path:    "/meta/other",
+			method:  http.MethodGet,
+			headers: bearer("app-reader"),
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusForbidden,
+			},
+		},
+		{
+			name:    "declare meta outside of prefix",
+			path:    "/meta/other",
+			method:  http.MethodPut,
+			headers: bearer("writer"),
+			body:    `{"type": "counter"}`,
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusForbidden,
+			},
+		},
+		{
+			name:    "delete outside of prefix",
+			path:    "/value/gauge/other",
+			method:  http.MethodDelete,
+			headers: bearer("app-admin"),
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusForbidden,
+			},
+		},
+		{
+			name:    "reset outside of prefix",
+			path:    "/admin/reset/other",
+			method:  http.MethodPost,
+			headers: bearer("app-admin"),
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusForbidden,
+			},
+		},
+		{
+			name:    "reset within prefix",
+			path:    "/admin/reset/app.test",
+			method:  http.MethodPost,
+			headers: bearer("app-admin"),
+			mock: func() {
+				service.EXPECT().ResetCounterMetric(gomock.Any(), "app.test").Return(nil)
+			},
+			expected: expected{
+				status: http.StatusOK,
+			},
+		},
+		{
+			name:    "write with read scope",
+			path:    "/update/gauge/app.test/1",
+			method:  http.MethodPost,
+			headers: bearer("reader"),
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusForbidden,
+			},
+		},
+		{
+			name:    "write outside of prefix",
+			path:    "/update/gauge/other/1",
+			method:  http.MethodPost,
+			headers: bearer("writer"),
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusForbidden,
+			},
+		},
+		{
+			name:    "batch write outside of prefix",
+			path:    "/updates/",
+			method:  http.MethodPost,
+			headers: bearer("writer"),
+			body:    `[{"id": "app.test", "type": "counter", "delta": 1}, {"id": "other", "type": "counter", "delta": 1}]`,
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusForbidden,
+			},
+		},
+		{
+			name:    "write within prefix",
+			path:    "/update/gauge/app.test/1",
+			method:  http.MethodPost,
+			headers: bearer("writer"),
+			mock: func() {
+				service.EXPECT().
+					PushGaugeMetric(gomock.Any(), &m.GaugeMetric{Name: "app.test", Value: float64(1)}).
+					Return(nil)
+			},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusOK,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, testIter(ts, tc))
+	}
+}
+
+// A browser opens the dashboard without a token, so the page comes without values and its
+// script polls them with a read token entered on the page.
+func TestRouterDashboardAuth(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+
+	tokens, err := auth.NewStaticStore([]*auth.Token{
+		{Value: "reader", Scopes: []auth.Scope{auth.ScopeRead}},
+	})
+	require.NoError(t, err)
+
+	service := NewMockmetricsProcessor(mockCtl)
+	ts := httptest.NewServer(NewMetricRouter(service, WithTokens(tokens)))
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `id="token"`)
+	assert.Contains(t, body, `data-values-url="/values/"`)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/t/team/", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `data-values-url="/t/team/values/"`)
+
+	resp, body = testRequest(t, ts, http.MethodGet, "/static/dashboard.js", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"Authorization"`)
+
+	resp, _ = testRequest(t, ts, http.MethodGet, "/values/", nil, nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	bearer := make(http.Header)
+	bearer.Set("Authorization", "Bearer reader")
+	service.EXPECT().GetAllGaugeMetrics(gomock.Any()).Return(nil, nil)
+	service.EXPECT().GetAllCounterMetrics(gomock.Any()).Return(nil, nil)
+	resp, _ = testRequest(t, ts, http.MethodGet, "/values/", nil, bearer)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRouterTenants(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+
+	service := NewMockmetricsProcessor(mockCtl)
+	r := NewMetricRouter(service)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	inTenant := func(id string) gomock.Matcher {
+		return gomock.Cond(func(ctx context.Context) bool {
+			return tenant.FromContext(ctx) == id
+		})
+	}
+	tenantHeader := func(id string) http.Header {
+		h := make(http.Header)
+		h.Set(tenant.Header, id)
+		return h
+	}
+
+	tests := []test{
+		{
+			name:   "default tenant",
+			path:   "/value/gauge/test",
+			method: http.MethodGet,
+			mock: func() {
+				service.EXPECT().GetGaugeMetric(inTenant(tenant.Default), "test").
+					Return(&m.GaugeMetric{Name: "test", Value: float64(1)}, nil)
+			},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusOK,
+				body:        "1",
+			},
+		},
+		{
+			name:    "tenant from header",
+			path:    "/update/counter/test/1",
+			method:  http.MethodPost,
+			headers: tenantHeader("team-a"),
+			mock: func() {
+				service.EXPECT().
+					PushCounterMetric(inTenant("team-a"), &m.CounterMetric{Name: "test", Value: int64(1)}).
+					Return(nil)
+			},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusOK,
+			},
+		},
+		{
+			name:   "tenant from url prefix",
+			path:   "/t/team-b/value/gauge/test",
+			method: http.MethodGet,
+			mock: func() {
+				service.EXPECT().GetGaugeMetric(inTenant("team-b"), "test").
+					Return(&m.GaugeMetric{Name: "test", Value: float64(2)}, nil)
+			},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusOK,
+				body:        "2",
+			},
+		},
+		{
+			name:    "invalid tenant",
+			path:    "/value/gauge/test",
+			method:  http.MethodGet,
+			headers: tenantHeader("../etc"),
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusBadRequest,
+			},
+		},
+		{
+			name:   "quota exceeded",
+			path:   "/update/gauge/test/1",
+			method: http.MethodPost,
+			mock: func() {
+				service.EXPECT().
+					PushGaugeMetric(gomock.Any(), &m.GaugeMetric{Name: "test", Value: float64(1)}).
+					Return(tenant.ErrQuotaExceeded)
+			},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusInsufficientStorage,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, testIter(ts, tc))
+	}
+}
+
+func TestRouterIngestLimits(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+
+	service := NewMockmetricsProcessor(mockCtl)
+	r := NewMetricRouter(service,
+		WithIngestLimits(mw.BodyLimits{Compressed: 1024, Decompressed: 256}, 2),
+		WithRateLimit(mw.NewRateLimiter(1, 3, mw.RateLimitByIP)),
+	)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	gzipHeaders := make(http.Header)
+	gzipHeaders.Set("Content-Encoding", "gzip")
+
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	_, err := zw.Write([]byte(`[{"id": "` + strings.Repeat("a", 1000) + `", "type": "counter", "delta": 1}]`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	var zippedRead bytes.Buffer
+	zw = gzip.NewWriter(&zippedRead)
+	_, err = zw.Write([]byte(`{"id": "` + strings.Repeat("a", 1000) + `", "type": "counter"}`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := []test{
+		{
+			name:    "decompressed read body over limit",
+			path:    "/value/",
+			method:  http.MethodPost,
+			body:    zippedRead.String(),
+			headers: gzipHeaders,
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusRequestEntityTooLarge,
+			},
+		},
+		{
+			name:   "batch over limit",
+			path:   "/updates/",
+			method: http.MethodPost,
+			body: `[
+				{"id": "c1", "type": "counter", "delta": 1},
+				{"id": "c2", "type": "counter", "delta": 1},
+				{"id": "c3", "type": "counter", "delta": 1}
+			]`,
+			mock: func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusRequestEntityTooLarge,
+			},
+		},
+		{
+			name:    "decompressed body over limit",
+			path:    "/updates/",
+			method:  http.MethodPost,
+			body:    zipped.String(),
+			headers: gzipHeaders,
+			mock:    func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusRequestEntityTooLarge,
+			},
+		},
+		{
+			name:   "batch within limits",
+			path:   "/updates/",
+			method: http.MethodPost,
+			body:   `[{"id": "c1", "type": "counter", "delta": 1}]`,
+			mock: func() {
+				service.EXPECT().PushMetrics(gomock.Any(), []*m.GaugeMetric{}, []*m.CounterMetric{{Name: "c1", Value: 1}}).
+					Return(nil)
+			},
+			expected: expected{
+				status: http.StatusOK,
+			},
+		},
+		{
+			name:   "rate limited",
+			path:   "/updates/",
+			method: http.MethodPost,
+			body:   `[]`,
+			mock:   func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusTooManyRequests,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, testIter(ts, tc))
+	}
+
+	resp, _ := testRequest(t, ts, http.MethodPost, "/updates/", strings.NewReader(`[]`), nil)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+}
+
+func TestRouterAdmin(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+
+	service := NewMockmetricsProcessor(mockCtl)
+	changes, backups := 0, 0
+	r := NewMetricRouter(service, WithAdmin(func() { changes++ }), WithBackup(func() { backups++ }))
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []test{
+		{
+			name:   "delete gauge",
+			path:   "/value/gauge/test",
+			method: http.MethodDelete,
+			mock: func() {
+				service.EXPECT().DeleteGaugeMetric(gomock.Any(), "test").Return(nil)
+			},
+			expected: expected{
+				status: http.StatusOK,
+			},
+		},
+		{
+			name:   "delete not existing counter",
+			path:   "/value/counter/test",
+			method: http.MethodDelete,
+			mock: func() {
+				service.EXPECT().DeleteCounterMetric(gomock.Any(), "test").Return(m.ErrMetricNotFound)
+			},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusNotFound,
+			},
+		},
+		{
+			name:   "reset counter",
+			path:   "/admin/reset/test",
+			method: http.MethodPost,
+			mock: func() {
+				service.EXPECT().ResetCounterMetric(gomock.Any(), "test").Return(nil)
+			},
+			expected: expected{
+				status: http.StatusOK,
+			},
+		},
+		{
+			name:   "delete by glob",
+			path:   "/admin/metrics?match=Heap*",
+			method: http.MethodDelete,
+			mock: func() {
+				service.EXPECT().GetAllGaugeMetrics(gomock.Any()).
+					Return([]*m.GaugeMetric{{Name: "HeapAlloc"}, {Name: "Alloc"}}, nil)
+				service.EXPECT().GetAllCounterMetrics(gomock.Any()).
+					Return([]*m.CounterMetric{{Name: "HeapCount"}}, nil)
+				service.EXPECT().DeleteGaugeMetric(gomock.Any(), "HeapAlloc").Return(nil)
+				service.EXPECT().DeleteCounterMetric(gomock.Any(), "HeapCount").Return(nil)
+			},
+			expected: expected{
+				contentType: "application/json",
+				status:      http.StatusOK,
+				body:        `{"deleted": 2}`,
+			},
+		},
+		{
+			name:   "delete by invalid glob",
+			path:   "/admin/metrics?match=%5B",
+			method: http.MethodDelete,
+			mock:   func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusBadRequest,
+			},
+		},
+		{
+			name:   "rename counter",
+			path:   "/admin/rename",
+			method: http.MethodPost,
+			body:   `{"type": "counter", "from": "Pollcount", "to": "PollCount"}`,
+			mock: func() {
+				service.EXPECT().RenameCounterMetric(gomock.Any(), "Pollcount", "PollCount").Return(nil)
+			},
+			expected: expected{
+				status: http.StatusOK,
+			},
+		},
+		{
+			name:   "rename to the same name",
+			path:   "/admin/rename",
+			method: http.MethodPost,
+			body:   `{"type": "gauge", "from": "a", "to": "a"}`,
+			mock:   func() {},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusBadRequest,
+			},
+		},
+		{
+			name:   "request backup",
+			path:   "/admin/backup",
+			method: http.MethodPost,
+			mock:   func() {},
+			expected: expected{
+				status: http.StatusAccepted,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, testIter(ts, tc))
+	}
+	assert.Equal(t, 4, changes)
+	assert.Equal(t, 1, backups)
+}
+
+func TestRouterAdminDisabled(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+
+	service := NewMockmetricsProcessor(mockCtl)
+	r := NewMetricRouter(service, WithBackup(func() { t.Error("backup requested") }))
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	resp, _ := testRequest(t, ts, http.MethodDelete, "/value/gauge/test", nil, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodPost, "/admin/reset/test", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, _ = testRequest(t, ts, http.MethodPost, "/admin/backup", nil, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRouterMeta(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+
+	service := NewMockmetricsProcessor(mockCtl)
+	r := NewMetricRouter(service)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	tests := []test{
+		{
+			name:   "put meta",
+			path:   "/meta/HeapAlloc",
+			method: http.MethodPut,
+			body:   `{"name": "ignored", "type": "gauge", "unit": "bytes", "help": "Heap in use"}`,
+			mock: func() {
+				service.EXPECT().PutMetricMeta(gomock.Any(), &m.MetricMeta{
+					Name: "HeapAlloc", Type: "gauge", Unit: "bytes", Help: "Heap in use",
+				}).Return(nil)
+			},
+			expected: expected{
+				status: http.StatusOK,
+			},
+		},
+		{
+			name:   "put meta conflicting with existing metric",
+			path:   "/meta/PollCount",
+			method: http.MethodPut,
+			body:   `{"type": "gauge"}`,
+			mock: func() {
+				service.EXPECT().PutMetricMeta(gomock.Any(), gomock.Any()).Return(m.ErrTypeConflict)
+			},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusConflict,
+			},
+		},
+		{
+			name:   "put invalid meta",
+			path:   "/meta/PollCount",
+			method: http.MethodPut,
+			body:   `{"type": "histogram"}`,
+			mock: func() {
+				service.EXPECT().PutMetricMeta(gomock.Any(), gomock.Any()).Return(m.ErrInvalidMeta)
+			},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusBadRequest,
+			},
+		},
+		{
+			name:   "get meta",
+			path:   "/meta/HeapAlloc",
+			method: http.MethodGet,
+			mock: func() {
+				service.EXPECT().GetMetricMeta(gomock.Any(), "HeapAlloc").
+					Return(&m.MetricMeta{Name: "HeapAlloc", Type: "gauge", Unit: "bytes"}, nil)
+			},
+			expected: expected{
+				contentType: "application/json",
+				status:      http.StatusOK,
+				body:        `{"name": "HeapAlloc", "type": "gauge", "unit": "bytes"}`,
+			},
+		},
+		{
+			name:   "get not existing meta",
+			path:   "/meta/Unknown",
+			method: http.MethodGet,
+			mock: func() {
+				service.EXPECT().GetMetricMeta(gomock.Any(), "Unknown").Return(nil, m.ErrMetricNotFound)
+			},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusNotFound,
+			},
+		},
+		{
+			name:   "push gauge into counter declared metric",
+			path:   "/update/gauge/PollCount/1",
+			method: http.MethodPost,
+			mock: func() {
+				service.EXPECT().PushGaugeMetric(gomock.Any(), gomock.Any()).Return(m.ErrTypeConflict)
+			},
+			expected: expected{
+				contentType: "text/plain",
+				status:      http.StatusConflict,
+			},
+		},
+		{
+			name:   "prometheus export",
+			path:   "/metrics",
+			method: http.MethodGet,
+			mock: func() {
+				service.EXPECT().GetAllGaugeMetrics(gomock.Any()).
+					Return([]*m.GaugeMetric{{Name: "HeapAlloc", Value: 1.5}}, nil)
+				service.EXPECT().GetAllCounterMetrics(gomock.Any()).
+					Return([]*m.CounterMetric{{Name: "poll.count", Value: 3}}, nil)
+				service.EXPECT().GetAllMetricMeta(gomock.Any()).
+					Return(map[string]*m.MetricMeta{
+						"HeapAlloc": {Name: "HeapAlloc", Unit: "bytes", Help: "Heap in use"},
+					}, nil)
+			},
+			expected: expected{
+				contentType: "text/plain; version=0.0.4",
+				status:      http.StatusOK,
+				body: "# HELP HeapAlloc Heap in use\n" +
+					"# UNIT HeapAlloc bytes\n" +
+					"# TYPE HeapAlloc gauge\n" +
+					"HeapAlloc 1.5\n" +
+					"# TYPE poll_count counter\n" +
+					"poll_count 3\n",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, testIter(ts, tc))
+	}
+}
+
+func TestRouterServerMetrics(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+
+	service := NewMockmetricsProcessor(mockCtl)
+	ts := httptest.NewServer(NewMetricRouter(service))
+	defer ts.Close()
+
+	service.EXPECT().PushCounterMetric(gomock.Any(), gomock.Any()).Return(m.ErrReservedName)
+	resp, _ := testRequest(t, ts, http.MethodPost, "/update/counter/_server.ingest.batches/1", nil, nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	reg := selfmetrics.NewRegistry()
+	reg.Add("ingest.batches", 3)
+	internal := httptest.NewServer(NewInternalRouter(reg))
+	defer internal.Close()
+
+	resp, body := testRequest(t, internal, http.MethodGet, "/metrics", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "# TYPE _server_ingest_batches counter\n_server_ingest_batches 3\n", body)
+}
+
+func TestRouterHealth(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+
+	service := NewMockmetricsProcessor(mockCtl)
+	ts := httptest.NewServer(NewMetricRouter(service))
+	defer ts.Close()
+
+	resp, body := testRequest(t, ts, http.MethodGet, "/healthz", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.JSONEq(t, `{"status":"ok"}`, body)
+
+	service.EXPECT().PingDB(gomock.Any()).Return(errors.New("dial tcp: connection refused"))
+	resp, body = testRequest(t, ts, http.MethodGet, "/ping", nil, nil)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.NotContains(t, body, "connection refused")
+
+	service.EXPECT().PingDB(gomock.Any()).Return(nil)
+	resp, body = testRequest(t, ts, http.MethodGet, "/readyz", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, `"phase":"ready"`)
+
+	checker := health.NewChecker()
+	checker.Add("backup_writable", func(context.Context) error { return nil })
+	ts2 := httptest.NewServer(NewMetricRouter(service, WithReadiness(checker)))
+	defer ts2.Close()
+
+	resp, body = testRequest(t, ts2, http.MethodGet, "/readyz", nil, nil)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Contains(t, body, `"phase":"starting"`)
+	assert.Contains(t, body, `"backup_writable":{"status":"ok"`)
+}
+
+func TestRouterWritesDuringRestore(t *testing.T) {
+	mockCtl := gomock.NewController(t)
+
+	service := NewMockmetricsProcessor(mockCtl)
+	checker := health.NewChecker()
+	r := NewMetricRouter(service,
+		WithReadiness(checker),
+		WithStartGate(checker.Started),
+		WithAdmin(nil),
+		WithBackup(func() {}),
+	)
+	ts := httptest.NewServer(r)
+	defer ts.Close()
+
+	for _, req := range []struct{ method, path, body string }{
+		{http.MethodPost, "/updates/", `[{"id": "c1", "type": "counter", "delta": 1}]`},
+		{http.MethodPost, "/update/gauge/g1/1", ""},
+		{http.MethodPut, "/meta/g1", `{"unit": "bytes"}`},
+		{http.MethodDelete, "/value/gauge/g1", ""},
+		{http.MethodPost, "/admin/backup", ""},
+	} {
+		resp, _ := testRequest(t, ts, req.method, req.path, strings.NewReader(req.body), nil)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "%s %s", req.method, req.path)
+		assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	}
+
+	service.EXPECT().GetGaugeMetric(gomock.Any(), "g1").Return(&m.GaugeMetric{Name: "g1", Value: 1}, nil)
+	resp, _ := testRequest(t, ts, http.MethodGet, "/value/gauge/g1", nil, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "reads are served during restore")
+
+	checker.SetPhase(health.PhaseReady)
+	service.EXPECT().PushMetrics(gomock.Any(), []*m.GaugeMetric{}, []*m.CounterMetric{{Name: "c1", Value: 1}}).Return(nil)
+	resp, _ = testRequest(t, ts, http.MethodPost, "/updates/", strings.NewReader(`[{"id": "c1", "type": "counter", "delta": 1}]`), nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
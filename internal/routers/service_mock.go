@@ -41,6 +41,34 @@ func (m *MockmetricsProcessor) EXPECT() *MockmetricsProcessorMockRecorder {
 	return m.recorder
 }
 
+// DeleteCounterMetric mocks base method.
+func (m *MockmetricsProcessor) DeleteCounterMetric(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteCounterMetric", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCounterMetric indicates an expected call of DeleteCounterMetric.
+func (mr *MockmetricsProcessorMockRecorder) DeleteCounterMetric(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCounterMetric", reflect.TypeOf((*MockmetricsProcessor)(nil).DeleteCounterMetric), arg0, arg1)
+}
+
+// DeleteGaugeMetric mocks base method.
+func (m *MockmetricsProcessor) DeleteGaugeMetric(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteGaugeMetric", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteGaugeMetric indicates an expected call of DeleteGaugeMetric.
+func (mr *MockmetricsProcessorMockRecorder) DeleteGaugeMetric(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteGaugeMetric", reflect.TypeOf((*MockmetricsProcessor)(nil).DeleteGaugeMetric), arg0, arg1)
+}
+
 // GetAllCounterMetrics mocks base method.
 func (m *MockmetricsProcessor) GetAllCounterMetrics(arg0 context.Context) ([]*models.CounterMetric, error) {
 	m.ctrl.T.Helper()
@@ -71,6 +99,21 @@ func (mr *MockmetricsProcessorMockRecorder) GetAllGaugeMetrics(arg0 any) *gomock
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllGaugeMetrics", reflect.TypeOf((*MockmetricsProcessor)(nil).GetAllGaugeMetrics), arg0)
 }
 
+// GetAllMetricMeta mocks base method.
+func (m *MockmetricsProcessor) GetAllMetricMeta(arg0 context.Context) (map[string]*models.MetricMeta, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAllMetricMeta", arg0)
+	ret0, _ := ret[0].(map[string]*models.MetricMeta)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAllMetricMeta indicates an expected call of GetAllMetricMeta.
+func (mr *MockmetricsProcessorMockRecorder) GetAllMetricMeta(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllMetricMeta", reflect.TypeOf((*MockmetricsProcessor)(nil).GetAllMetricMeta), arg0)
+}
+
 // GetCounterMetric mocks base method.
 func (m *MockmetricsProcessor) GetCounterMetric(arg0 context.Context, arg1 string) (*models.CounterMetric, error) {
 	m.ctrl.T.Helper()
@@ -101,6 +144,21 @@ func (mr *MockmetricsProcessorMockRecorder) GetGaugeMetric(arg0, arg1 any) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGaugeMetric", reflect.TypeOf((*MockmetricsProcessor)(nil).GetGaugeMetric), arg0, arg1)
 }
 
+// GetMetricMeta mocks base method.
+func (m *MockmetricsProcessor) GetMetricMeta(arg0 context.Context, arg1 string) (*models.MetricMeta, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetricMeta", arg0, arg1)
+	ret0, _ := ret[0].(*models.MetricMeta)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetricMeta indicates an expected call of GetMetricMeta.
+func (mr *MockmetricsProcessorMockRecorder) GetMetricMeta(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetricMeta", reflect.TypeOf((*MockmetricsProcessor)(nil).GetMetricMeta), arg0, arg1)
+}
+
 // PingDB mocks base method.
 func (m *MockmetricsProcessor) PingDB(arg0 context.Context) error {
 	m.ctrl.T.Helper()
@@ -156,3 +214,59 @@ func (mr *MockmetricsProcessorMockRecorder) PushMetrics(arg0, arg1, arg2 any) *g
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PushMetrics", reflect.TypeOf((*MockmetricsProcessor)(nil).PushMetrics), arg0, arg1, arg2)
 }
+
+// PutMetricMeta mocks base method.
+func (m *MockmetricsProcessor) PutMetricMeta(arg0 context.Context, arg1 *models.MetricMeta) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PutMetricMeta", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PutMetricMeta indicates an expected call of PutMetricMeta.
+func (mr *MockmetricsProcessorMockRecorder) PutMetricMeta(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutMetricMeta", reflect.TypeOf((*MockmetricsProcessor)(nil).PutMetricMeta), arg0, arg1)
+}
+
+// RenameCounterMetric mocks base method.
+func (m *MockmetricsProcessor) RenameCounterMetric(ctx context.Context, from, to string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenameCounterMetric", ctx, from, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameCounterMetric indicates an expected call of RenameCounterMetric.
+func (mr *MockmetricsProcessorMockRecorder) RenameCounterMetric(ctx, from, to any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameCounterMetric", reflect.TypeOf((*MockmetricsProcessor)(nil).RenameCounterMetric), ctx, from, to)
+}
+
+// RenameGaugeMetric mocks base method.
+func (m *MockmetricsProcessor) RenameGaugeMetric(ctx context.Context, from, to string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenameGaugeMetric", ctx, from, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameGaugeMetric indicates an expected call of RenameGaugeMetric.
+func (mr *MockmetricsProcessorMockRecorder) RenameGaugeMetric(ctx, from, to any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameGaugeMetric", reflect.TypeOf((*MockmetricsProcessor)(nil).RenameGaugeMetric), ctx, from, to)
+}
+
+// ResetCounterMetric mocks base method.
+func (m *MockmetricsProcessor) ResetCounterMetric(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetCounterMetric", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetCounterMetric indicates an expected call of ResetCounterMetric.
+func (mr *MockmetricsProcessorMockRecorder) ResetCounterMetric(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetCounterMetric", reflect.TypeOf((*MockmetricsProcessor)(nil).ResetCounterMetric), arg0, arg1)
+}
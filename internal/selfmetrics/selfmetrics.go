@@ -0,0 +1,188 @@
+package selfmetrics
+
+import (
+	"context"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+)
+
+// Prefix is reserved for metrics of the server itself, clients may not write under it.
+const Prefix = "_server."
+
+// Default is the registry the server components report to.
+var Default = NewRegistry()
+
+// Registry holds cumulative counters and last-value gauges of the server itself.
+// Names are given without Prefix.
+type Registry struct {
+	mu         sync.Mutex
+	counters   map[string]int64
+	gauges     map[string]float64
+	collectors []Collector
+}
+
+// Collector records values kept elsewhere, such as connection pool totals, into the
+// registry. Collectors run each time the registry is read.
+type Collector func(*Registry)
+
+func NewRegistry() *Registry {
+	return &Registry{
+		counters: make(map[string]int64),
+		gauges:   make(map[string]float64),
+	}
+}
+
+func (r *Registry) Add(name string, delta int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[name] += delta
+}
+
+func (r *Registry) Set(name string, v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges[name] = v
+}
+
+// SetCounter records a cumulative value counted elsewhere.
+func (r *Registry) SetCounter(name string, v int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[name] = v
+}
+
+func (r *Registry) AddCollector(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Observe records a duration as name.count, name.us_total and name.last_seconds.
+func (r *Registry) Observe(name string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[name+".count"]++
+	r.counters[name+".us_total"] += d.Microseconds()
+	r.gauges[name+".last_seconds"] = d.Seconds()
+}
+
+// Time starts timing name; call the returned function when the operation is done.
+func (r *Registry) Time(name string) func() {
+	start := time.Now()
+	return func() {
+		r.Observe(name, time.Since(start))
+	}
+}
+
+// Snapshot returns copies of all counters and gauges with Prefix applied.
+func (r *Registry) Snapshot() (map[string]float64, map[string]int64) {
+	r.mu.Lock()
+	collectors := r.collectors
+	r.mu.Unlock()
+	for _, c := range collectors {
+		c(r)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	gauges := make(map[string]float64, len(r.gauges))
+	for nm, v := range r.gauges {
+		gauges[Prefix+nm] = v
+	}
+	counters := make(map[string]int64, len(r.counters))
+	for nm, v := range r.counters {
+		counters[Prefix+nm] = v
+	}
+	return gauges, counters
+}
+
+// Metrics returns the snapshot in the form of the read APIs.
+func (r *Registry) Metrics() ([]*m.GaugeMetric, []*m.CounterMetric) {
+	gs, cs := r.Snapshot()
+	gauges := make([]*m.GaugeMetric, 0, len(gs))
+	for nm, v := range gs {
+		gauges = append(gauges, &m.GaugeMetric{Name: nm, Value: v})
+	}
+	counters := make([]*m.CounterMetric, 0, len(cs))
+	for nm, v := range cs {
+		counters = append(counters, &m.CounterMetric{Name: nm, Value: v})
+	}
+	return gauges, counters
+}
+
+// Reserved reports whether a client-supplied name falls under Prefix.
+func Reserved(nm string) bool {
+	return strings.HasPrefix(nm, Prefix)
+}
+
+// SanitizeRoute turns a route pattern such as "POST /t/{tenant}/updates/" into a name part.
+func SanitizeRoute(s string) string {
+	var b strings.Builder
+	sep := false
+	for _, r := range s {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			if sep && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+			sep = false
+			continue
+		}
+		sep = true
+	}
+	return b.String()
+}
+
+type gaugesCountersWriter interface {
+	WriteGaugesCounters(context.Context, map[string]float64, map[string]int64) error
+}
+
+// Flusher copies the registry into storage so that server metrics appear through the read APIs.
+// Counters are written as deltas since the previous flush.
+type Flusher struct {
+	reg     *Registry
+	strg    gaugesCountersWriter
+	flushed map[string]int64
+}
+
+func NewFlusher(reg *Registry, strg gaugesCountersWriter) *Flusher {
+	return &Flusher{reg: reg, strg: strg, flushed: make(map[string]int64)}
+}
+
+func (f *Flusher) Flush(ctx context.Context) error {
+	gauges, counters := f.reg.Snapshot()
+	deltas := make(map[string]int64, len(counters))
+	for nm, v := range counters {
+		if d := v - f.flushed[nm]; d != 0 {
+			deltas[nm] = d
+		}
+	}
+	if err := f.strg.WriteGaugesCounters(ctx, gauges, deltas); err != nil {
+		return err
+	}
+	maps.Copy(f.flushed, counters)
+	return nil
+}
+
+// Start flushes every interval until ctx is done.
+func (f *Flusher) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := f.Flush(ctx); err != nil {
+				logger.Log.Errorf("Failed to store server metrics: %s", err.Error())
+			}
+		}
+	}()
+}
@@ -0,0 +1,66 @@
+package selfmetrics
+
+import (
+	"context"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingWriter struct {
+	gauges   map[string]float64
+	counters map[string]int64
+}
+
+func (w *recordingWriter) WriteGaugesCounters(_ context.Context, gauges map[string]float64, counters map[string]int64) error {
+	maps.Copy(w.gauges, gauges)
+	for nm, v := range counters {
+		w.counters[nm] += v
+	}
+	return nil
+}
+
+func TestFlusherWritesDeltas(t *testing.T) {
+	reg := NewRegistry()
+	w := &recordingWriter{gauges: map[string]float64{}, counters: map[string]int64{}}
+	f := NewFlusher(reg, w)
+
+	reg.Add("ingest.batches", 2)
+	reg.Observe("backup.dump", 1500*time.Microsecond)
+	require.NoError(t, f.Flush(context.Background()))
+
+	reg.Add("ingest.batches", 3)
+	require.NoError(t, f.Flush(context.Background()))
+
+	assert.Equal(t, int64(5), w.counters["_server.ingest.batches"])
+	assert.Equal(t, int64(1), w.counters["_server.backup.dump.count"])
+	assert.Equal(t, int64(1500), w.counters["_server.backup.dump.us_total"])
+	assert.Equal(t, 0.0015, w.gauges["_server.backup.dump.last_seconds"])
+}
+
+func TestSanitizeRoute(t *testing.T) {
+	assert.Equal(t, "POST_t_tenant_updates", SanitizeRoute("POST /t/{tenant}/updates/"))
+	assert.Equal(t, "GET_value_tp_nm", SanitizeRoute("GET /value/{tp}/{nm}"))
+	assert.True(t, Reserved("_server.http.GET.count"))
+	assert.False(t, Reserved("server"))
+}
+
+func TestCollectors(t *testing.T) {
+	reg := NewRegistry()
+	total := int64(0)
+	reg.AddCollector(func(r *Registry) {
+		total += 2
+		r.SetCounter("pool.acquire.count", total)
+		r.Set("pool.idle_conns", 3)
+	})
+
+	gauges, counters := reg.Snapshot()
+	assert.Equal(t, 3.0, gauges["_server.pool.idle_conns"])
+	assert.Equal(t, int64(2), counters["_server.pool.acquire.count"])
+
+	_, counters = reg.Snapshot()
+	assert.Equal(t, int64(4), counters["_server.pool.acquire.count"])
+}
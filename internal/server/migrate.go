@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/configs"
+	"github.com/volchkovski/go-practicum-metrics/internal/storage/pg/migrator"
+)
+
+var ErrMigrateUsage = errors.New("usage: server [flags] migrate up | down [N] | version | force V")
+
+// Migrate runs a schema migration command against the postgres storage of cfg:
+// up applies pending migrations, down rolls back N of them (one by default), version
+// prints the schema version and force sets it without running migrations.
+func Migrate(cfg *configs.ServerConfig, args []string, out io.Writer) (err error) {
+	if cfg.DSN == "" {
+		return errors.New("migrate requires postgres storage, set the database DSN")
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return ErrMigrateUsage
+	}
+	var arg *int
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return fmt.Errorf("%q is not a number: %w", args[1], ErrMigrateUsage)
+		}
+		arg = &n
+	}
+
+	var run func(*migrator.Migrator) error
+	switch {
+	case args[0] == "up" && arg == nil:
+		run = (*migrator.Migrator).Up
+	case args[0] == "down":
+		steps := 1
+		if arg != nil {
+			steps = *arg
+		}
+		run = func(m *migrator.Migrator) error { return m.Down(steps) }
+	case args[0] == "force" && arg != nil:
+		run = func(m *migrator.Migrator) error { return m.Force(*arg) }
+	case args[0] == "version" && arg == nil:
+		run = func(*migrator.Migrator) error { return nil }
+	default:
+		return ErrMigrateUsage
+	}
+
+	m, err := migrator.New(cfg.DSN)
+	if err != nil {
+		return fmt.Errorf("migrations failed: %w", err)
+	}
+	defer func() {
+		err = errors.Join(err, m.Close())
+	}()
+	if err = run(m); err != nil {
+		return err
+	}
+	return printVersion(out, m)
+}
+
+func printVersion(out io.Writer, m *migrator.Migrator) error {
+	version, dirty, err := m.Version()
+	if err != nil {
+		return err
+	}
+	latest, err := migrator.Latest()
+	if err != nil {
+		return err
+	}
+	state := ""
+	if dirty {
+		state = " (dirty)"
+	}
+	_, err = fmt.Fprintf(out, "Schema version %d%s, latest known %d\n", version, state, latest)
+	return err
+}
@@ -1,21 +1,36 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/signal"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
 	"github.com/volchkovski/go-practicum-metrics/internal/backup"
 	"github.com/volchkovski/go-practicum-metrics/internal/configs"
+	"github.com/volchkovski/go-practicum-metrics/internal/health"
 	"github.com/volchkovski/go-practicum-metrics/internal/httpserver"
 	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+	mw "github.com/volchkovski/go-practicum-metrics/internal/middleware"
 	"github.com/volchkovski/go-practicum-metrics/internal/routers"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
 	"github.com/volchkovski/go-practicum-metrics/internal/services"
 	"github.com/volchkovski/go-practicum-metrics/internal/storage/mem"
 	"github.com/volchkovski/go-practicum-metrics/internal/storage/pg"
+	"github.com/volchkovski/go-practicum-metrics/internal/tlsutil"
+)
+
+const (
+	// shutdownTimeout bounds waiting for active requests once the drain is over.
+	shutdownTimeout = 10 * time.Second
+	// partitionsInterval is how often samples partitions are created and dropped.
+	partitionsInterval = time.Hour
 )
 
 func Run(cfg *configs.ServerConfig) (err error) {
@@ -29,49 +44,253 @@ func Run(cfg *configs.ServerConfig) (err error) {
 		}
 	}()
 
-	var strg services.MetricStorage
+	var (
+		strg   services.MetricStorage
+		pgStrg *pg.Pg
+	)
 	if cfg.DSN == "" {
 		strg = mem.NewMemStorage()
 		logger.Log.Infoln("Memory storage in use")
 	} else {
-		if strg, err = pg.New(cfg.DSN); err != nil {
+		pgOpts := []pg.Option{pg.WithPool(pg.PoolConfig{
+			MaxConns:          int32(cfg.DBMaxConns),
+			MinConns:          int32(cfg.DBMinConns),
+			MaxConnLifetime:   cfg.DBConnLifetime.Duration(),
+			MaxConnIdleTime:   cfg.DBConnIdleTime.Duration(),
+			HealthCheckPeriod: cfg.DBHealthCheck.Duration(),
+			StatementCache:    cfg.DBStatementCache,
+		}), pg.WithSampleRetention(cfg.SampleRetention.Duration())}
+		if cfg.AllowNewerSchema {
+			pgOpts = append(pgOpts, pg.WithNewerSchema())
+		}
+		if pgStrg, err = pg.New(cfg.DSN, pgOpts...); err != nil {
 			logger.Log.Errorf("Postgres creation failed: %s", err.Error())
 			return
 		}
+		selfmetrics.Default.AddCollector(pgStrg.ReportPoolStats)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		pgStrg.StartPartitionMaintenance(ctx, partitionsInterval)
+		strg = pgStrg
 		logger.Log.Infoln("Postgres storage in use")
 	}
-	service := services.NewMetricService(strg)
+	serviceOpts := []services.Option{services.WithSeriesQuota(cfg.TenantMaxSeries, cfg.TenantQuotas)}
+	backupOpts := []backup.Option{
+		backup.WithKeep(cfg.BackupKeep),
+		backup.WithCompression(cfg.BackupCompression),
+		backup.WithRestoreMode(cfg.RestoreMode),
+	}
+	if cfg.RestoreDryRun {
+		service := services.NewMetricService(strg, serviceOpts...)
+		defer func() {
+			if errServiceClose := service.Close(); errServiceClose != nil {
+				err = errors.Join(err, errServiceClose)
+			}
+		}()
+		return restoreDryRun(os.Stdout, backup.NewMetricsBackup(service, cfg.FileStoragePath, cfg.StoreIntr.Duration(), backupOpts...))
+	}
+	// A zero store interval persists every write synchronously through the write-ahead log.
+	if cfg.StoreIntr == 0 {
+		var wal *backup.WAL
+		if wal, err = backup.OpenWAL(cfg.FileStoragePath); err != nil {
+			return fmt.Errorf("failed to open write-ahead log: %w", err)
+		}
+		defer func() {
+			if errClose := wal.Close(); errClose != nil {
+				err = errors.Join(err, errClose)
+			}
+		}()
+		serviceOpts = append(serviceOpts, services.WithWriteLog(wal))
+		backupOpts = append(backupOpts, backup.WithWAL(wal, cfg.WALCheckpoint.Duration()))
+		logger.Log.Infoln("Synchronous backup with write-ahead log in use")
+	}
+	service := services.NewMetricService(strg, serviceOpts...)
 	defer func() {
 		if errServiceClose := service.Close(); errServiceClose != nil {
 			err = errors.Join(err, errServiceClose)
 		}
 	}()
 
-	b := backup.NewMetricsBackup(service, cfg.FileStoragePath, cfg.StoreIntr)
+	b := backup.NewMetricsBackup(service, cfg.FileStoragePath, cfg.StoreIntr.Duration(), backupOpts...)
 
-	if cfg.Restore {
-		if err = b.Restore(); err != nil && !errors.Is(err, io.EOF) {
+	checker := health.NewChecker()
+	checker.Add("storage", service.PingDB)
+	if pgStrg != nil {
+		checker.Add("migrations", pgStrg.CheckMigrations)
+	}
+	checker.Add("backup_writable", b.CheckWritable)
+	checker.Add("backup_age", b.CheckAge)
+
+	routerOpts := []routers.Option{routers.WithReadiness(checker), routers.WithStartGate(checker.Started)}
+	switch {
+	case cfg.TokensFile != "":
+		var tokens *auth.StaticStore
+		if tokens, err = auth.LoadFile(cfg.TokensFile); err != nil {
+			return
+		}
+		routerOpts = append(routerOpts, routers.WithTokens(tokens))
+		logger.Log.Infoln("Authentication enabled with tokens file")
+	case cfg.TokensDB:
+		if pgStrg == nil {
+			return errors.New("tokens from database require postgres storage")
+		}
+		routerOpts = append(routerOpts, routers.WithTokens(auth.StoreFunc(pgStrg.LookupToken)))
+		logger.Log.Infoln("Authentication enabled with tokens from database")
+	}
+
+	routerOpts = append(routerOpts, routers.WithIngestLimits(mw.BodyLimits{
+		Compressed:   cfg.MaxBodySize,
+		Decompressed: cfg.MaxDecodedSize,
+	}, cfg.MaxBatch))
+	if cfg.RateLimit > 0 {
+		routerOpts = append(routerOpts, routers.WithRateLimit(mw.NewRateLimiter(cfg.RateLimit, cfg.RateBurst, cfg.RateLimitBy)))
+	}
+	if cfg.IdempotencyWindow > 0 {
+		routerOpts = append(routerOpts, routers.WithIdempotency(mw.NewIdempotency(cfg.IdempotencyWindow.Duration())))
+	}
+
+	if cfg.EnableAdmin {
+		routerOpts = append(routerOpts, routers.WithAdmin(b.Trigger), routers.WithBackup(b.Trigger))
+		logger.Log.Infoln("Admin endpoints enabled")
+	}
+
+	router := routers.NewMetricRouter(service, routerOpts...)
+
+	var (
+		serverOpts []httpserver.Option
+		certs      *tlsutil.Reloader
+	)
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		if certs, err = tlsutil.NewReloader(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA); err != nil {
 			return
 		}
+		serverOpts = append(serverOpts, httpserver.WithTLS(certs.ServerConfig()))
+		logger.Log.Infoln("HTTPS enabled")
+	} else if cfg.TLSClientCA != "" {
+		return errors.New("client certificate verification requires a server certificate")
+	}
+
+	// A nil channel never fires, so the loop below ignores a disabled internal listener.
+	var internalNotify chan error
+	if cfg.InternalAddr != "" {
+		internal := httpserver.New(routers.NewInternalRouter(selfmetrics.Default), cfg.InternalAddr)
+		internal.Start()
+		internalNotify = internal.Notify()
+		logger.Log.Infof("Server metrics exposed on %s", cfg.InternalAddr)
+	}
+	if cfg.SelfMetricsIntr > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		selfmetrics.NewFlusher(selfmetrics.Default, strg).Start(ctx, cfg.SelfMetricsIntr.Duration())
 	}
 
-	router := routers.NewMetricRouter(service)
-	httpserver := httpserver.New(router, cfg.Addr)
+	httpserver := httpserver.New(router, cfg.Addr, serverOpts...)
 
+	// The server listens during restore so that probes see it is not ready yet; writes are
+	// refused until the restore is over.
 	httpserver.Start()
+	if cfg.Restore {
+		if err = b.Restore(); err != nil && !errors.Is(err, io.EOF) {
+			return
+		}
+	}
 	b.Start()
+	checker.SetPhase(health.PhaseReady)
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
 
-	select {
-	case err = <-httpserver.Notify():
-		return
-	case err = <-b.Notify():
-		return
-	case s := <-interrupt:
-		logger.Log.Infoln("server - Run - signal: " + s.String())
+	for {
+		select {
+		case err = <-httpserver.Notify():
+			return
+		case err = <-b.Notify():
+			return
+		case err = <-internalNotify:
+			return
+		case <-hangup:
+			logger.Log.Infoln("server - Run - signal: hangup, reloading")
+			if certs != nil {
+				if errReload := certs.Reload(); errReload != nil {
+					logger.Log.Errorf("Failed to reload certificates: %s", errReload.Error())
+				} else {
+					logger.Log.Infoln("Certificates reloaded")
+				}
+			}
+			cfg = reloadConfig(cfg, b)
+		case s := <-interrupt:
+			logger.Log.Infoln("server - Run - signal: " + s.String())
+			return shutdown(httpserver, checker, cfg.ShutdownDrain.Duration())
+		}
+	}
+}
+
+// shutdown fails readiness for the drain period so that load balancers stop sending
+// requests, then waits for the active ones.
+func shutdown(srv *httpserver.HTTPServer, checker *health.Checker, drain time.Duration) error {
+	checker.SetPhase(health.PhaseDraining)
+	if drain > 0 {
+		logger.Log.Infof("Draining for %s", drain)
+		time.Sleep(drain)
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
 
-	return nil
+// restoreDryRun prints the metrics a restore would change, nothing is written.
+func restoreDryRun(out io.Writer, b *backup.MetricsBackup) error {
+	changes, err := b.Diff()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TENANT\tTYPE\tNAME\tCURRENT\tRESTORED")
+	for _, ch := range changes {
+		current := ch.Current
+		if current == "" {
+			current = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", ch.Tenant, ch.Type, ch.Name, current, ch.Restored)
+	}
+	if err = tw.Flush(); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%d metrics would change\n", len(changes))
+	return err
+}
+
+// reloadConfig re-reads the config and applies settings that do not need a restart.
+// The returned config describes what is in effect: on an invalid config the current one
+// stays, changes needing a restart are only reported.
+func reloadConfig(cfg *configs.ServerConfig, b *backup.MetricsBackup) *configs.ServerConfig {
+	next, err := cfg.Reload()
+	if err != nil {
+		logger.Log.Errorf("Failed to reload config, keeping the current one: %s", err.Error())
+		return cfg
+	}
+	if changed := cfg.RestartRequired(next); len(changed) > 0 {
+		logger.Log.Warnf("Config changes of %v take effect after restart", changed)
+	}
+
+	applied := *cfg
+	if next.LogLevel != cfg.LogLevel {
+		if err = logger.SetLevel(next.LogLevel); err != nil {
+			logger.Log.Errorf("Failed to apply the log level: %s", err.Error())
+		} else {
+			applied.LogLevel = next.LogLevel
+		}
+	}
+	if next.FileStoragePath != cfg.FileStoragePath || next.StoreIntr != cfg.StoreIntr {
+		if cfg.StoreIntr == 0 || next.StoreIntr == 0 {
+			logger.Log.Warnln("Backup changes take effect after restart while the write-ahead log is involved")
+		} else {
+			b.Reschedule(next.FileStoragePath, next.StoreIntr.Duration())
+			applied.FileStoragePath, applied.StoreIntr = next.FileStoragePath, next.StoreIntr
+		}
+	}
+	logger.Log.Infoln("Config reloaded")
+	return &applied
 }
@@ -2,24 +2,81 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
 
 	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+const (
+	gaugeType   = "gauge"
+	counterType = "counter"
 )
 
 type MetricService struct {
-	strg MetricStorage
+	strg         MetricStorage
+	seriesQuota  int
+	tenantQuotas map[string]int
+	wlog         WriteLog
+	wlogMu       sync.RWMutex
+	retryDelays  []time.Duration
+	tenantLocks  sync.Map
+}
+
+// WriteLog durably records changes of metrics and their metadata. Append gets counter
+// deltas, AppendSet absolute counter values; admin changes name the metric type as gauge
+// or counter.
+type WriteLog interface {
+	Append(ctx context.Context, tenant string, gauges map[string]float64, counters map[string]int64) error
+	AppendSet(ctx context.Context, tenant string, gauges map[string]float64, counters map[string]int64) error
+	AppendDelete(ctx context.Context, tenant, tp, name string) error
+	AppendReset(ctx context.Context, tenant, name string) error
+	AppendRename(ctx context.Context, tenant, tp, from, to string) error
+	AppendMeta(ctx context.Context, tenant string, meta *m.MetricMeta) error
+}
+
+type Option func(*MetricService)
+
+// WithSeriesQuota limits the number of series (distinct type and name pairs) per tenant.
+// Zero means unlimited; quotas holds overrides for individual tenants.
+func WithSeriesQuota(quota int, quotas map[string]int) Option {
+	return func(ms *MetricService) {
+		ms.seriesQuota = quota
+		ms.tenantQuotas = quotas
+	}
+}
+
+// WithWriteLog records every change of metrics in l once storage applied it.
+func WithWriteLog(l WriteLog) Option {
+	return func(ms *MetricService) {
+		ms.wlog = l
+	}
 }
 
 func (ms *MetricService) Close() error {
 	return ms.strg.Close()
 }
 
-func NewMetricService(strg MetricStorage) *MetricService {
-	return &MetricService{strg}
+// writeRetryDelays are the waits before each retry of a logged change that failed in
+// storage with a transient error.
+var writeRetryDelays = []time.Duration{time.Second, 3 * time.Second, 5 * time.Second}
+
+func NewMetricService(strg MetricStorage, opts ...Option) *MetricService {
+	ms := &MetricService{strg: strg, retryDelays: writeRetryDelays}
+	for _, opt := range opts {
+		opt(ms)
+	}
+	return ms
 }
 
 func (ms *MetricService) GetGaugeMetric(ctx context.Context, nm string) (*m.GaugeMetric, error) {
+	defer selfmetrics.Default.Time("storage.read")()
 	val, err := ms.strg.ReadGauge(ctx, nm)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get gauge metric with name %s: %w", nm, err)
@@ -28,6 +85,7 @@ func (ms *MetricService) GetGaugeMetric(ctx context.Context, nm string) (*m.Gaug
 }
 
 func (ms *MetricService) GetCounterMetric(ctx context.Context, nm string) (*m.CounterMetric, error) {
+	defer selfmetrics.Default.Time("storage.read")()
 	val, err := ms.strg.ReadCounter(ctx, nm)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get counter metric with name %s: %w", nm, err)
@@ -36,20 +94,39 @@ func (ms *MetricService) GetCounterMetric(ctx context.Context, nm string) (*m.Co
 }
 
 func (ms *MetricService) PushGaugeMetric(ctx context.Context, m *m.GaugeMetric) error {
-	if err := ms.strg.WriteGauge(ctx, m.Name, m.Value); err != nil {
+	defer selfmetrics.Default.Time("storage.write")()
+	if err := checkReserved(m.Name); err != nil {
+		return err
+	}
+	err := ms.withQuota(ctx, []string{m.Name}, nil, func() error {
+		return ms.logged(ctx, false, map[string]float64{m.Name: m.Value}, nil, func(ctx context.Context) error {
+			return ms.strg.WriteGauge(ctx, m.Name, m.Value)
+		})
+	})
+	if err != nil {
 		return fmt.Errorf("failed to push gauge metric with name name %s and value %.2f: %w", m.Name, m.Value, err)
 	}
 	return nil
 }
 
 func (ms *MetricService) PushCounterMetric(ctx context.Context, m *m.CounterMetric) error {
-	if err := ms.strg.WriteCounter(ctx, m.Name, m.Value); err != nil {
+	defer selfmetrics.Default.Time("storage.write")()
+	if err := checkReserved(m.Name); err != nil {
+		return err
+	}
+	err := ms.withQuota(ctx, nil, []string{m.Name}, func() error {
+		return ms.logged(ctx, false, nil, map[string]int64{m.Name: m.Value}, func(ctx context.Context) error {
+			return ms.strg.WriteCounter(ctx, m.Name, m.Value)
+		})
+	})
+	if err != nil {
 		return fmt.Errorf("failed to push counter metric with name name %s and value %d: %w", m.Name, m.Value, err)
 	}
 	return nil
 }
 
 func (ms *MetricService) GetAllGaugeMetrics(ctx context.Context) ([]*m.GaugeMetric, error) {
+	defer selfmetrics.Default.Time("storage.read_all")()
 	gauges, err := ms.strg.ReadAllGauges(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all gauge metrics: %w", err)
@@ -62,6 +139,7 @@ func (ms *MetricService) GetAllGaugeMetrics(ctx context.Context) ([]*m.GaugeMetr
 }
 
 func (ms *MetricService) GetAllCounterMetrics(ctx context.Context) ([]*m.CounterMetric, error) {
+	defer selfmetrics.Default.Time("storage.read_all")()
 	counters, err := ms.strg.ReadAllCounters(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get all counter metrics: %w", err)
@@ -81,18 +159,321 @@ func (ms *MetricService) PingDB(ctx context.Context) error {
 }
 
 func (ms *MetricService) PushMetrics(ctx context.Context, gauges []*m.GaugeMetric, counters []*m.CounterMetric) error {
+	defer selfmetrics.Default.Time("storage.write_batch")()
+	size := len(gauges) + len(counters)
+	selfmetrics.Default.Add("ingest.batches", 1)
+	selfmetrics.Default.Add("ingest.batch_metrics", int64(size))
+	selfmetrics.Default.Set("ingest.batch_size_last", float64(size))
+
 	gs := make(map[string]float64)
 	cs := make(map[string]int64)
 
 	for _, gauge := range gauges {
+		if err := checkReserved(gauge.Name); err != nil {
+			return err
+		}
 		gs[gauge.Name] = gauge.Value
 	}
 	for _, counter := range counters {
+		if err := checkReserved(counter.Name); err != nil {
+			return err
+		}
 		cs[counter.Name] += counter.Value
 	}
 
-	if err := ms.strg.WriteGaugesCounters(ctx, gs, cs); err != nil {
+	gaugeNames := slices.Collect(maps.Keys(gs))
+	counterNames := slices.Collect(maps.Keys(cs))
+	err := ms.withQuota(ctx, gaugeNames, counterNames, func() error {
+		return ms.logged(ctx, false, gs, cs, func(ctx context.Context) error {
+			return ms.strg.WriteGaugesCounters(ctx, gs, cs)
+		})
+	})
+	if err != nil {
 		return fmt.Errorf("failed to write gauges and counters: %w", err)
 	}
 	return nil
 }
+
+// SetMetrics stores absolute values: counters are overwritten instead of added to.
+func (ms *MetricService) SetMetrics(ctx context.Context, gauges []*m.GaugeMetric, counters []*m.CounterMetric) error {
+	defer selfmetrics.Default.Time("storage.write_batch")()
+	gs := make(map[string]float64, len(gauges))
+	cs := make(map[string]int64, len(counters))
+	for _, gauge := range gauges {
+		if err := checkReserved(gauge.Name); err != nil {
+			return err
+		}
+		gs[gauge.Name] = gauge.Value
+	}
+	for _, counter := range counters {
+		if err := checkReserved(counter.Name); err != nil {
+			return err
+		}
+		cs[counter.Name] = counter.Value
+	}
+
+	gaugeNames := slices.Collect(maps.Keys(gs))
+	counterNames := slices.Collect(maps.Keys(cs))
+	err := ms.withQuota(ctx, gaugeNames, counterNames, func() error {
+		return ms.logged(ctx, true, gs, cs, func(ctx context.Context) error {
+			return ms.strg.SetGaugesCounters(ctx, gs, cs)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set gauges and counters: %w", err)
+	}
+	return nil
+}
+
+// logged records gauges and counters in the write log, as absolute values when set is
+// true, once write applied them.
+func (ms *MetricService) logged(ctx context.Context, set bool, gauges map[string]float64, counters map[string]int64, write func(context.Context) error) error {
+	return ms.loggedChange(ctx, func(l WriteLog, id string) error {
+		if set {
+			return l.AppendSet(ctx, id, gauges, counters)
+		}
+		return l.Append(ctx, id, gauges, counters)
+	}, write)
+}
+
+// loggedChange runs apply and then records the change of the request tenant with record,
+// so that a change storage refused never reaches the log. A change is acknowledged only
+// once both succeeded; one whose record failed stays in storage without being logged.
+// Both run while PauseWrites waits, apply without storage retries: transient failures are
+// retried after the lock is released, so that a checkpoint does not wait for them.
+func (ms *MetricService) loggedChange(ctx context.Context, record func(l WriteLog, id string) error, apply func(context.Context) error) error {
+	if ms.wlog == nil {
+		return apply(ctx)
+	}
+	once := m.WithoutRetries(ctx)
+	err := ms.applyLogged(once, record, apply)
+	for _, d := range ms.retryDelays {
+		if !errors.Is(err, m.ErrTransient) {
+			return err
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, context.Cause(ctx))
+		case <-timer.C:
+		}
+		err = ms.applyLogged(once, record, apply)
+	}
+	return err
+}
+
+func (ms *MetricService) applyLogged(ctx context.Context, record func(l WriteLog, id string) error, apply func(context.Context) error) error {
+	ms.wlogMu.RLock()
+	defer ms.wlogMu.RUnlock()
+	if err := apply(ctx); err != nil {
+		return err
+	}
+	if err := record(ms.wlog, tenant.FromContext(ctx)); err != nil {
+		return fmt.Errorf("failed to log change: %w", err)
+	}
+	return nil
+}
+
+// PauseWrites runs fn while no logged change is in progress, so that fn sees storage and
+// the write log in the same state.
+func (ms *MetricService) PauseWrites(fn func() error) error {
+	ms.wlogMu.Lock()
+	defer ms.wlogMu.Unlock()
+	return fn()
+}
+
+func (ms *MetricService) Tenants(ctx context.Context) ([]string, error) {
+	ids, err := ms.strg.Tenants(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	return ids, nil
+}
+
+func (ms *MetricService) quota(id string) int {
+	if q, ok := ms.tenantQuotas[id]; ok {
+		return q
+	}
+	return ms.seriesQuota
+}
+
+// withQuota runs write unless a name is locked to the other type or the write would create
+// more series than the tenant quota allows; updates of existing series are always within
+// the quota. Writes of a tenant and declarations of its metadata run one at a time, so
+// that concurrent ones cannot pass the checks together.
+func (ms *MetricService) withQuota(ctx context.Context, gauges, counters []string, write func() error) error {
+	return ms.withQuotaFreeing(ctx, gauges, counters, 0, write)
+}
+
+// withQuotaFreeing is withQuota for writes that also remove freed series, like renames.
+func (ms *MetricService) withQuotaFreeing(ctx context.Context, gauges, counters []string, freed int, write func() error) error {
+	id := tenant.FromContext(ctx)
+	unlock := ms.lockTenant(id)
+	defer unlock()
+
+	if err := ms.checkTypes(ctx, gauges, counters); err != nil {
+		return err
+	}
+	limit := ms.quota(id)
+	if limit <= 0 {
+		return write()
+	}
+	total, missing, err := ms.strg.CountSeries(ctx, gauges, counters)
+	if err != nil {
+		return fmt.Errorf("failed to check series quota: %w", err)
+	}
+	if missing > 0 && total+missing-freed > limit {
+		return fmt.Errorf("tenant %s: %w (limit %d)", id, tenant.ErrQuotaExceeded, limit)
+	}
+	return write()
+}
+
+func (ms *MetricService) lockTenant(id string) func() {
+	mu, _ := ms.tenantLocks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+func (ms *MetricService) DeleteGaugeMetric(ctx context.Context, nm string) error {
+	err := ms.loggedChange(ctx, func(l WriteLog, id string) error {
+		return l.AppendDelete(ctx, id, gaugeType, nm)
+	}, func(ctx context.Context) error {
+		return ms.strg.DeleteGauge(ctx, nm)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete gauge metric with name %s: %w", nm, err)
+	}
+	return nil
+}
+
+func (ms *MetricService) DeleteCounterMetric(ctx context.Context, nm string) error {
+	err := ms.loggedChange(ctx, func(l WriteLog, id string) error {
+		return l.AppendDelete(ctx, id, counterType, nm)
+	}, func(ctx context.Context) error {
+		return ms.strg.DeleteCounter(ctx, nm)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete counter metric with name %s: %w", nm, err)
+	}
+	return nil
+}
+
+func (ms *MetricService) ResetCounterMetric(ctx context.Context, nm string) error {
+	err := ms.loggedChange(ctx, func(l WriteLog, id string) error {
+		return l.AppendReset(ctx, id, nm)
+	}, func(ctx context.Context) error {
+		return ms.strg.ResetCounter(ctx, nm)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset counter metric with name %s: %w", nm, err)
+	}
+	return nil
+}
+
+// RenameGaugeMetric moves a gauge under the same checks as a write to the new name.
+func (ms *MetricService) RenameGaugeMetric(ctx context.Context, from, to string) error {
+	if err := checkReserved(to); err != nil {
+		return err
+	}
+	err := ms.withQuotaFreeing(ctx, []string{to}, nil, 1, func() error {
+		return ms.loggedChange(ctx, func(l WriteLog, id string) error {
+			return l.AppendRename(ctx, id, gaugeType, from, to)
+		}, func(ctx context.Context) error {
+			return ms.strg.RenameGauge(ctx, from, to)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rename gauge metric %s to %s: %w", from, to, err)
+	}
+	return nil
+}
+
+// RenameCounterMetric moves a counter under the same checks as a write to the new name.
+func (ms *MetricService) RenameCounterMetric(ctx context.Context, from, to string) error {
+	if err := checkReserved(to); err != nil {
+		return err
+	}
+	err := ms.withQuotaFreeing(ctx, nil, []string{to}, 1, func() error {
+		return ms.loggedChange(ctx, func(l WriteLog, id string) error {
+			return l.AppendRename(ctx, id, counterType, from, to)
+		}, func(ctx context.Context) error {
+			return ms.strg.RenameCounter(ctx, from, to)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rename counter metric %s to %s: %w", from, to, err)
+	}
+	return nil
+}
+
+func (ms *MetricService) GetMetricMeta(ctx context.Context, nm string) (*m.MetricMeta, error) {
+	meta, err := ms.strg.ReadMeta(ctx, nm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata of %s: %w", nm, err)
+	}
+	return meta, nil
+}
+
+func (ms *MetricService) GetAllMetricMeta(ctx context.Context) (map[string]*m.MetricMeta, error) {
+	metas, err := ms.strg.ReadAllMeta(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all metadata: %w", err)
+	}
+	return metas, nil
+}
+
+// PutMetricMeta stores metadata. Declaring a type is refused while a metric of the other
+// type with the same name exists.
+func (ms *MetricService) PutMetricMeta(ctx context.Context, meta *m.MetricMeta) error {
+	unlock := ms.lockTenant(tenant.FromContext(ctx))
+	defer unlock()
+
+	switch meta.Type {
+	case "":
+	case gaugeType:
+		if _, err := ms.strg.ReadCounter(ctx, meta.Name); err == nil {
+			return fmt.Errorf("%s exists as counter: %w", meta.Name, m.ErrTypeConflict)
+		}
+	case counterType:
+		if _, err := ms.strg.ReadGauge(ctx, meta.Name); err == nil {
+			return fmt.Errorf("%s exists as gauge: %w", meta.Name, m.ErrTypeConflict)
+		}
+	default:
+		return fmt.Errorf("%w: type must be %s, %s or empty", m.ErrInvalidMeta, gaugeType, counterType)
+	}
+	err := ms.loggedChange(ctx, func(l WriteLog, id string) error {
+		return l.AppendMeta(ctx, id, meta)
+	}, func(ctx context.Context) error {
+		return ms.strg.WriteMeta(ctx, meta)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put metadata of %s: %w", meta.Name, err)
+	}
+	return nil
+}
+
+// checkReserved refuses client writes under the prefix of server metrics.
+func checkReserved(nm string) error {
+	if selfmetrics.Reserved(nm) {
+		return fmt.Errorf("%s: %w", nm, m.ErrReservedName)
+	}
+	return nil
+}
+
+// checkTypes refuses writes of names locked to the other type, by a stored series or
+// declared metadata. It costs a single storage lookup for the whole batch.
+func (ms *MetricService) checkTypes(ctx context.Context, gauges, counters []string) error {
+	if len(gauges) == 0 && len(counters) == 0 {
+		return nil
+	}
+	conflicts, err := ms.strg.TypeConflicts(ctx, gauges, counters)
+	if err != nil {
+		return fmt.Errorf("failed to check metric types: %w", err)
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	nm := slices.Min(slices.Collect(maps.Keys(conflicts)))
+	return fmt.Errorf("%s is a %s: %w", nm, conflicts[nm], m.ErrTypeConflict)
+}
@@ -2,11 +2,17 @@ package services
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/storage/mem"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
 	"go.uber.org/mock/gomock"
 )
 
@@ -35,12 +41,14 @@ func TestMetricService(t *testing.T) {
 	})
 
 	t.Run("push gauge metric", func(t *testing.T) {
+		strg.EXPECT().TypeConflicts(ctx, []string{"test"}, nil).Return(map[string]string{}, nil)
 		strg.EXPECT().WriteGauge(ctx, "test", float64(123)).Return(nil)
 		err := mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "test", Value: float64(123)})
 		require.Nil(t, err)
 	})
 
 	t.Run("push counter metric", func(t *testing.T) {
+		strg.EXPECT().TypeConflicts(ctx, nil, []string{"test"}).Return(map[string]string{}, nil)
 		strg.EXPECT().WriteCounter(ctx, "test", int64(123)).Return(nil)
 		err := mservice.PushCounterMetric(ctx, &models.CounterMetric{Name: "test", Value: int64(123)})
 		require.Nil(t, err)
@@ -70,3 +78,299 @@ func TestMetricService(t *testing.T) {
 		assert.Equal(t, []models.CounterMetric{{Name: "test", Value: 123}}, counters)
 	})
 }
+
+func TestMetricServiceSeriesQuota(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	strg := NewMockMetricStorage(ctrl)
+
+	mservice := NewMetricService(strg, WithSeriesQuota(2, map[string]int{"big": 10}))
+
+	ctx := context.Background()
+
+	strg.EXPECT().TypeConflicts(gomock.Any(), gomock.Any(), gomock.Any()).Return(map[string]string{}, nil).AnyTimes()
+
+	t.Run("update of existing series", func(t *testing.T) {
+		strg.EXPECT().CountSeries(ctx, []string{"a"}, nil).Return(2, 0, nil)
+		strg.EXPECT().WriteGauge(ctx, "a", float64(3)).Return(nil)
+		err := mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "a", Value: 3})
+		require.NoError(t, err)
+	})
+
+	t.Run("new series over quota", func(t *testing.T) {
+		strg.EXPECT().CountSeries(ctx, nil, []string{"a"}).Return(2, 1, nil)
+		err := mservice.PushCounterMetric(ctx, &models.CounterMetric{Name: "a", Value: 1})
+		require.ErrorIs(t, err, tenant.ErrQuotaExceeded)
+	})
+
+	t.Run("tenant override", func(t *testing.T) {
+		ctx := tenant.WithTenant(ctx, "big")
+		strg.EXPECT().CountSeries(ctx, []string{"c"}, []string{"d"}).Return(2, 2, nil)
+		strg.EXPECT().WriteGaugesCounters(ctx, map[string]float64{"c": 1}, map[string]int64{"d": 1}).Return(nil)
+		err := mservice.PushMetrics(ctx,
+			[]*models.GaugeMetric{{Name: "c", Value: 1}},
+			[]*models.CounterMetric{{Name: "d", Value: 1}},
+		)
+		require.NoError(t, err)
+	})
+
+	t.Run("rename at quota", func(t *testing.T) {
+		strg.EXPECT().CountSeries(ctx, []string{"b"}, nil).Return(2, 1, nil)
+		strg.EXPECT().RenameGauge(ctx, "a", "b").Return(nil)
+		err := mservice.RenameGaugeMetric(ctx, "a", "b")
+		require.NoError(t, err)
+	})
+
+	t.Run("rename over quota", func(t *testing.T) {
+		strg.EXPECT().CountSeries(ctx, nil, []string{"b"}).Return(3, 1, nil)
+		err := mservice.RenameCounterMetric(ctx, "a", "b")
+		require.ErrorIs(t, err, tenant.ErrQuotaExceeded)
+	})
+}
+
+// slowStorage widens the window between the quota check and the write.
+type slowStorage struct {
+	*mem.MemStorage
+}
+
+func (s slowStorage) WriteGauge(ctx context.Context, name string, value float64) error {
+	time.Sleep(time.Millisecond)
+	return s.MemStorage.WriteGauge(ctx, name, value)
+}
+
+func TestMetricServiceSeriesQuotaConcurrent(t *testing.T) {
+	strg := mem.NewMemStorage()
+	mservice := NewMetricService(slowStorage{strg}, WithSeriesQuota(3, nil))
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: fmt.Sprintf("g%d", i), Value: 1})
+		}()
+	}
+	wg.Wait()
+
+	total, _, err := strg.CountSeries(ctx, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+}
+
+func TestMetricServiceTypeLocking(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	strg := NewMockMetricStorage(ctrl)
+
+	mservice := NewMetricService(strg)
+
+	ctx := context.Background()
+
+	t.Run("write with declared type", func(t *testing.T) {
+		strg.EXPECT().TypeConflicts(ctx, []string{"Alloc"}, nil).Return(map[string]string{}, nil)
+		strg.EXPECT().WriteGauge(ctx, "Alloc", float64(1)).Return(nil)
+		err := mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "Alloc", Value: 1})
+		require.NoError(t, err)
+	})
+
+	t.Run("write with conflicting type", func(t *testing.T) {
+		strg.EXPECT().TypeConflicts(ctx, nil, []string{"Alloc"}).Return(map[string]string{"Alloc": "gauge"}, nil)
+		err := mservice.PushCounterMetric(ctx, &models.CounterMetric{Name: "Alloc", Value: 1})
+		require.ErrorIs(t, err, models.ErrTypeConflict)
+	})
+
+	t.Run("batch with conflicting type", func(t *testing.T) {
+		strg.EXPECT().TypeConflicts(ctx, nil, []string{"Alloc"}).Return(map[string]string{"Alloc": "gauge"}, nil)
+		err := mservice.PushMetrics(ctx, nil, []*models.CounterMetric{{Name: "Alloc", Value: 1}})
+		require.ErrorIs(t, err, models.ErrTypeConflict)
+	})
+
+	t.Run("rename to conflicting type", func(t *testing.T) {
+		strg.EXPECT().TypeConflicts(ctx, nil, []string{"Alloc"}).Return(map[string]string{"Alloc": "gauge"}, nil)
+		err := mservice.RenameCounterMetric(ctx, "PollCount", "Alloc")
+		require.ErrorIs(t, err, models.ErrTypeConflict)
+	})
+
+	t.Run("rename to reserved name", func(t *testing.T) {
+		err := mservice.RenameGaugeMetric(ctx, "Alloc", "_server.uptime")
+		require.ErrorIs(t, err, models.ErrReservedName)
+	})
+
+	t.Run("declare type of existing metric of other type", func(t *testing.T) {
+		strg.EXPECT().ReadGauge(ctx, "PollCount").Return(float64(1), nil)
+		err := mservice.PutMetricMeta(ctx, &models.MetricMeta{Name: "PollCount", Type: "counter"})
+		require.ErrorIs(t, err, models.ErrTypeConflict)
+	})
+
+	t.Run("declare unknown type", func(t *testing.T) {
+		err := mservice.PutMetricMeta(ctx, &models.MetricMeta{Name: "PollCount", Type: "histogram"})
+		require.ErrorIs(t, err, models.ErrInvalidMeta)
+	})
+}
+
+func TestMetricServiceTypeLockingStored(t *testing.T) {
+	mservice := NewMetricService(mem.NewMemStorage())
+	ctx := context.Background()
+
+	require.NoError(t, mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "Alloc", Value: 1}))
+	require.NoError(t, mservice.PutMetricMeta(ctx, &models.MetricMeta{Name: "PollCount", Type: "counter"}))
+
+	err := mservice.PushCounterMetric(ctx, &models.CounterMetric{Name: "Alloc", Value: 1})
+	assert.ErrorIs(t, err, models.ErrTypeConflict, "a stored gauge locks the name")
+	err = mservice.PushMetrics(ctx, []*models.GaugeMetric{{Name: "PollCount", Value: 1}}, nil)
+	assert.ErrorIs(t, err, models.ErrTypeConflict, "a declared counter locks the name")
+	assert.NoError(t, mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "Alloc", Value: 2}))
+}
+
+func TestMetricServiceTypeLockingConcurrent(t *testing.T) {
+	strg := mem.NewMemStorage()
+	mservice := NewMetricService(slowStorage{strg})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(3)
+		name := fmt.Sprintf("m%d", i)
+		go func() {
+			defer wg.Done()
+			_ = mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: name, Value: 1})
+		}()
+		go func() {
+			defer wg.Done()
+			_ = mservice.PushCounterMetric(ctx, &models.CounterMetric{Name: name, Value: 1})
+		}()
+		go func() {
+			defer wg.Done()
+			_ = mservice.PutMetricMeta(ctx, &models.MetricMeta{Name: name, Type: "counter"})
+		}()
+	}
+	wg.Wait()
+
+	for i := range 10 {
+		name := fmt.Sprintf("m%d", i)
+		_, gaugeErr := strg.ReadGauge(ctx, name)
+		_, counterErr := strg.ReadCounter(ctx, name)
+		assert.False(t, gaugeErr == nil && counterErr == nil, "%s stored as both types", name)
+		meta, err := strg.ReadMeta(ctx, name)
+		if err == nil && meta.Type == "counter" {
+			assert.Error(t, gaugeErr, "%s stored as gauge though declared a counter", name)
+		}
+	}
+}
+
+// orderLog records appends in steps shared with orderStorage, failing when err is set.
+type orderLog struct {
+	steps *[]string
+	err   error
+}
+
+func (l orderLog) Append(_ context.Context, _ string, _ map[string]float64, _ map[string]int64) error {
+	*l.steps = append(*l.steps, "log")
+	return l.err
+}
+
+func (l orderLog) AppendSet(ctx context.Context, id string, gauges map[string]float64, counters map[string]int64) error {
+	return l.Append(ctx, id, gauges, counters)
+}
+
+func (l orderLog) AppendDelete(ctx context.Context, id, _, _ string) error {
+	return l.Append(ctx, id, nil, nil)
+}
+
+func (l orderLog) AppendReset(ctx context.Context, id, _ string) error {
+	return l.Append(ctx, id, nil, nil)
+}
+
+func (l orderLog) AppendRename(ctx context.Context, id, _, _, _ string) error {
+	return l.Append(ctx, id, nil, nil)
+}
+
+func (l orderLog) AppendMeta(ctx context.Context, id string, _ *models.MetricMeta) error {
+	return l.Append(ctx, id, nil, nil)
+}
+
+// orderStorage records gauge writes in steps, failing with the errors of fail in turn.
+// Every write is announced on called when it is set.
+type orderStorage struct {
+	*mem.MemStorage
+	steps  *[]string
+	fail   *[]error
+	called chan struct{}
+}
+
+func (s orderStorage) WriteGauge(ctx context.Context, name string, value float64) error {
+	step := "storage"
+	if !models.RetriesDisabled(ctx) {
+		step += " with retries"
+	}
+	*s.steps = append(*s.steps, step)
+	if s.called != nil {
+		s.called <- struct{}{}
+	}
+	if s.fail != nil && len(*s.fail) > 0 {
+		err := (*s.fail)[0]
+		*s.fail = (*s.fail)[1:]
+		return err
+	}
+	return s.MemStorage.WriteGauge(ctx, name, value)
+}
+
+func TestMetricServiceWriteLogAfterStorage(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		steps []string
+		fail  []error
+	)
+	strg := orderStorage{MemStorage: mem.NewMemStorage(), steps: &steps, fail: &fail}
+	mservice := NewMetricService(strg, WithWriteLog(orderLog{steps: &steps}))
+	mservice.retryDelays = []time.Duration{time.Millisecond}
+	require.NoError(t, mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 1}))
+	assert.Equal(t, []string{"storage", "log"}, steps)
+
+	steps, fail = nil, []error{errors.New("constraint violated")}
+	require.Error(t, mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 2}))
+	assert.Equal(t, []string{"storage"}, steps, "a write that is not applied is not logged")
+
+	steps, fail = nil, []error{fmt.Errorf("%w: serialization failure", models.ErrTransient)}
+	require.NoError(t, mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 3}))
+	assert.Equal(t, []string{"storage", "storage", "log"}, steps)
+
+	steps = nil
+	mservice = NewMetricService(strg, WithWriteLog(orderLog{steps: &steps, err: errors.New("disk full")}))
+	require.Error(t, mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 4}))
+	assert.Equal(t, []string{"storage", "log"}, steps)
+}
+
+func TestMetricServiceRetriesOutsideOfPause(t *testing.T) {
+	var (
+		steps  []string
+		fail   = []error{models.ErrTransient}
+		called = make(chan struct{}, 1)
+	)
+	strg := orderStorage{MemStorage: mem.NewMemStorage(), steps: &steps, fail: &fail, called: called}
+	mservice := NewMetricService(strg, WithWriteLog(orderLog{steps: &steps}))
+	mservice.retryDelays = []time.Duration{time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pushed := make(chan error, 1)
+	go func() {
+		pushed <- mservice.PushGaugeMetric(ctx, &models.GaugeMetric{Name: "g", Value: 1})
+	}()
+	<-called
+
+	paused := make(chan struct{})
+	go func() {
+		assert.NoError(t, mservice.PauseWrites(func() error { return nil }))
+		close(paused)
+	}()
+	select {
+	case <-paused:
+	case <-time.After(time.Second):
+		t.Fatal("writes do not pause while a failed write waits to retry")
+	}
+
+	cancel()
+	err := <-pushed
+	assert.ErrorIs(t, err, models.ErrTransient)
+	assert.ErrorIs(t, err, context.Canceled)
+}
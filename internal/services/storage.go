@@ -1,6 +1,10 @@
 package services
 
-import "context"
+import (
+	"context"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+)
 
 type MetricStorage interface {
 	MetricsReader
@@ -8,6 +12,13 @@ type MetricStorage interface {
 	AllMetricsReader
 	Pinger
 	GaugesCountersWriter
+	GaugesCountersSetter
+	TenantsLister
+	MetricsDeleter
+	MetricsRenamer
+	MetaStorage
+	SeriesCounter
+	TypeConflictFinder
 	Closer
 }
 
@@ -37,3 +48,43 @@ type Pinger interface {
 type GaugesCountersWriter interface {
 	WriteGaugesCounters(ctx context.Context, gauges map[string]float64, counters map[string]int64) error
 }
+
+// GaugesCountersSetter stores absolute values: counters are overwritten instead of added to.
+type GaugesCountersSetter interface {
+	SetGaugesCounters(ctx context.Context, gauges map[string]float64, counters map[string]int64) error
+}
+
+type TenantsLister interface {
+	Tenants(context.Context) ([]string, error)
+}
+
+type MetricsDeleter interface {
+	DeleteGauge(context.Context, string) error
+	DeleteCounter(context.Context, string) error
+	ResetCounter(context.Context, string) error
+}
+
+// MetricsRenamer moves a metric to a new name. A gauge overwrites the target,
+// a counter is added to it.
+type MetricsRenamer interface {
+	RenameGauge(ctx context.Context, from, to string) error
+	RenameCounter(ctx context.Context, from, to string) error
+}
+
+type MetaStorage interface {
+	ReadMeta(context.Context, string) (*models.MetricMeta, error)
+	ReadAllMeta(context.Context) (map[string]*models.MetricMeta, error)
+	WriteMeta(context.Context, *models.MetricMeta) error
+}
+
+// SeriesCounter counts series of the request tenant without reading them: total is the
+// number of stored series, missing how many of the given names are not stored yet.
+type SeriesCounter interface {
+	CountSeries(ctx context.Context, gauges, counters []string) (total, missing int, err error)
+}
+
+// TypeConflictFinder looks up in one go which of the given names of the request tenant
+// are locked to the other type, stored or declared as such. It maps them to that type.
+type TypeConflictFinder interface {
+	TypeConflicts(ctx context.Context, gauges, counters []string) (map[string]string, error)
+}
@@ -13,6 +13,7 @@ import (
 	context "context"
 	reflect "reflect"
 
+	models "github.com/volchkovski/go-practicum-metrics/internal/models"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -54,6 +55,50 @@ func (mr *MockMetricStorageMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockMetricStorage)(nil).Close))
 }
 
+// CountSeries mocks base method.
+func (m *MockMetricStorage) CountSeries(ctx context.Context, gauges, counters []string) (int, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountSeries", ctx, gauges, counters)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CountSeries indicates an expected call of CountSeries.
+func (mr *MockMetricStorageMockRecorder) CountSeries(ctx, gauges, counters any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountSeries", reflect.TypeOf((*MockMetricStorage)(nil).CountSeries), ctx, gauges, counters)
+}
+
+// DeleteCounter mocks base method.
+func (m *MockMetricStorage) DeleteCounter(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteCounter", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCounter indicates an expected call of DeleteCounter.
+func (mr *MockMetricStorageMockRecorder) DeleteCounter(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCounter", reflect.TypeOf((*MockMetricStorage)(nil).DeleteCounter), arg0, arg1)
+}
+
+// DeleteGauge mocks base method.
+func (m *MockMetricStorage) DeleteGauge(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteGauge", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteGauge indicates an expected call of DeleteGauge.
+func (mr *MockMetricStorageMockRecorder) DeleteGauge(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteGauge", reflect.TypeOf((*MockMetricStorage)(nil).DeleteGauge), arg0, arg1)
+}
+
 // Ping mocks base method.
 func (m *MockMetricStorage) Ping(arg0 context.Context) error {
 	m.ctrl.T.Helper()
@@ -98,6 +143,21 @@ func (mr *MockMetricStorageMockRecorder) ReadAllGauges(arg0 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadAllGauges", reflect.TypeOf((*MockMetricStorage)(nil).ReadAllGauges), arg0)
 }
 
+// ReadAllMeta mocks base method.
+func (m *MockMetricStorage) ReadAllMeta(arg0 context.Context) (map[string]*models.MetricMeta, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadAllMeta", arg0)
+	ret0, _ := ret[0].(map[string]*models.MetricMeta)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadAllMeta indicates an expected call of ReadAllMeta.
+func (mr *MockMetricStorageMockRecorder) ReadAllMeta(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadAllMeta", reflect.TypeOf((*MockMetricStorage)(nil).ReadAllMeta), arg0)
+}
+
 // ReadCounter mocks base method.
 func (m *MockMetricStorage) ReadCounter(arg0 context.Context, arg1 string) (int64, error) {
 	m.ctrl.T.Helper()
@@ -128,6 +188,107 @@ func (mr *MockMetricStorageMockRecorder) ReadGauge(arg0, arg1 any) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadGauge", reflect.TypeOf((*MockMetricStorage)(nil).ReadGauge), arg0, arg1)
 }
 
+// ReadMeta mocks base method.
+func (m *MockMetricStorage) ReadMeta(arg0 context.Context, arg1 string) (*models.MetricMeta, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadMeta", arg0, arg1)
+	ret0, _ := ret[0].(*models.MetricMeta)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadMeta indicates an expected call of ReadMeta.
+func (mr *MockMetricStorageMockRecorder) ReadMeta(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadMeta", reflect.TypeOf((*MockMetricStorage)(nil).ReadMeta), arg0, arg1)
+}
+
+// RenameCounter mocks base method.
+func (m *MockMetricStorage) RenameCounter(ctx context.Context, from, to string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenameCounter", ctx, from, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameCounter indicates an expected call of RenameCounter.
+func (mr *MockMetricStorageMockRecorder) RenameCounter(ctx, from, to any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameCounter", reflect.TypeOf((*MockMetricStorage)(nil).RenameCounter), ctx, from, to)
+}
+
+// RenameGauge mocks base method.
+func (m *MockMetricStorage) RenameGauge(ctx context.Context, from, to string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenameGauge", ctx, from, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameGauge indicates an expected call of RenameGauge.
+func (mr *MockMetricStorageMockRecorder) RenameGauge(ctx, from, to any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameGauge", reflect.TypeOf((*MockMetricStorage)(nil).RenameGauge), ctx, from, to)
+}
+
+// ResetCounter mocks base method.
+func (m *MockMetricStorage) ResetCounter(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetCounter", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetCounter indicates an expected call of ResetCounter.
+func (mr *MockMetricStorageMockRecorder) ResetCounter(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetCounter", reflect.TypeOf((*MockMetricStorage)(nil).ResetCounter), arg0, arg1)
+}
+
+// SetGaugesCounters mocks base method.
+func (m *MockMetricStorage) SetGaugesCounters(ctx context.Context, gauges map[string]float64, counters map[string]int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetGaugesCounters", ctx, gauges, counters)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetGaugesCounters indicates an expected call of SetGaugesCounters.
+func (mr *MockMetricStorageMockRecorder) SetGaugesCounters(ctx, gauges, counters any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetGaugesCounters", reflect.TypeOf((*MockMetricStorage)(nil).SetGaugesCounters), ctx, gauges, counters)
+}
+
+// Tenants mocks base method.
+func (m *MockMetricStorage) Tenants(arg0 context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Tenants", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Tenants indicates an expected call of Tenants.
+func (mr *MockMetricStorageMockRecorder) Tenants(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Tenants", reflect.TypeOf((*MockMetricStorage)(nil).Tenants), arg0)
+}
+
+// TypeConflicts mocks base method.
+func (m *MockMetricStorage) TypeConflicts(ctx context.Context, gauges, counters []string) (map[string]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TypeConflicts", ctx, gauges, counters)
+	ret0, _ := ret[0].(map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TypeConflicts indicates an expected call of TypeConflicts.
+func (mr *MockMetricStorageMockRecorder) TypeConflicts(ctx, gauges, counters any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TypeConflicts", reflect.TypeOf((*MockMetricStorage)(nil).TypeConflicts), ctx, gauges, counters)
+}
+
 // WriteCounter mocks base method.
 func (m *MockMetricStorage) WriteCounter(arg0 context.Context, arg1 string, arg2 int64) error {
 	m.ctrl.T.Helper()
@@ -170,6 +331,20 @@ func (mr *MockMetricStorageMockRecorder) WriteGaugesCounters(ctx, gauges, counte
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteGaugesCounters", reflect.TypeOf((*MockMetricStorage)(nil).WriteGaugesCounters), ctx, gauges, counters)
 }
 
+// WriteMeta mocks base method.
+func (m *MockMetricStorage) WriteMeta(arg0 context.Context, arg1 *models.MetricMeta) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteMeta", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteMeta indicates an expected call of WriteMeta.
+func (mr *MockMetricStorageMockRecorder) WriteMeta(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteMeta", reflect.TypeOf((*MockMetricStorage)(nil).WriteMeta), arg0, arg1)
+}
+
 // MockCloser is a mock of Closer interface.
 type MockCloser struct {
 	ctrl     *gomock.Controller
@@ -443,3 +618,345 @@ func (mr *MockGaugesCountersWriterMockRecorder) WriteGaugesCounters(ctx, gauges,
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteGaugesCounters", reflect.TypeOf((*MockGaugesCountersWriter)(nil).WriteGaugesCounters), ctx, gauges, counters)
 }
+
+// MockGaugesCountersSetter is a mock of GaugesCountersSetter interface.
+type MockGaugesCountersSetter struct {
+	ctrl     *gomock.Controller
+	recorder *MockGaugesCountersSetterMockRecorder
+	isgomock struct{}
+}
+
+// MockGaugesCountersSetterMockRecorder is the mock recorder for MockGaugesCountersSetter.
+type MockGaugesCountersSetterMockRecorder struct {
+	mock *MockGaugesCountersSetter
+}
+
+// NewMockGaugesCountersSetter creates a new mock instance.
+func NewMockGaugesCountersSetter(ctrl *gomock.Controller) *MockGaugesCountersSetter {
+	mock := &MockGaugesCountersSetter{ctrl: ctrl}
+	mock.recorder = &MockGaugesCountersSetterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockGaugesCountersSetter) EXPECT() *MockGaugesCountersSetterMockRecorder {
+	return m.recorder
+}
+
+// SetGaugesCounters mocks base method.
+func (m *MockGaugesCountersSetter) SetGaugesCounters(ctx context.Context, gauges map[string]float64, counters map[string]int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetGaugesCounters", ctx, gauges, counters)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetGaugesCounters indicates an expected call of SetGaugesCounters.
+func (mr *MockGaugesCountersSetterMockRecorder) SetGaugesCounters(ctx, gauges, counters any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetGaugesCounters", reflect.TypeOf((*MockGaugesCountersSetter)(nil).SetGaugesCounters), ctx, gauges, counters)
+}
+
+// MockTenantsLister is a mock of TenantsLister interface.
+type MockTenantsLister struct {
+	ctrl     *gomock.Controller
+	recorder *MockTenantsListerMockRecorder
+	isgomock struct{}
+}
+
+// MockTenantsListerMockRecorder is the mock recorder for MockTenantsLister.
+type MockTenantsListerMockRecorder struct {
+	mock *MockTenantsLister
+}
+
+// NewMockTenantsLister creates a new mock instance.
+func NewMockTenantsLister(ctrl *gomock.Controller) *MockTenantsLister {
+	mock := &MockTenantsLister{ctrl: ctrl}
+	mock.recorder = &MockTenantsListerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTenantsLister) EXPECT() *MockTenantsListerMockRecorder {
+	return m.recorder
+}
+
+// Tenants mocks base method.
+func (m *MockTenantsLister) Tenants(arg0 context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Tenants", arg0)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Tenants indicates an expected call of Tenants.
+func (mr *MockTenantsListerMockRecorder) Tenants(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Tenants", reflect.TypeOf((*MockTenantsLister)(nil).Tenants), arg0)
+}
+
+// MockMetricsDeleter is a mock of MetricsDeleter interface.
+type MockMetricsDeleter struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricsDeleterMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricsDeleterMockRecorder is the mock recorder for MockMetricsDeleter.
+type MockMetricsDeleterMockRecorder struct {
+	mock *MockMetricsDeleter
+}
+
+// NewMockMetricsDeleter creates a new mock instance.
+func NewMockMetricsDeleter(ctrl *gomock.Controller) *MockMetricsDeleter {
+	mock := &MockMetricsDeleter{ctrl: ctrl}
+	mock.recorder = &MockMetricsDeleterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricsDeleter) EXPECT() *MockMetricsDeleterMockRecorder {
+	return m.recorder
+}
+
+// DeleteCounter mocks base method.
+func (m *MockMetricsDeleter) DeleteCounter(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteCounter", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCounter indicates an expected call of DeleteCounter.
+func (mr *MockMetricsDeleterMockRecorder) DeleteCounter(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCounter", reflect.TypeOf((*MockMetricsDeleter)(nil).DeleteCounter), arg0, arg1)
+}
+
+// DeleteGauge mocks base method.
+func (m *MockMetricsDeleter) DeleteGauge(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteGauge", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteGauge indicates an expected call of DeleteGauge.
+func (mr *MockMetricsDeleterMockRecorder) DeleteGauge(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteGauge", reflect.TypeOf((*MockMetricsDeleter)(nil).DeleteGauge), arg0, arg1)
+}
+
+// ResetCounter mocks base method.
+func (m *MockMetricsDeleter) ResetCounter(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetCounter", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetCounter indicates an expected call of ResetCounter.
+func (mr *MockMetricsDeleterMockRecorder) ResetCounter(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetCounter", reflect.TypeOf((*MockMetricsDeleter)(nil).ResetCounter), arg0, arg1)
+}
+
+// MockMetricsRenamer is a mock of MetricsRenamer interface.
+type MockMetricsRenamer struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetricsRenamerMockRecorder
+	isgomock struct{}
+}
+
+// MockMetricsRenamerMockRecorder is the mock recorder for MockMetricsRenamer.
+type MockMetricsRenamerMockRecorder struct {
+	mock *MockMetricsRenamer
+}
+
+// NewMockMetricsRenamer creates a new mock instance.
+func NewMockMetricsRenamer(ctrl *gomock.Controller) *MockMetricsRenamer {
+	mock := &MockMetricsRenamer{ctrl: ctrl}
+	mock.recorder = &MockMetricsRenamerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetricsRenamer) EXPECT() *MockMetricsRenamerMockRecorder {
+	return m.recorder
+}
+
+// RenameCounter mocks base method.
+func (m *MockMetricsRenamer) RenameCounter(ctx context.Context, from, to string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenameCounter", ctx, from, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameCounter indicates an expected call of RenameCounter.
+func (mr *MockMetricsRenamerMockRecorder) RenameCounter(ctx, from, to any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameCounter", reflect.TypeOf((*MockMetricsRenamer)(nil).RenameCounter), ctx, from, to)
+}
+
+// RenameGauge mocks base method.
+func (m *MockMetricsRenamer) RenameGauge(ctx context.Context, from, to string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenameGauge", ctx, from, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameGauge indicates an expected call of RenameGauge.
+func (mr *MockMetricsRenamerMockRecorder) RenameGauge(ctx, from, to any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameGauge", reflect.TypeOf((*MockMetricsRenamer)(nil).RenameGauge), ctx, from, to)
+}
+
+// MockMetaStorage is a mock of MetaStorage interface.
+type MockMetaStorage struct {
+	ctrl     *gomock.Controller
+	recorder *MockMetaStorageMockRecorder
+	isgomock struct{}
+}
+
+// MockMetaStorageMockRecorder is the mock recorder for MockMetaStorage.
+type MockMetaStorageMockRecorder struct {
+	mock *MockMetaStorage
+}
+
+// NewMockMetaStorage creates a new mock instance.
+func NewMockMetaStorage(ctrl *gomock.Controller) *MockMetaStorage {
+	mock := &MockMetaStorage{ctrl: ctrl}
+	mock.recorder = &MockMetaStorageMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMetaStorage) EXPECT() *MockMetaStorageMockRecorder {
+	return m.recorder
+}
+
+// ReadAllMeta mocks base method.
+func (m *MockMetaStorage) ReadAllMeta(arg0 context.Context) (map[string]*models.MetricMeta, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadAllMeta", arg0)
+	ret0, _ := ret[0].(map[string]*models.MetricMeta)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadAllMeta indicates an expected call of ReadAllMeta.
+func (mr *MockMetaStorageMockRecorder) ReadAllMeta(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadAllMeta", reflect.TypeOf((*MockMetaStorage)(nil).ReadAllMeta), arg0)
+}
+
+// ReadMeta mocks base method.
+func (m *MockMetaStorage) ReadMeta(arg0 context.Context, arg1 string) (*models.MetricMeta, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReadMeta", arg0, arg1)
+	ret0, _ := ret[0].(*models.MetricMeta)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReadMeta indicates an expected call of ReadMeta.
+func (mr *MockMetaStorageMockRecorder) ReadMeta(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReadMeta", reflect.TypeOf((*MockMetaStorage)(nil).ReadMeta), arg0, arg1)
+}
+
+// WriteMeta mocks base method.
+func (m *MockMetaStorage) WriteMeta(arg0 context.Context, arg1 *models.MetricMeta) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteMeta", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteMeta indicates an expected call of WriteMeta.
+func (mr *MockMetaStorageMockRecorder) WriteMeta(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteMeta", reflect.TypeOf((*MockMetaStorage)(nil).WriteMeta), arg0, arg1)
+}
+
+// MockSeriesCounter is a mock of SeriesCounter interface.
+type MockSeriesCounter struct {
+	ctrl     *gomock.Controller
+	recorder *MockSeriesCounterMockRecorder
+	isgomock struct{}
+}
+
+// MockSeriesCounterMockRecorder is the mock recorder for MockSeriesCounter.
+type MockSeriesCounterMockRecorder struct {
+	mock *MockSeriesCounter
+}
+
+// NewMockSeriesCounter creates a new mock instance.
+func NewMockSeriesCounter(ctrl *gomock.Controller) *MockSeriesCounter {
+	mock := &MockSeriesCounter{ctrl: ctrl}
+	mock.recorder = &MockSeriesCounterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSeriesCounter) EXPECT() *MockSeriesCounterMockRecorder {
+	return m.recorder
+}
+
+// CountSeries mocks base method.
+func (m *MockSeriesCounter) CountSeries(ctx context.Context, gauges, counters []string) (int, int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountSeries", ctx, gauges, counters)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(int)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// CountSeries indicates an expected call of CountSeries.
+func (mr *MockSeriesCounterMockRecorder) CountSeries(ctx, gauges, counters any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountSeries", reflect.TypeOf((*MockSeriesCounter)(nil).CountSeries), ctx, gauges, counters)
+}
+
+// MockTypeConflictFinder is a mock of TypeConflictFinder interface.
+type MockTypeConflictFinder struct {
+	ctrl     *gomock.Controller
+	recorder *MockTypeConflictFinderMockRecorder
+	isgomock struct{}
+}
+
+// MockTypeConflictFinderMockRecorder is the mock recorder for MockTypeConflictFinder.
+type MockTypeConflictFinderMockRecorder struct {
+	mock *MockTypeConflictFinder
+}
+
+// NewMockTypeConflictFinder creates a new mock instance.
+func NewMockTypeConflictFinder(ctrl *gomock.Controller) *MockTypeConflictFinder {
+	mock := &MockTypeConflictFinder{ctrl: ctrl}
+	mock.recorder = &MockTypeConflictFinderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTypeConflictFinder) EXPECT() *MockTypeConflictFinderMockRecorder {
+	return m.recorder
+}
+
+// TypeConflicts mocks base method.
+func (m *MockTypeConflictFinder) TypeConflicts(ctx context.Context, gauges, counters []string) (map[string]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TypeConflicts", ctx, gauges, counters)
+	ret0, _ := ret[0].(map[string]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TypeConflicts indicates an expected call of TypeConflicts.
+func (mr *MockTypeConflictFinderMockRecorder) TypeConflicts(ctx, gauges, counters any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TypeConflicts", reflect.TypeOf((*MockTypeConflictFinder)(nil).TypeConflicts), ctx, gauges, counters)
+}
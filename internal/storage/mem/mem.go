@@ -5,24 +5,33 @@ import (
 	"errors"
 	"fmt"
 	"maps"
+	"slices"
 	"sync"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
 )
 
 var ErrCanceled = errors.New("operation is canceled")
 
+// MemStorage keeps metrics of every tenant in its own namespace.
 type MemStorage struct {
-	gauges       map[string]float64
+	gauges       map[string]map[string]float64
 	gaugesLock   sync.RWMutex
-	counters     map[string]int64
+	counters     map[string]map[string]int64
 	countersLock sync.RWMutex
+	metas        map[string]map[string]models.MetricMeta
+	metasLock    sync.RWMutex
 }
 
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
-		gauges:       map[string]float64{},
+		gauges:       map[string]map[string]float64{},
 		gaugesLock:   sync.RWMutex{},
-		counters:     map[string]int64{},
+		counters:     map[string]map[string]int64{},
 		countersLock: sync.RWMutex{},
+		metas:        map[string]map[string]models.MetricMeta{},
+		metasLock:    sync.RWMutex{},
 	}
 }
 
@@ -30,6 +39,29 @@ func (s *MemStorage) Close() error {
 	return nil
 }
 
+// tenantGauges must be called with gaugesLock held for writing. It creates the tenant
+// namespace, so paths that only change existing metrics look it up instead.
+func (s *MemStorage) tenantGauges(ctx context.Context) map[string]float64 {
+	id := tenant.FromContext(ctx)
+	gauges, ok := s.gauges[id]
+	if !ok {
+		gauges = map[string]float64{}
+		s.gauges[id] = gauges
+	}
+	return gauges
+}
+
+// tenantCounters must be called with countersLock held for writing.
+func (s *MemStorage) tenantCounters(ctx context.Context) map[string]int64 {
+	id := tenant.FromContext(ctx)
+	counters, ok := s.counters[id]
+	if !ok {
+		counters = map[string]int64{}
+		s.counters[id] = counters
+	}
+	return counters
+}
+
 func (s *MemStorage) WriteGauge(ctx context.Context, name string, value float64) error {
 	select {
 	case <-ctx.Done():
@@ -37,7 +69,7 @@ func (s *MemStorage) WriteGauge(ctx context.Context, name string, value float64)
 	default:
 		s.gaugesLock.Lock()
 		defer s.gaugesLock.Unlock()
-		s.gauges[name] = value
+		s.tenantGauges(ctx)[name] = value
 		return nil
 	}
 }
@@ -49,7 +81,7 @@ func (s *MemStorage) WriteCounter(ctx context.Context, name string, value int64)
 	default:
 		s.countersLock.Lock()
 		defer s.countersLock.Unlock()
-		s.counters[name] += value
+		s.tenantCounters(ctx)[name] += value
 		return nil
 	}
 }
@@ -61,7 +93,7 @@ func (s *MemStorage) ReadGauge(ctx context.Context, name string) (float64, error
 	default:
 		s.gaugesLock.RLock()
 		defer s.gaugesLock.RUnlock()
-		if m, ok := s.gauges[name]; ok {
+		if m, ok := s.gauges[tenant.FromContext(ctx)][name]; ok {
 			return m, nil
 		}
 		return 0, fmt.Errorf("%s not found", name)
@@ -75,7 +107,7 @@ func (s *MemStorage) ReadCounter(ctx context.Context, name string) (int64, error
 	default:
 		s.countersLock.RLock()
 		defer s.countersLock.RUnlock()
-		if m, ok := s.counters[name]; ok {
+		if m, ok := s.counters[tenant.FromContext(ctx)][name]; ok {
 			return m, nil
 		}
 		return 0, fmt.Errorf("%s not found", name)
@@ -89,7 +121,10 @@ func (s *MemStorage) ReadAllGauges(ctx context.Context) (map[string]float64, err
 	default:
 		s.gaugesLock.RLock()
 		defer s.gaugesLock.RUnlock()
-		gauges := s.gauges
+		gauges := maps.Clone(s.gauges[tenant.FromContext(ctx)])
+		if gauges == nil {
+			gauges = map[string]float64{}
+		}
 		return gauges, nil
 	}
 }
@@ -101,7 +136,10 @@ func (s *MemStorage) ReadAllCounters(ctx context.Context) (map[string]int64, err
 	default:
 		s.countersLock.RLock()
 		defer s.countersLock.RUnlock()
-		counters := s.counters
+		counters := maps.Clone(s.counters[tenant.FromContext(ctx)])
+		if counters == nil {
+			counters = map[string]int64{}
+		}
 		return counters, nil
 	}
 }
@@ -113,13 +151,232 @@ func (s *MemStorage) WriteGaugesCounters(ctx context.Context, gauges map[string]
 	default:
 		s.gaugesLock.Lock()
 		defer s.gaugesLock.Unlock()
-		maps.Copy(s.gauges, gauges)
+		maps.Copy(s.tenantGauges(ctx), gauges)
 
 		s.countersLock.Lock()
 		defer s.countersLock.Unlock()
+		tc := s.tenantCounters(ctx)
 		for nm, v := range counters {
-			s.counters[nm] += v
+			tc[nm] += v
+		}
+		return nil
+	}
+}
+
+// SetGaugesCounters stores the values as they are, counters are not added to.
+func (s *MemStorage) SetGaugesCounters(ctx context.Context, gauges map[string]float64, counters map[string]int64) error {
+	select {
+	case <-ctx.Done():
+		return ErrCanceled
+	default:
+		s.gaugesLock.Lock()
+		defer s.gaugesLock.Unlock()
+		maps.Copy(s.tenantGauges(ctx), gauges)
+
+		s.countersLock.Lock()
+		defer s.countersLock.Unlock()
+		maps.Copy(s.tenantCounters(ctx), counters)
+		return nil
+	}
+}
+
+func (s *MemStorage) Tenants(ctx context.Context) ([]string, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ErrCanceled
+	default:
+		s.gaugesLock.RLock()
+		defer s.gaugesLock.RUnlock()
+		s.countersLock.RLock()
+		defer s.countersLock.RUnlock()
+		s.metasLock.RLock()
+		defer s.metasLock.RUnlock()
+		ids := slices.Collect(maps.Keys(s.gauges))
+		ids = slices.AppendSeq(ids, maps.Keys(s.counters))
+		ids = slices.AppendSeq(ids, maps.Keys(s.metas))
+		slices.Sort(ids)
+		return slices.Compact(ids), nil
+	}
+}
+
+func (s *MemStorage) CountSeries(ctx context.Context, gauges, counters []string) (total, missing int, err error) {
+	select {
+	case <-ctx.Done():
+		return 0, 0, ErrCanceled
+	default:
+		id := tenant.FromContext(ctx)
+		s.gaugesLock.RLock()
+		defer s.gaugesLock.RUnlock()
+		s.countersLock.RLock()
+		defer s.countersLock.RUnlock()
+		for _, nm := range gauges {
+			if _, ok := s.gauges[id][nm]; !ok {
+				missing++
+			}
+		}
+		for _, nm := range counters {
+			if _, ok := s.counters[id][nm]; !ok {
+				missing++
+			}
+		}
+		return len(s.gauges[id]) + len(s.counters[id]), missing, nil
+	}
+}
+
+func (s *MemStorage) TypeConflicts(ctx context.Context, gauges, counters []string) (map[string]string, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ErrCanceled
+	default:
+		id := tenant.FromContext(ctx)
+		s.gaugesLock.RLock()
+		defer s.gaugesLock.RUnlock()
+		s.countersLock.RLock()
+		defer s.countersLock.RUnlock()
+		s.metasLock.RLock()
+		defer s.metasLock.RUnlock()
+		conflicts := make(map[string]string)
+		for _, nm := range gauges {
+			if _, ok := s.counters[id][nm]; ok || s.metas[id][nm].Type == "counter" {
+				conflicts[nm] = "counter"
+			}
+		}
+		for _, nm := range counters {
+			if _, ok := s.gauges[id][nm]; ok || s.metas[id][nm].Type == "gauge" {
+				conflicts[nm] = "gauge"
+			}
+		}
+		return conflicts, nil
+	}
+}
+
+func (s *MemStorage) DeleteGauge(ctx context.Context, name string) error {
+	select {
+	case <-ctx.Done():
+		return ErrCanceled
+	default:
+		s.gaugesLock.Lock()
+		defer s.gaugesLock.Unlock()
+		gauges := s.gauges[tenant.FromContext(ctx)]
+		if _, ok := gauges[name]; !ok {
+			return fmt.Errorf("gauge %s: %w", name, models.ErrMetricNotFound)
+		}
+		delete(gauges, name)
+		return nil
+	}
+}
+
+func (s *MemStorage) DeleteCounter(ctx context.Context, name string) error {
+	select {
+	case <-ctx.Done():
+		return ErrCanceled
+	default:
+		s.countersLock.Lock()
+		defer s.countersLock.Unlock()
+		counters := s.counters[tenant.FromContext(ctx)]
+		if _, ok := counters[name]; !ok {
+			return fmt.Errorf("counter %s: %w", name, models.ErrMetricNotFound)
+		}
+		delete(counters, name)
+		return nil
+	}
+}
+
+func (s *MemStorage) ResetCounter(ctx context.Context, name string) error {
+	select {
+	case <-ctx.Done():
+		return ErrCanceled
+	default:
+		s.countersLock.Lock()
+		defer s.countersLock.Unlock()
+		counters := s.counters[tenant.FromContext(ctx)]
+		if _, ok := counters[name]; !ok {
+			return fmt.Errorf("counter %s: %w", name, models.ErrMetricNotFound)
+		}
+		counters[name] = 0
+		return nil
+	}
+}
+
+func (s *MemStorage) RenameGauge(ctx context.Context, from, to string) error {
+	select {
+	case <-ctx.Done():
+		return ErrCanceled
+	default:
+		s.gaugesLock.Lock()
+		defer s.gaugesLock.Unlock()
+		gauges := s.gauges[tenant.FromContext(ctx)]
+		v, ok := gauges[from]
+		if !ok {
+			return fmt.Errorf("gauge %s: %w", from, models.ErrMetricNotFound)
+		}
+		delete(gauges, from)
+		gauges[to] = v
+		return nil
+	}
+}
+
+func (s *MemStorage) RenameCounter(ctx context.Context, from, to string) error {
+	select {
+	case <-ctx.Done():
+		return ErrCanceled
+	default:
+		s.countersLock.Lock()
+		defer s.countersLock.Unlock()
+		counters := s.counters[tenant.FromContext(ctx)]
+		v, ok := counters[from]
+		if !ok {
+			return fmt.Errorf("counter %s: %w", from, models.ErrMetricNotFound)
+		}
+		delete(counters, from)
+		counters[to] += v
+		return nil
+	}
+}
+
+func (s *MemStorage) ReadMeta(ctx context.Context, name string) (*models.MetricMeta, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ErrCanceled
+	default:
+		s.metasLock.RLock()
+		defer s.metasLock.RUnlock()
+		if meta, ok := s.metas[tenant.FromContext(ctx)][name]; ok {
+			return &meta, nil
+		}
+		return nil, fmt.Errorf("metadata of %s: %w", name, models.ErrMetricNotFound)
+	}
+}
+
+func (s *MemStorage) ReadAllMeta(ctx context.Context) (map[string]*models.MetricMeta, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ErrCanceled
+	default:
+		s.metasLock.RLock()
+		defer s.metasLock.RUnlock()
+		metas := make(map[string]*models.MetricMeta, len(s.metas[tenant.FromContext(ctx)]))
+		for nm, meta := range s.metas[tenant.FromContext(ctx)] {
+			metas[nm] = &meta
+		}
+		return metas, nil
+	}
+}
+
+func (s *MemStorage) WriteMeta(ctx context.Context, meta *models.MetricMeta) error {
+	select {
+	case <-ctx.Done():
+		return ErrCanceled
+	default:
+		s.metasLock.Lock()
+		defer s.metasLock.Unlock()
+		id := tenant.FromContext(ctx)
+		metas, ok := s.metas[id]
+		if !ok {
+			metas = map[string]models.MetricMeta{}
+			s.metas[id] = metas
 		}
+		metas[meta.Name] = *meta
 		return nil
 	}
 }
@@ -0,0 +1,26 @@
+package mem
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+func TestAdminOnUnknownTenant(t *testing.T) {
+	s := NewMemStorage()
+	ctx := tenant.WithTenant(context.Background(), "ghost")
+
+	assert.ErrorIs(t, s.DeleteGauge(ctx, "g"), models.ErrMetricNotFound)
+	assert.ErrorIs(t, s.DeleteCounter(ctx, "c"), models.ErrMetricNotFound)
+	assert.ErrorIs(t, s.ResetCounter(ctx, "c"), models.ErrMetricNotFound)
+	assert.ErrorIs(t, s.RenameGauge(ctx, "g", "h"), models.ErrMetricNotFound)
+	assert.ErrorIs(t, s.RenameCounter(ctx, "c", "d"), models.ErrMetricNotFound)
+
+	ids, err := s.Tenants(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, ids, "failed admin changes must not create a tenant")
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS tokens
+(
+    token  VARCHAR(255) PRIMARY KEY,
+    scopes VARCHAR(255) NOT NULL,
+    prefix VARCHAR(255) NOT NULL DEFAULT ''
+);
@@ -0,0 +1,11 @@
+ALTER TABLE tokens DROP COLUMN IF EXISTS tenant;
+
+DELETE FROM counters WHERE tenant <> 'default';
+ALTER TABLE counters DROP CONSTRAINT IF EXISTS counters_tenant_name_key;
+ALTER TABLE counters ADD CONSTRAINT counters_name_key UNIQUE (name);
+ALTER TABLE counters DROP COLUMN IF EXISTS tenant;
+
+DELETE FROM gauges WHERE tenant <> 'default';
+ALTER TABLE gauges DROP CONSTRAINT IF EXISTS gauges_tenant_name_key;
+ALTER TABLE gauges ADD CONSTRAINT gauges_name_key UNIQUE (name);
+ALTER TABLE gauges DROP COLUMN IF EXISTS tenant;
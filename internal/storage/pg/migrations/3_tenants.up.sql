@@ -0,0 +1,9 @@
+ALTER TABLE gauges ADD COLUMN IF NOT EXISTS tenant VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE gauges DROP CONSTRAINT IF EXISTS gauges_name_key;
+ALTER TABLE gauges ADD CONSTRAINT gauges_tenant_name_key UNIQUE (tenant, name);
+
+ALTER TABLE counters ADD COLUMN IF NOT EXISTS tenant VARCHAR(63) NOT NULL DEFAULT 'default';
+ALTER TABLE counters DROP CONSTRAINT IF EXISTS counters_name_key;
+ALTER TABLE counters ADD CONSTRAINT counters_tenant_name_key UNIQUE (tenant, name);
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS tenant VARCHAR(63) NOT NULL DEFAULT '';
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS metadata
+(
+    id     SERIAL PRIMARY KEY,
+    tenant VARCHAR(63) NOT NULL DEFAULT 'default',
+    name   VARCHAR(255) NOT NULL,
+    type   VARCHAR(16) NOT NULL DEFAULT '',
+    unit   VARCHAR(64) NOT NULL DEFAULT '',
+    help   TEXT NOT NULL DEFAULT '',
+    owner  VARCHAR(255) NOT NULL DEFAULT '',
+    CONSTRAINT metadata_tenant_name_key UNIQUE (tenant, name)
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS samples
+(
+    tenant VARCHAR(63) NOT NULL,
+    name   VARCHAR(255) NOT NULL,
+    type   VARCHAR(16) NOT NULL,
+    value  DOUBLE PRECISION NOT NULL,
+    ts     TIMESTAMPTZ NOT NULL
+) PARTITION BY RANGE (ts);
+
+CREATE INDEX IF NOT EXISTS samples_tenant_name_ts_idx ON samples (tenant, name, ts);
@@ -0,0 +1,5 @@
+ALTER TABLE samples DROP CONSTRAINT IF EXISTS samples_value_check;
+UPDATE samples SET gauge_value = counter_value WHERE type = 'counter';
+ALTER TABLE samples DROP COLUMN IF EXISTS counter_value;
+ALTER TABLE samples ALTER COLUMN gauge_value SET NOT NULL;
+ALTER TABLE samples RENAME COLUMN gauge_value TO value;
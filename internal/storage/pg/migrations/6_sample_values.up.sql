@@ -0,0 +1,5 @@
+ALTER TABLE samples RENAME COLUMN value TO gauge_value;
+ALTER TABLE samples ALTER COLUMN gauge_value DROP NOT NULL;
+ALTER TABLE samples ADD COLUMN IF NOT EXISTS counter_value BIGINT;
+UPDATE samples SET counter_value = gauge_value::BIGINT, gauge_value = NULL WHERE type = 'counter';
+ALTER TABLE samples ADD CONSTRAINT samples_value_check CHECK (num_nonnulls(gauge_value, counter_value) = 1);
@@ -0,0 +1,7 @@
+// Package migrations holds the schema migrations, embedded so the binary runs from any directory.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
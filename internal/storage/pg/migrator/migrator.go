@@ -2,26 +2,129 @@ package migrator
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
+
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
-	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+	"github.com/volchkovski/go-practicum-metrics/internal/storage/pg/migrations"
 )
 
-const sourceURL = "file://internal/storage/pg/migrations"
+var ErrNewerSchema = errors.New("database schema is newer than this binary supports")
+
+// Migrator applies the migrations embedded in the binary.
+type Migrator struct {
+	m *migrate.Migrate
+}
 
-func Run(dsn string) error {
-	m, err := migrate.New(sourceURL, dsn)
+func New(dsn string) (*Migrator, error) {
+	src, err := iofs.New(migrations.FS, ".")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err = m.Up(); err != nil {
-		if errors.Is(err, migrate.ErrNoChange) {
-			logger.Log.Info("No migrations to apply")
-			return nil
+	m, err := migrate.NewWithSourceInstance("iofs", src, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{m: m}, nil
+}
+
+// Latest returns the version of the newest embedded migration.
+func Latest() (uint, error) {
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	version, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
 		}
+		version = next
+	}
+}
+
+// Up applies pending migrations, it is not an error when there are none.
+func (m *Migrator) Up() error {
+	if err := m.m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
 		return err
 	}
-	logger.Log.Info("All migrations applied successfully")
 	return nil
 }
+
+// Down rolls back the given number of applied migrations.
+func (m *Migrator) Down(steps int) error {
+	return m.m.Steps(-steps)
+}
+
+// Version returns the schema version, zero before the first migration.
+func (m *Migrator) Version() (uint, bool, error) {
+	version, dirty, err := m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+// Force sets the schema version without running migrations, to recover a dirty schema
+// after fixing it by hand.
+func (m *Migrator) Force(version int) error {
+	return m.m.Force(version)
+}
+
+func (m *Migrator) Close() error {
+	errSource, errDB := m.m.Close()
+	return errors.Join(errSource, errDB)
+}
+
+// Run applies pending migrations and returns the resulting schema version. A schema newer
+// than the embedded migrations fails with ErrNewerSchema unless allowNewer is set; then it
+// is left as it is.
+func Run(dsn string, allowNewer bool) (version uint, err error) {
+	m, err := New(dsn)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		err = errors.Join(err, m.Close())
+	}()
+
+	latest, err := Latest()
+	if err != nil {
+		return 0, err
+	}
+	current, _, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+	if current > latest {
+		if !allowNewer {
+			return 0, fmt.Errorf("%w: schema version %d, latest known %d", ErrNewerSchema, current, latest)
+		}
+		logger.Log.Warnf("Schema version %d is newer than the latest known %d, running without migrating", current, latest)
+		return current, nil
+	}
+
+	if err = m.Up(); err != nil {
+		return 0, err
+	}
+	if version, _, err = m.Version(); err != nil {
+		return 0, err
+	}
+	if version == current {
+		logger.Log.Info("No migrations to apply")
+	} else {
+		logger.Log.Info("All migrations applied successfully")
+	}
+	return version, nil
+}
@@ -0,0 +1,28 @@
+package migrator
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volchkovski/go-practicum-metrics/internal/storage/pg/migrations"
+)
+
+func TestLatest(t *testing.T) {
+	latest, err := Latest()
+	require.NoError(t, err)
+	assert.Equal(t, uint(6), latest)
+}
+
+func TestEmbeddedMigrationsPaired(t *testing.T) {
+	ups, err := fs.Glob(migrations.FS, "*.up.sql")
+	require.NoError(t, err)
+	require.NotEmpty(t, ups)
+	for _, up := range ups {
+		down := strings.TrimSuffix(up, ".up.sql") + ".down.sql"
+		_, err := fs.Stat(migrations.FS, down)
+		assert.NoError(t, err, "%s has no down migration", up)
+	}
+}
@@ -0,0 +1,115 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+)
+
+const (
+	partitionPrefix = "samples_"
+	partitionLayout = "20060102"
+	// partitionsAhead is the number of days after today partitions exist for, so that
+	// writes go on when maintenance is late.
+	partitionsAhead = 3
+)
+
+const createPartition = `CREATE TABLE IF NOT EXISTS %s PARTITION OF samples FOR VALUES FROM ('%s') TO ('%s')`
+
+// MaintainPartitions creates the daily partitions of samples for today and the days ahead
+// and drops the ones entirely older than the sample retention. Days are in UTC.
+func (pg *Pg) MaintainPartitions(ctx context.Context, now time.Time) error {
+	today := day(now)
+	for i := range partitionsAhead + 1 {
+		from := today.AddDate(0, 0, i)
+		to := from.AddDate(0, 0, 1)
+		query := fmt.Sprintf(createPartition, partitionName(from), from.Format(time.RFC3339), to.Format(time.RFC3339))
+		if _, err := pg.pool.Exec(ctx, query); err != nil {
+			return fmt.Errorf("failed to create partition %s: %w", partitionName(from), err)
+		}
+	}
+	if pg.sampleRetention <= 0 {
+		return nil
+	}
+
+	names, err := pg.samplePartitions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions: %w", err)
+	}
+	for _, nm := range expiredPartitions(names, now.Add(-pg.sampleRetention)) {
+		if _, err = pg.pool.Exec(ctx, "DROP TABLE IF EXISTS "+nm); err != nil {
+			return fmt.Errorf("failed to drop partition %s: %w", nm, err)
+		}
+		logger.Log.Infof("Dropped expired samples partition %s", nm)
+	}
+	return nil
+}
+
+// StartPartitionMaintenance maintains partitions every interval until ctx is done.
+func (pg *Pg) StartPartitionMaintenance(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := pg.MaintainPartitions(ctx, now); err != nil {
+					logger.Log.Errorf("Failed to maintain samples partitions: %s", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+func (pg *Pg) samplePartitions(ctx context.Context) (names []string, err error) {
+	rows, err := pg.pool.Query(ctx, q.SamplePartitions)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var nm string
+		if err = rows.Scan(&nm); err != nil {
+			return
+		}
+		names = append(names, nm)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func day(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func partitionName(day time.Time) string {
+	return partitionPrefix + day.Format(partitionLayout)
+}
+
+// expiredPartitions returns the partitions holding only samples before cutoff. Tables not
+// named by partitionName are left alone.
+func expiredPartitions(names []string, cutoff time.Time) []string {
+	var expired []string
+	for _, nm := range names {
+		suffix, ok := strings.CutPrefix(nm, partitionPrefix)
+		if !ok {
+			continue
+		}
+		from, err := time.Parse(partitionLayout, suffix)
+		if err != nil {
+			continue
+		}
+		if !from.AddDate(0, 0, 1).After(cutoff) {
+			expired = append(expired, nm)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,30 @@
+package pg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionName(t *testing.T) {
+	local := time.FixedZone("UTC+5", 5*60*60)
+	assert.Equal(t, "samples_20261018", partitionName(day(time.Date(2026, 10, 19, 2, 0, 0, 0, local))))
+	assert.Equal(t, "samples_20261019", partitionName(day(time.Date(2026, 10, 19, 23, 59, 0, 0, time.UTC))))
+}
+
+func TestExpiredPartitions(t *testing.T) {
+	names := []string{
+		"samples_20261016",
+		"samples_20261017",
+		"samples_20261018",
+		"samples_20261019",
+		"samples_archive",
+		"gauges",
+	}
+	cutoff := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"samples_20261016", "samples_20261017"}, expiredPartitions(names, cutoff))
+
+	cutoff = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"samples_20261016", "samples_20261017"}, expiredPartitions(names, cutoff))
+}
@@ -2,90 +2,187 @@ package pg
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
-	"github.com/volchkovski/go-practicum-metrics/internal/storage/pg/migrator"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
-)
-
-const (
-	MaxOpenConns = 5
-	MaxIdleConns
-	MaxLifetime = 5 * time.Minute
-	MaxIdleTime = 10 * time.Minute
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/volchkovski/go-practicum-metrics/internal/auth"
+	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+	"github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
+	"github.com/volchkovski/go-practicum-metrics/internal/storage/pg/migrator"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
 )
 
 type Pg struct {
-	db *sql.DB
+	pool            *pgxpool.Pool
+	schemaVersion   uint
+	retryDelays     []time.Duration
+	sampleRetention time.Duration
+	// sampleWarned is the time of the last warning about failed samples in unix nanoseconds.
+	sampleWarned atomic.Int64
 }
 
-func configureConnPool(db *sql.DB) {
-	db.SetMaxOpenConns(MaxOpenConns)
-	db.SetMaxIdleConns(MaxIdleConns)
-	db.SetConnMaxLifetime(MaxLifetime)
-	db.SetConnMaxIdleTime(MaxIdleTime)
+// sampleWarnInterval is the least time between warnings about failed samples, so that a
+// missing partition does not log one per write. storage.sample_failures counts them all.
+const sampleWarnInterval = time.Minute
+
+type options struct {
+	allowNewerSchema bool
+	pool             PoolConfig
+	sampleRetention  time.Duration
 }
 
-func New(dsn string) (*Pg, error) {
-	if err := migrator.Run(dsn); err != nil {
-		return nil, fmt.Errorf("migrations failed: %w", err)
+type Option func(*options)
+
+// WithNewerSchema runs on a schema migrated by a newer binary instead of failing, for
+// rolling back a deployment before its migrations are.
+func WithNewerSchema() Option {
+	return func(o *options) {
+		o.allowNewerSchema = true
+	}
+}
+
+func WithPool(cfg PoolConfig) Option {
+	return func(o *options) {
+		o.pool = cfg
+	}
+}
+
+// WithSampleRetention drops samples partitions once they are older than d, zero keeps them.
+func WithSampleRetention(d time.Duration) Option {
+	return func(o *options) {
+		o.sampleRetention = d
+	}
+}
+
+func New(dsn string, opts ...Option) (*Pg, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
 	}
-	db, err := sql.Open("pgx", dsn)
+	version, err := migrator.Run(dsn, o.allowNewerSchema)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("migrations failed: %w", err)
 	}
-	configureConnPool(db)
 	if err := loadQueries(); err != nil {
 		return nil, fmt.Errorf("failed to load queries: %w", err)
 	}
-	return &Pg{db}, nil
+	pool, err := newPool(context.Background(), dsn, o.pool)
+	if err != nil {
+		return nil, err
+	}
+	pg := &Pg{pool: pool, schemaVersion: version, retryDelays: retryDelays, sampleRetention: o.sampleRetention}
+	if err = pg.MaintainPartitions(context.Background(), time.Now()); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pg, nil
 }
 
 func (pg *Pg) Ping(ctx context.Context) error {
-	return pg.db.PingContext(ctx)
+	return pg.pool.Ping(ctx)
+}
+
+// CheckMigrations fails when the schema is dirty or no longer at the version applied on start.
+func (pg *Pg) CheckMigrations(ctx context.Context) error {
+	var (
+		version uint
+		dirty   bool
+	)
+	if err := pg.pool.QueryRow(ctx, q.MigrationVersion).Scan(&version, &dirty); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if dirty {
+		return fmt.Errorf("schema version %d is dirty", version)
+	}
+	if version != pg.schemaVersion {
+		return fmt.Errorf("schema version is %d, expected %d", version, pg.schemaVersion)
+	}
+	return nil
 }
 
 func (pg *Pg) Close() error {
-	return pg.db.Close()
+	pg.pool.Close()
+	return nil
 }
 
+// WriteGauge sets the gauge and appends the value to samples in one transaction.
 func (pg *Pg) WriteGauge(ctx context.Context, name string, value float64) error {
-	_, err := pg.db.ExecContext(ctx, q.InsertGauge, name, value)
-	return err
+	return pg.retryExec(ctx, func() error {
+		return pg.writeSampled(ctx, q.InsertGauge, q.InsertGaugeSamples, name, value)
+	})
 }
 
+// WriteCounter adds to the counter and appends the result to samples in one transaction.
 func (pg *Pg) WriteCounter(ctx context.Context, name string, value int64) error {
-	_, err := pg.db.ExecContext(ctx, q.InsertCounter, name, value)
-	return err
+	return pg.retryIncrement(ctx, func() error {
+		return pg.writeSampled(ctx, q.InsertCounter, q.InsertCounterSamples, name, value)
+	})
+}
+
+func (pg *Pg) writeSampled(ctx context.Context, query, sampleQuery string, name string, value any) (err error) {
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if errRB := tx.Rollback(ctx); errRB != nil {
+			err = errors.Join(err, errRB)
+		}
+	}()
+
+	id := tenant.FromContext(ctx)
+	if _, err = tx.Exec(ctx, query, id, name, value); err != nil {
+		return
+	}
+	if err = pg.insertSamples(ctx, tx, sampleQuery, id, []string{name}); err != nil {
+		return
+	}
+	err = tx.Commit(ctx)
+	return
 }
 
 func (pg *Pg) ReadGauge(ctx context.Context, name string) (float64, error) {
-	var val float64
-	err := pg.db.QueryRowContext(ctx, q.SelectGaugeValue, name).Scan(&val)
-	return val, err
+	return retry(ctx, pg, func() (float64, error) {
+		var val float64
+		err := pg.pool.QueryRow(ctx, q.SelectGaugeValue, tenant.FromContext(ctx), name).Scan(&val)
+		return val, err
+	})
 }
 
 func (pg *Pg) ReadCounter(ctx context.Context, name string) (int64, error) {
-	var val int64
-	err := pg.db.QueryRowContext(ctx, q.SelectCounterValue, name).Scan(&val)
-	return val, err
+	return retry(ctx, pg, func() (int64, error) {
+		var val int64
+		err := pg.pool.QueryRow(ctx, q.SelectCounterValue, tenant.FromContext(ctx), name).Scan(&val)
+		return val, err
+	})
 }
 
-func (pg *Pg) ReadAllGauges(ctx context.Context) (gauges map[string]float64, err error) {
-	var rows *sql.Rows
-	rows, err = pg.db.QueryContext(ctx, q.SelectGauges)
+func (pg *Pg) ReadAllGauges(ctx context.Context) (map[string]float64, error) {
+	return retry(ctx, pg, func() (map[string]float64, error) {
+		return pg.readAllGauges(ctx)
+	})
+}
+
+func (pg *Pg) readAllGauges(ctx context.Context) (gauges map[string]float64, err error) {
+	var rows pgx.Rows
+	rows, err = pg.pool.Query(ctx, q.SelectGauges, tenant.FromContext(ctx))
 	if err != nil {
 		return
 	}
 
-	defer func() {
-		if errClose := rows.Close(); errClose != nil {
-			err = errors.Join(err, errClose)
-		}
-	}()
+	defer rows.Close()
 
 	gauges = make(map[string]float64)
 	for rows.Next() {
@@ -103,18 +200,20 @@ func (pg *Pg) ReadAllGauges(ctx context.Context) (gauges map[string]float64, err
 	return
 }
 
-func (pg *Pg) ReadAllCounters(ctx context.Context) (counters map[string]int64, err error) {
-	var rows *sql.Rows
-	rows, err = pg.db.QueryContext(ctx, q.SelectCounters)
+func (pg *Pg) ReadAllCounters(ctx context.Context) (map[string]int64, error) {
+	return retry(ctx, pg, func() (map[string]int64, error) {
+		return pg.readAllCounters(ctx)
+	})
+}
+
+func (pg *Pg) readAllCounters(ctx context.Context) (counters map[string]int64, err error) {
+	var rows pgx.Rows
+	rows, err = pg.pool.Query(ctx, q.SelectCounters, tenant.FromContext(ctx))
 	if err != nil {
 		return
 	}
 
-	defer func() {
-		if errClose := rows.Close(); errClose != nil {
-			err = errors.Join(err, errClose)
-		}
-	}()
+	defer rows.Close()
 
 	counters = make(map[string]int64)
 	for rows.Next() {
@@ -132,8 +231,25 @@ func (pg *Pg) ReadAllCounters(ctx context.Context) (counters map[string]int64, e
 	return
 }
 
-func (pg *Pg) WriteGaugesCounters(ctx context.Context, gauges map[string]float64, counters map[string]int64) (err error) {
-	tx, err := pg.db.Begin()
+// WriteGaugesCounters sets gauges and adds counters in one transaction, with a single
+// statement per table whatever the size of the batch. The resulting values are appended
+// to samples in the same transaction.
+func (pg *Pg) WriteGaugesCounters(ctx context.Context, gauges map[string]float64, counters map[string]int64) error {
+	return pg.retryIncrement(ctx, func() error {
+		return pg.writeGaugesCounters(ctx, gauges, counters, q.UpsertCounters, true)
+	})
+}
+
+// SetGaugesCounters stores the values as they are, counters are not added to. Nothing is
+// appended to samples, the values were sampled where they come from.
+func (pg *Pg) SetGaugesCounters(ctx context.Context, gauges map[string]float64, counters map[string]int64) error {
+	return pg.retryExec(ctx, func() error {
+		return pg.writeGaugesCounters(ctx, gauges, counters, q.SetCounters, false)
+	})
+}
+
+func (pg *Pg) writeGaugesCounters(ctx context.Context, gauges map[string]float64, counters map[string]int64, counterQuery string, sample bool) (err error) {
+	tx, err := pg.pool.Begin(ctx)
 	if err != nil {
 		return
 	}
@@ -141,32 +257,283 @@ func (pg *Pg) WriteGaugesCounters(ctx context.Context, gauges map[string]float64
 		if err == nil {
 			return
 		}
-		if errRB := tx.Rollback(); errRB != nil {
+		if errRB := tx.Rollback(ctx); errRB != nil {
 			err = errors.Join(err, errRB)
 		}
 	}()
 
-	ggStmt, err := tx.PrepareContext(ctx, q.InsertGauge)
+	id := tenant.FromContext(ctx)
+	if len(gauges) > 0 {
+		names, values := columns(gauges)
+		if _, err = tx.Exec(ctx, q.UpsertGauges, id, names, values); err != nil {
+			return
+		}
+		if sample {
+			if err = pg.insertSamples(ctx, tx, q.InsertGaugeSamples, id, names); err != nil {
+				return
+			}
+		}
+	}
+	if len(counters) > 0 {
+		names, values := columns(counters)
+		if _, err = tx.Exec(ctx, counterQuery, id, names, values); err != nil {
+			return
+		}
+		if sample {
+			if err = pg.insertSamples(ctx, tx, q.InsertCounterSamples, id, names); err != nil {
+				return
+			}
+		}
+	}
+
+	err = tx.Commit(ctx)
+	return
+}
+
+// insertSamples appends samples of the named metrics under a savepoint. Samples only
+// record history, so when appending fails, e.g. there is no partition yet because
+// maintenance is late, the failure is counted and the write itself goes on.
+func (pg *Pg) insertSamples(ctx context.Context, tx pgx.Tx, query, id string, names []string) error {
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err = sp.Exec(ctx, query, id, names); err != nil {
+		selfmetrics.Default.Add("storage.sample_failures", 1)
+		if pg.sampleWarnDue(time.Now()) {
+			logger.Log.Warnf("Failed to record samples of tenant %s: %s; failures of the next %s are only counted",
+				id, err.Error(), sampleWarnInterval)
+		}
+		return sp.Rollback(ctx)
+	}
+	return sp.Commit(ctx)
+}
+
+// sampleWarnDue reports whether a sample failure at now is logged, which happens at most
+// once per sampleWarnInterval.
+func (pg *Pg) sampleWarnDue(now time.Time) bool {
+	last := pg.sampleWarned.Load()
+	if last != 0 && now.Sub(time.Unix(0, last)) < sampleWarnInterval {
+		return false
+	}
+	return pg.sampleWarned.CompareAndSwap(last, now.UnixNano())
+}
+
+// columns turns metrics into arrays for unnest. Names are sorted so that concurrent
+// batches lock shared rows in the same order and do not deadlock.
+func columns[V float64 | int64](metrics map[string]V) ([]string, []V) {
+	names := slices.Sorted(maps.Keys(metrics))
+	values := make([]V, len(names))
+	for i, nm := range names {
+		values[i] = metrics[nm]
+	}
+	return names, values
+}
+
+func (pg *Pg) LookupToken(ctx context.Context, token string) (*auth.Token, error) {
+	return retry(ctx, pg, func() (*auth.Token, error) {
+		return pg.lookupToken(ctx, token)
+	})
+}
+
+func (pg *Pg) lookupToken(ctx context.Context, token string) (*auth.Token, error) {
+	var scopes, prefix, tenantID string
+	err := pg.pool.QueryRow(ctx, q.SelectToken, token).Scan(&scopes, &prefix, &tenantID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, auth.ErrUnknownToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &auth.Token{Value: token, Prefix: prefix, Tenant: tenantID}
+	for _, s := range strings.Split(scopes, ",") {
+		t.Scopes = append(t.Scopes, auth.Scope(strings.TrimSpace(s)))
+	}
+	return t, nil
+}
+
+func (pg *Pg) Tenants(ctx context.Context) ([]string, error) {
+	return retry(ctx, pg, func() ([]string, error) {
+		return pg.tenants(ctx)
+	})
+}
+
+func (pg *Pg) tenants(ctx context.Context) (ids []string, err error) {
+	var rows pgx.Rows
+	rows, err = pg.pool.Query(ctx, q.SelectTenants)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (pg *Pg) CountSeries(ctx context.Context, gauges, counters []string) (total, missing int, err error) {
+	err = pg.retryExec(ctx, func() error {
+		return pg.pool.QueryRow(ctx, q.CountSeries, tenant.FromContext(ctx), gauges, counters).Scan(&total, &missing)
+	})
+	return
+}
+
+func (pg *Pg) TypeConflicts(ctx context.Context, gauges, counters []string) (map[string]string, error) {
+	return retry(ctx, pg, func() (map[string]string, error) {
+		return pg.typeConflicts(ctx, gauges, counters)
+	})
+}
+
+func (pg *Pg) typeConflicts(ctx context.Context, gauges, counters []string) (conflicts map[string]string, err error) {
+	var rows pgx.Rows
+	rows, err = pg.pool.Query(ctx, q.TypeConflicts, tenant.FromContext(ctx), gauges, counters)
+	if err != nil {
+		return
+	}
+
+	defer rows.Close()
+
+	conflicts = make(map[string]string)
+	for rows.Next() {
+		var nm, tp string
+		if err = rows.Scan(&nm, &tp); err != nil {
+			return
+		}
+		conflicts[nm] = tp
+	}
+	err = rows.Err()
+	return
+}
+
+func (pg *Pg) DeleteGauge(ctx context.Context, name string) error {
+	return pg.execExisting(ctx, q.DeleteGauge, name)
+}
+
+func (pg *Pg) DeleteCounter(ctx context.Context, name string) error {
+	return pg.execExisting(ctx, q.DeleteCounter, name)
+}
+
+func (pg *Pg) ResetCounter(ctx context.Context, name string) error {
+	return pg.execExisting(ctx, q.ResetCounter, name)
+}
+
+// execExisting runs a statement on a single metric of the request tenant
+// and reports models.ErrMetricNotFound when it did not touch any row.
+func (pg *Pg) execExisting(ctx context.Context, query string, name string) error {
+	tag, err := retry(ctx, pg, func() (pgconn.CommandTag, error) {
+		return pg.pool.Exec(ctx, query, tenant.FromContext(ctx), name)
+	})
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s: %w", name, models.ErrMetricNotFound)
+	}
+	return nil
+}
+
+func (pg *Pg) RenameGauge(ctx context.Context, from, to string) error {
+	return pg.retryExec(ctx, func() error {
+		return pg.rename(ctx, q.RenameGauge, q.DeleteGauge, from, to)
+	})
+}
+
+func (pg *Pg) RenameCounter(ctx context.Context, from, to string) error {
+	return pg.retryIncrement(ctx, func() error {
+		return pg.rename(ctx, q.RenameCounter, q.DeleteCounter, from, to)
+	})
+}
+
+func (pg *Pg) rename(ctx context.Context, mergeQuery, deleteQuery string, from, to string) (err error) {
+	tx, err := pg.pool.Begin(ctx)
 	if err != nil {
 		return
 	}
-	for nm, v := range gauges {
-		if _, err = ggStmt.Exec(nm, v); err != nil {
+	defer func() {
+		if err == nil {
 			return
 		}
+		if errRB := tx.Rollback(ctx); errRB != nil {
+			err = errors.Join(err, errRB)
+		}
+	}()
+
+	id := tenant.FromContext(ctx)
+	tag, err := tx.Exec(ctx, mergeQuery, id, from, to)
+	if err != nil {
+		return
 	}
+	if tag.RowsAffected() == 0 {
+		err = fmt.Errorf("%s: %w", from, models.ErrMetricNotFound)
+		return
+	}
+	if _, err = tx.Exec(ctx, deleteQuery, id, from); err != nil {
+		return
+	}
+
+	err = tx.Commit(ctx)
+	return
+}
 
-	cntStmt, err := tx.PrepareContext(ctx, q.InsertCounter)
+func (pg *Pg) ReadMeta(ctx context.Context, name string) (*models.MetricMeta, error) {
+	return retry(ctx, pg, func() (*models.MetricMeta, error) {
+		return pg.readMeta(ctx, name)
+	})
+}
+
+func (pg *Pg) readMeta(ctx context.Context, name string) (*models.MetricMeta, error) {
+	meta := &models.MetricMeta{Name: name}
+	err := pg.pool.QueryRow(ctx, q.SelectMetaValue, tenant.FromContext(ctx), name).
+		Scan(&meta.Type, &meta.Unit, &meta.Help, &meta.Owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("metadata of %s: %w", name, models.ErrMetricNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func (pg *Pg) ReadAllMeta(ctx context.Context) (map[string]*models.MetricMeta, error) {
+	return retry(ctx, pg, func() (map[string]*models.MetricMeta, error) {
+		return pg.readAllMeta(ctx)
+	})
+}
+
+func (pg *Pg) readAllMeta(ctx context.Context) (metas map[string]*models.MetricMeta, err error) {
+	var rows pgx.Rows
+	rows, err = pg.pool.Query(ctx, q.SelectMetas, tenant.FromContext(ctx))
 	if err != nil {
 		return
 	}
 
-	for nm, v := range counters {
-		if _, err = cntStmt.Exec(nm, v); err != nil {
+	defer rows.Close()
+
+	metas = make(map[string]*models.MetricMeta)
+	for rows.Next() {
+		meta := new(models.MetricMeta)
+		if err = rows.Scan(&meta.Name, &meta.Type, &meta.Unit, &meta.Help, &meta.Owner); err != nil {
 			return
 		}
+		metas[meta.Name] = meta
 	}
 
-	err = tx.Commit()
+	err = rows.Err()
 	return
 }
+
+func (pg *Pg) WriteMeta(ctx context.Context, meta *models.MetricMeta) error {
+	return pg.retryExec(ctx, func() error {
+		_, err := pg.pool.Exec(ctx, q.InsertMeta, tenant.FromContext(ctx),
+			meta.Name, meta.Type, meta.Unit, meta.Help, meta.Owner)
+		return err
+	})
+}
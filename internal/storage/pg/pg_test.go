@@ -0,0 +1,245 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+// testDSNEnv names the database the tests and benchmarks run against, they are skipped
+// without it. Tables of the bench tenant are cleared.
+const testDSNEnv = "TEST_DATABASE_DSN"
+
+const benchTenant = "bench"
+
+func newTestPg(tb testing.TB) *Pg {
+	tb.Helper()
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		tb.Skipf("%s is not set", testDSNEnv)
+	}
+	pg, err := New(dsn)
+	require.NoError(tb, err)
+	tb.Cleanup(func() {
+		for _, table := range []string{"gauges", "counters", "samples"} {
+			_, err := pg.pool.Exec(context.Background(), "DELETE FROM "+table+" WHERE tenant = $1", benchTenant)
+			assert.NoError(tb, err)
+		}
+		assert.NoError(tb, pg.Close())
+	})
+	return pg
+}
+
+func TestWriteGaugesCounters(t *testing.T) {
+	pg := newTestPg(t)
+	ctx := tenant.WithTenant(context.Background(), benchTenant)
+
+	require.NoError(t, pg.WriteGaugesCounters(ctx, map[string]float64{"a": 1, "b": 2}, map[string]int64{"c": 3}))
+	require.NoError(t, pg.WriteGaugesCounters(ctx, map[string]float64{"a": 5}, map[string]int64{"c": 4, "d": 1}))
+	gauges, err := pg.ReadAllGauges(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"a": 5, "b": 2}, gauges)
+	counters, err := pg.ReadAllCounters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"c": 7, "d": 1}, counters)
+	require.NoError(t, pg.WriteGauge(ctx, "b", 6))
+	require.NoError(t, pg.WriteCounter(ctx, "d", 2))
+
+	total, missing, err := pg.CountSeries(ctx, []string{"a", "x"}, []string{"c"})
+	require.NoError(t, err)
+	assert.Equal(t, 4, total)
+	assert.Equal(t, 1, missing)
+
+	conflicts, err := pg.TypeConflicts(ctx, []string{"c", "x"}, []string{"a", "d"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"c": "counter", "a": "gauge"}, conflicts)
+
+	require.NoError(t, pg.SetGaugesCounters(ctx, nil, map[string]int64{"c": 2}))
+	counters, err = pg.ReadAllCounters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"c": 2, "d": 3}, counters)
+
+	require.NoError(t, pg.WriteCounter(ctx, "big", 1<<53+1))
+	rows, err := pg.pool.Query(ctx, "SELECT name, gauge_value, counter_value FROM samples WHERE tenant = $1 ORDER BY ts, name", benchTenant)
+	require.NoError(t, err)
+	samples, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (string, error) {
+		var (
+			nm      string
+			gauge   *float64
+			counter *int64
+		)
+		if err := row.Scan(&nm, &gauge, &counter); err != nil {
+			return "", err
+		}
+		if gauge != nil {
+			return fmt.Sprintf("%s=%g", nm, *gauge), nil
+		}
+		return fmt.Sprintf("%s=%d", nm, *counter), nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a=1", "b=2", "c=3", "a=5", "c=7", "d=1", "b=6", "d=3", "big=9007199254740993"}, samples,
+		"counters keep their precision beyond 2^53")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.ErrorIs(t, pg.WriteGaugesCounters(canceled, map[string]float64{"a": 1}, nil), context.Canceled)
+}
+
+func TestMaintainPartitions(t *testing.T) {
+	pg := newTestPg(t)
+	ctx := context.Background()
+	// A century back, so that expiring them leaves partitions of the present alone.
+	past := time.Date(1926, 10, 19, 12, 0, 0, 0, time.UTC)
+	t.Cleanup(func() {
+		for i := range partitionsAhead + 1 {
+			_, err := pg.pool.Exec(ctx, "DROP TABLE IF EXISTS "+partitionName(day(past).AddDate(0, 0, i)))
+			assert.NoError(t, err)
+		}
+	})
+
+	require.NoError(t, pg.MaintainPartitions(ctx, past))
+	names, err := pg.samplePartitions(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, names, partitionName(day(past).AddDate(0, 0, partitionsAhead)))
+
+	now := time.Now()
+	pg.sampleRetention = now.Sub(past.AddDate(0, 0, 2))
+	require.NoError(t, pg.MaintainPartitions(ctx, now))
+	names, err = pg.samplePartitions(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, names, partitionName(day(past)))
+	assert.NotContains(t, names, partitionName(day(past).AddDate(0, 0, 1)))
+	assert.Contains(t, names, partitionName(day(past).AddDate(0, 0, 2)))
+	assert.Contains(t, names, partitionName(day(now)))
+}
+
+func TestSampleWarnDue(t *testing.T) {
+	var pg Pg
+	now := time.Now()
+	assert.True(t, pg.sampleWarnDue(now), "the first failure is logged")
+	assert.False(t, pg.sampleWarnDue(now.Add(time.Second)))
+	assert.False(t, pg.sampleWarnDue(now.Add(sampleWarnInterval-time.Millisecond)))
+	assert.True(t, pg.sampleWarnDue(now.Add(sampleWarnInterval)))
+}
+
+func benchBatch(n int) (map[string]float64, map[string]int64) {
+	gauges := make(map[string]float64, n/2)
+	counters := make(map[string]int64, n/2)
+	for i := range n / 2 {
+		gauges[fmt.Sprintf("gauge_%d", i)] = float64(i)
+		counters[fmt.Sprintf("counter_%d", i)] = int64(i)
+	}
+	return gauges, counters
+}
+
+// writeRows is the previous implementation: one prepared upsert per metric.
+func (pg *Pg) writeRows(ctx context.Context, gauges map[string]float64, counters map[string]int64) (err error) {
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, tx.Rollback(ctx))
+		}
+	}()
+	id := tenant.FromContext(ctx)
+	for nm, v := range gauges {
+		if _, err = tx.Exec(ctx, q.InsertGauge, id, nm, v); err != nil {
+			return
+		}
+	}
+	for nm, v := range counters {
+		if _, err = tx.Exec(ctx, q.InsertCounter, id, nm, v); err != nil {
+			return
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// writeCopy copies metrics into temporary tables and merges them with one statement each.
+func (pg *Pg) writeCopy(ctx context.Context, gauges map[string]float64, counters map[string]int64) (err error) {
+	tx, err := pg.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, tx.Rollback(ctx))
+		}
+	}()
+	id := tenant.FromContext(ctx)
+	tables := []struct {
+		name      string
+		valueType string
+		rows      [][]any
+		merge     string
+	}{
+		{"gauges", "double precision", gaugeRows(gauges), "value = EXCLUDED.value"},
+		{"counters", "bigint", counterRows(counters), "value = counters.value + EXCLUDED.value"},
+	}
+	for _, t := range tables {
+		tmp := "batch_" + t.name
+		if _, err = tx.Exec(ctx, fmt.Sprintf("CREATE TEMP TABLE %s (name text, value %s) ON COMMIT DROP", tmp, t.valueType)); err != nil {
+			return err
+		}
+		if _, err = tx.CopyFrom(ctx, pgx.Identifier{tmp}, []string{"name", "value"}, pgx.CopyFromRows(t.rows)); err != nil {
+			return err
+		}
+		merge := fmt.Sprintf("INSERT INTO %s (tenant, name, value) SELECT $1, name, value FROM %s ON CONFLICT (tenant, name) DO UPDATE SET %s", t.name, tmp, t.merge)
+		if _, err = tx.Exec(ctx, merge, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func gaugeRows(gauges map[string]float64) [][]any {
+	rows := make([][]any, 0, len(gauges))
+	for nm, v := range gauges {
+		rows = append(rows, []any{nm, v})
+	}
+	return rows
+}
+
+func counterRows(counters map[string]int64) [][]any {
+	rows := make([][]any, 0, len(counters))
+	for nm, v := range counters {
+		rows = append(rows, []any{nm, v})
+	}
+	return rows
+}
+
+func BenchmarkWriteGaugesCounters(b *testing.B) {
+	pg := newTestPg(b)
+	ctx := tenant.WithTenant(context.Background(), benchTenant)
+	impls := []struct {
+		name  string
+		write func(context.Context, map[string]float64, map[string]int64) error
+	}{
+		{"rows", pg.writeRows},
+		{"unnest", pg.WriteGaugesCounters},
+		{"copy", pg.writeCopy},
+	}
+	for _, n := range []int{10, 100, 1000, 10000} {
+		gauges, counters := benchBatch(n)
+		for _, impl := range impls {
+			b.Run(fmt.Sprintf("%s/%d", impl.name, n), func(b *testing.B) {
+				for range b.N {
+					if err := impl.write(ctx, gauges, counters); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(n*b.N)/b.Elapsed().Seconds(), "metrics/s")
+			})
+		}
+	}
+}
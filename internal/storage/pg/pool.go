@@ -0,0 +1,80 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
+)
+
+// Statement cache modes. Statements are prepared once per connection and reused, only
+// their result descriptions are cached, or nothing is cached, which works behind
+// connection poolers in transaction mode.
+const (
+	StatementCacheStatement = "statement"
+	StatementCacheDescribe  = "describe"
+	StatementCacheDisabled  = "disabled"
+)
+
+var execModes = map[string]pgx.QueryExecMode{
+	StatementCacheStatement: pgx.QueryExecModeCacheStatement,
+	StatementCacheDescribe:  pgx.QueryExecModeCacheDescribe,
+	StatementCacheDisabled:  pgx.QueryExecModeDescribeExec,
+}
+
+// PoolConfig tunes the connection pool, zero values keep the pgxpool defaults.
+type PoolConfig struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	StatementCache    string
+}
+
+func newPool(ctx context.Context, dsn string, pc PoolConfig) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if pc.MaxConns > 0 {
+		cfg.MaxConns = pc.MaxConns
+	}
+	if pc.MinConns > 0 {
+		cfg.MinConns = pc.MinConns
+	}
+	if pc.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = pc.MaxConnLifetime
+	}
+	if pc.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = pc.MaxConnIdleTime
+	}
+	if pc.HealthCheckPeriod > 0 {
+		cfg.HealthCheckPeriod = pc.HealthCheckPeriod
+	}
+	if pc.StatementCache != "" {
+		mode, ok := execModes[pc.StatementCache]
+		if !ok {
+			return nil, fmt.Errorf("unknown statement cache mode %q", pc.StatementCache)
+		}
+		cfg.ConnConfig.DefaultQueryExecMode = mode
+	}
+	return pgxpool.NewWithConfig(ctx, cfg)
+}
+
+// ReportPoolStats records the state of the connection pool, meant to be added as a
+// collector of the server metrics.
+func (pg *Pg) ReportPoolStats(r *selfmetrics.Registry) {
+	s := pg.pool.Stat()
+	r.Set("storage.pool.max_conns", float64(s.MaxConns()))
+	r.Set("storage.pool.total_conns", float64(s.TotalConns()))
+	r.Set("storage.pool.acquired_conns", float64(s.AcquiredConns()))
+	r.Set("storage.pool.idle_conns", float64(s.IdleConns()))
+	r.SetCounter("storage.pool.acquire.count", s.AcquireCount())
+	r.SetCounter("storage.pool.acquire.us_total", s.AcquireDuration().Microseconds())
+	r.SetCounter("storage.pool.acquire.waited", s.EmptyAcquireCount())
+	r.SetCounter("storage.pool.acquire.canceled", s.CanceledAcquireCount())
+}
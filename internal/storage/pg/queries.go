@@ -12,12 +12,31 @@ var (
 )
 
 type queries struct {
-	InsertGauge        string
-	InsertCounter      string
-	SelectGaugeValue   string
-	SelectCounterValue string
-	SelectGauges       string
-	SelectCounters     string
+	InsertGauge          string
+	InsertCounter        string
+	UpsertGauges         string
+	UpsertCounters       string
+	SetCounters          string
+	InsertGaugeSamples   string
+	InsertCounterSamples string
+	SamplePartitions     string
+	SelectGaugeValue     string
+	SelectCounterValue   string
+	SelectGauges         string
+	SelectCounters       string
+	SelectToken          string
+	SelectTenants        string
+	CountSeries          string
+	TypeConflicts        string
+	DeleteGauge          string
+	DeleteCounter        string
+	ResetCounter         string
+	RenameGauge          string
+	RenameCounter        string
+	SelectMetaValue      string
+	SelectMetas          string
+	InsertMeta           string
+	MigrationVersion     string
 }
 
 //go:embed queries/*.sql
@@ -26,44 +45,42 @@ var queryFS embed.FS
 func loadQueries() error {
 	var initErr error
 	once.Do(func() {
-		insertGaugeQ, err := loadQuery("insert_gauge")
-		if err != nil {
-			initErr = err
-			return
+		var loaded queries
+		files := map[string]*string{
+			"insert_gauge":           &loaded.InsertGauge,
+			"insert_counter":         &loaded.InsertCounter,
+			"upsert_gauges":          &loaded.UpsertGauges,
+			"upsert_counters":        &loaded.UpsertCounters,
+			"set_counters":           &loaded.SetCounters,
+			"insert_gauge_samples":   &loaded.InsertGaugeSamples,
+			"insert_counter_samples": &loaded.InsertCounterSamples,
+			"sample_partitions":      &loaded.SamplePartitions,
+			"gauge_value":            &loaded.SelectGaugeValue,
+			"counter_value":          &loaded.SelectCounterValue,
+			"gauges":                 &loaded.SelectGauges,
+			"counters":               &loaded.SelectCounters,
+			"token":                  &loaded.SelectToken,
+			"tenants":                &loaded.SelectTenants,
+			"count_series":           &loaded.CountSeries,
+			"type_conflicts":         &loaded.TypeConflicts,
+			"delete_gauge":           &loaded.DeleteGauge,
+			"delete_counter":         &loaded.DeleteCounter,
+			"reset_counter":          &loaded.ResetCounter,
+			"rename_gauge":           &loaded.RenameGauge,
+			"rename_counter":         &loaded.RenameCounter,
+			"meta_value":             &loaded.SelectMetaValue,
+			"metas":                  &loaded.SelectMetas,
+			"insert_meta":            &loaded.InsertMeta,
+			"migration_version":      &loaded.MigrationVersion,
 		}
-		insertCounterQ, err := loadQuery("insert_counter")
-		if err != nil {
-			initErr = err
-			return
-		}
-		selectGaugeValueQ, err := loadQuery("gauge_value")
-		if err != nil {
-			initErr = err
-			return
-		}
-		selectCounterValueQ, err := loadQuery("counter_value")
-		if err != nil {
-			initErr = err
-			return
-		}
-		selectGaugesQ, err := loadQuery("gauges")
-		if err != nil {
-			initErr = err
-			return
-		}
-		selectCountersQ, err := loadQuery("counters")
-		if err != nil {
-			initErr = err
-			return
-		}
-		q = queries{
-			InsertGauge:        insertGaugeQ,
-			InsertCounter:      insertCounterQ,
-			SelectGaugeValue:   selectGaugeValueQ,
-			SelectCounterValue: selectCounterValueQ,
-			SelectGauges:       selectGaugesQ,
-			SelectCounters:     selectCountersQ,
+		for filename, query := range files {
+			var err error
+			if *query, err = loadQuery(filename); err != nil {
+				initErr = err
+				return
+			}
 		}
+		q = loaded
 	})
 	if initErr != nil {
 		return fmt.Errorf("failed to load queries: %w", initErr)
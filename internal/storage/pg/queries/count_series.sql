@@ -0,0 +1,7 @@
+SELECT
+    (SELECT count(*) FROM gauges WHERE tenant = $1)
+        + (SELECT count(*) FROM counters WHERE tenant = $1),
+    (SELECT count(*) FROM unnest($2::text[]) AS batch (name)
+        WHERE NOT EXISTS (SELECT 1 FROM gauges WHERE tenant = $1 AND gauges.name = batch.name))
+        + (SELECT count(*) FROM unnest($3::text[]) AS batch (name)
+        WHERE NOT EXISTS (SELECT 1 FROM counters WHERE tenant = $1 AND counters.name = batch.name));
@@ -1 +1 @@
-SELECT value FROM counters WHERE name = $1;
\ No newline at end of file
+SELECT value FROM counters WHERE tenant = $1 AND name = $2;
\ No newline at end of file
@@ -1 +1 @@
-SELECT name, value FROM counters;
\ No newline at end of file
+SELECT name, value FROM counters WHERE tenant = $1;
\ No newline at end of file
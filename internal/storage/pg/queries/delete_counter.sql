@@ -0,0 +1 @@
+DELETE FROM counters WHERE tenant = $1 AND name = $2;
\ No newline at end of file
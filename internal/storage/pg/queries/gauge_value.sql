@@ -1 +1 @@
-SELECT value FROM gauges WHERE name = $1;
\ No newline at end of file
+SELECT value FROM gauges WHERE tenant = $1 AND name = $2;
\ No newline at end of file
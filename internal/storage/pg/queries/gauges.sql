@@ -1 +1 @@
-SELECT name, value FROM gauges;
\ No newline at end of file
+SELECT name, value FROM gauges WHERE tenant = $1;
\ No newline at end of file
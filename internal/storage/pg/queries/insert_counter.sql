@@ -1,4 +1,4 @@
-INSERT INTO counters (name, value)
-VALUES ($1, $2)
-ON CONFLICT (name)
+INSERT INTO counters (tenant, name, value)
+VALUES ($1, $2, $3)
+ON CONFLICT (tenant, name)
 DO UPDATE SET value = counters.value + EXCLUDED.value;
@@ -0,0 +1,4 @@
+INSERT INTO samples (tenant, name, type, counter_value, ts)
+SELECT tenant, name, 'counter', value, now()
+FROM counters
+WHERE tenant = $1 AND name = ANY($2::text[]);
@@ -1,4 +1,4 @@
-INSERT INTO gauges (name, value)
-VALUES ($1, $2)
-ON CONFLICT (name)
+INSERT INTO gauges (tenant, name, value)
+VALUES ($1, $2, $3)
+ON CONFLICT (tenant, name)
 DO UPDATE SET value = EXCLUDED.value;
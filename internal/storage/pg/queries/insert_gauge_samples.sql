@@ -0,0 +1,4 @@
+INSERT INTO samples (tenant, name, type, gauge_value, ts)
+SELECT tenant, name, 'gauge', value, now()
+FROM gauges
+WHERE tenant = $1 AND name = ANY($2::text[]);
@@ -0,0 +1,4 @@
+INSERT INTO metadata (tenant, name, type, unit, help, owner)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (tenant, name)
+DO UPDATE SET type = EXCLUDED.type, unit = EXCLUDED.unit, help = EXCLUDED.help, owner = EXCLUDED.owner;
@@ -0,0 +1 @@
+SELECT type, unit, help, owner FROM metadata WHERE tenant = $1 AND name = $2;
\ No newline at end of file
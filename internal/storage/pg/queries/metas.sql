@@ -0,0 +1 @@
+SELECT name, type, unit, help, owner FROM metadata WHERE tenant = $1;
\ No newline at end of file
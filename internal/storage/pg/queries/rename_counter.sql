@@ -0,0 +1,4 @@
+INSERT INTO counters (tenant, name, value)
+SELECT tenant, $3, value FROM counters WHERE tenant = $1 AND name = $2
+ON CONFLICT (tenant, name)
+DO UPDATE SET value = counters.value + EXCLUDED.value;
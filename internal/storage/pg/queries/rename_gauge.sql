@@ -0,0 +1,4 @@
+INSERT INTO gauges (tenant, name, value)
+SELECT tenant, $3, value FROM gauges WHERE tenant = $1 AND name = $2
+ON CONFLICT (tenant, name)
+DO UPDATE SET value = EXCLUDED.value;
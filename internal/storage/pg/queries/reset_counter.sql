@@ -0,0 +1 @@
+UPDATE counters SET value = 0 WHERE tenant = $1 AND name = $2;
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT tenant FROM gauges UNION SELECT tenant FROM counters UNION SELECT tenant FROM metadata ORDER BY tenant;
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT scopes, prefix, tenant FROM tokens WHERE token = $1;
\ No newline at end of file
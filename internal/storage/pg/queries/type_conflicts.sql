@@ -0,0 +1,7 @@
+SELECT name, 'counter'::text FROM counters WHERE tenant = $1 AND name = ANY($2)
+UNION
+SELECT name, type FROM metadata WHERE tenant = $1 AND type = 'counter' AND name = ANY($2)
+UNION
+SELECT name, 'gauge'::text FROM gauges WHERE tenant = $1 AND name = ANY($3)
+UNION
+SELECT name, type FROM metadata WHERE tenant = $1 AND type = 'gauge' AND name = ANY($3);
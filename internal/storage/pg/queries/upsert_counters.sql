@@ -0,0 +1,5 @@
+INSERT INTO counters (tenant, name, value)
+SELECT $1, name, value
+FROM unnest($2::text[], $3::bigint[]) AS batch (name, value)
+ON CONFLICT (tenant, name)
+DO UPDATE SET value = counters.value + EXCLUDED.value;
@@ -0,0 +1,5 @@
+INSERT INTO gauges (tenant, name, value)
+SELECT $1, name, value
+FROM unnest($2::text[], $3::double precision[]) AS batch (name, value)
+ON CONFLICT (tenant, name)
+DO UPDATE SET value = EXCLUDED.value;
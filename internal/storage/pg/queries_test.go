@@ -0,0 +1,17 @@
+package pg
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadQueries(t *testing.T) {
+	require.NoError(t, loadQueries())
+	v := reflect.ValueOf(q)
+	for i := range v.NumField() {
+		assert.NotEmpty(t, v.Field(i).String(), "query %s is not loaded", v.Type().Field(i).Name)
+	}
+}
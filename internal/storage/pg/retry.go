@@ -0,0 +1,99 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/volchkovski/go-practicum-metrics/internal/logger"
+	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
+)
+
+// Postgres error codes of transient failures.
+const (
+	connectionExceptionClass = "08"
+	serializationFailure     = "40001"
+	deadlockDetected         = "40P01"
+)
+
+// retryDelays are the waits before each retry of an operation failed with a transient error.
+var retryDelays = []time.Duration{time.Second, 3 * time.Second, 5 * time.Second}
+
+// retriable reports whether err is transient, so that running an idempotent operation
+// again is safe: connection exceptions reported by the server and the errors of notApplied.
+// Other errors, e.g. constraint violations, fail right away.
+func retriable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, connectionExceptionClass) {
+		return true
+	}
+	return notApplied(err)
+}
+
+// notApplied reports whether err is transient and the operation surely had no effect:
+// serialization failures and deadlocks, which roll the transaction back, and connection
+// failures before anything was sent. A connection lost later leaves the outcome unknown.
+func notApplied(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == serializationFailure || pgErr.Code == deadlockDetected
+	}
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &connectErr) || pgconn.SafeToRetry(err)
+}
+
+// retry runs op again after each of the retry delays while it fails with a transient
+// error. Waiting stops when ctx is done, the last error is returned with the cause. For
+// contexts from models.WithoutRetries op runs once and a transient error is wrapped in
+// models.ErrTransient instead.
+func retry[T any](ctx context.Context, pg *Pg, op func() (T, error)) (T, error) {
+	return retryIf(ctx, pg, retriable, op)
+}
+
+func retryIf[T any](ctx context.Context, pg *Pg, transient func(error) bool, op func() (T, error)) (T, error) {
+	v, err := op()
+	if m.RetriesDisabled(ctx) {
+		if err != nil && transient(err) {
+			err = fmt.Errorf("%w: %w", m.ErrTransient, err)
+		}
+		return v, err
+	}
+	for _, d := range pg.retryDelays {
+		if err == nil || !transient(err) {
+			return v, err
+		}
+		logger.Log.Warnf("Postgres operation failed, retrying in %s: %s", d, err.Error())
+		selfmetrics.Default.Add("storage.retries", 1)
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return v, errors.Join(err, context.Cause(ctx))
+		case <-timer.C:
+		}
+		v, err = op()
+	}
+	return v, err
+}
+
+// retryExec is retry for operations without a result.
+func (pg *Pg) retryExec(ctx context.Context, op func() error) error {
+	_, err := retry(ctx, pg, func() (struct{}, error) {
+		return struct{}{}, op()
+	})
+	return err
+}
+
+// retryIncrement is retryExec for operations that must not run twice, like adding to
+// counters: they are retried only when the failed attempt was not applied.
+func (pg *Pg) retryIncrement(ctx context.Context, op func() error) error {
+	_, err := retryIf(ctx, pg, notApplied, func() (struct{}, error) {
+		return struct{}{}, op()
+	})
+	return err
+}
@@ -0,0 +1,145 @@
+package pg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	m "github.com/volchkovski/go-practicum-metrics/internal/models"
+)
+
+func TestRetriable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"deadlock", &pgconn.PgError{Code: "40P01"}, true},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"wrapped", fmt.Errorf("write: %w", &pgconn.PgError{Code: "40001"}), true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"plain", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, retriable(tt.err))
+		})
+	}
+}
+
+func TestNotApplied(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"deadlock", &pgconn.PgError{Code: "40P01"}, true},
+		{"connect failure", &pgconn.ConnectError{}, true},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, false},
+		{"plain", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, notApplied(tt.err))
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	pg := &Pg{retryDelays: []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}}
+	transient := &pgconn.PgError{Code: "40P01"}
+
+	t.Run("succeeds after transient errors", func(t *testing.T) {
+		calls := 0
+		v, err := retry(context.Background(), pg, func() (int, error) {
+			calls++
+			if calls < 3 {
+				return 0, transient
+			}
+			return 42, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 42, v)
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("gives up after the last delay", func(t *testing.T) {
+		calls := 0
+		err := pg.retryExec(context.Background(), func() error {
+			calls++
+			return transient
+		})
+		assert.ErrorIs(t, err, transient)
+		assert.Equal(t, 4, calls)
+	})
+
+	t.Run("fails right away on permanent errors", func(t *testing.T) {
+		calls := 0
+		permanent := &pgconn.PgError{Code: "23505"}
+		err := pg.retryExec(context.Background(), func() error {
+			calls++
+			return permanent
+		})
+		assert.ErrorIs(t, err, permanent)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("increments are not retried when their outcome is unknown", func(t *testing.T) {
+		calls := 0
+		lost := &pgconn.PgError{Code: "08006"}
+		err := pg.retryIncrement(context.Background(), func() error {
+			calls++
+			return lost
+		})
+		assert.ErrorIs(t, err, lost)
+		assert.Equal(t, 1, calls)
+
+		calls = 0
+		err = pg.retryIncrement(context.Background(), func() error {
+			calls++
+			if calls < 2 {
+				return transient
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("leaves retries to the caller when asked to", func(t *testing.T) {
+		calls := 0
+		err := pg.retryExec(m.WithoutRetries(context.Background()), func() error {
+			calls++
+			return transient
+		})
+		assert.ErrorIs(t, err, m.ErrTransient)
+		assert.ErrorIs(t, err, transient)
+		assert.Equal(t, 1, calls)
+
+		lost := &pgconn.PgError{Code: "08006"}
+		err = pg.retryIncrement(m.WithoutRetries(context.Background()), func() error {
+			return lost
+		})
+		assert.NotErrorIs(t, err, m.ErrTransient)
+	})
+
+	t.Run("stops waiting when the context is done", func(t *testing.T) {
+		slow := &Pg{retryDelays: []time.Duration{time.Hour}}
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := slow.retryExec(ctx, func() error {
+			calls++
+			cancel()
+			return transient
+		})
+		assert.ErrorIs(t, err, transient)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, calls)
+	})
+}
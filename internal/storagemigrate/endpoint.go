@@ -0,0 +1,79 @@
+package storagemigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/backup"
+	"github.com/volchkovski/go-practicum-metrics/internal/services"
+	"github.com/volchkovski/go-practicum-metrics/internal/storage/mem"
+	"github.com/volchkovski/go-practicum-metrics/internal/storage/pg"
+)
+
+// Storage is what metrics are copied from and to.
+type Storage interface {
+	services.AllMetricsReader
+	services.GaugesCountersSetter
+	services.TenantsLister
+}
+
+// Endpoint is an opened source or destination. Close persists what was written.
+type Endpoint struct {
+	Storage
+	close func() error
+}
+
+func (e *Endpoint) Close() error {
+	return e.close()
+}
+
+func isDSN(spec string) bool {
+	return strings.HasPrefix(spec, "postgres://") || strings.HasPrefix(spec, "postgresql://")
+}
+
+// Open opens a postgres DSN or a backup file. A backup file is restored into memory
+// like the server does on start, and written back as a new snapshot on Close with the
+// given compression; a missing file opens empty.
+func Open(spec, compression string) (*Endpoint, error) {
+	if isDSN(spec) {
+		strg, err := pg.New(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open postgres: %w", err)
+		}
+		return &Endpoint{Storage: strg, close: strg.Close}, nil
+	}
+
+	fp := strings.TrimPrefix(spec, "file:")
+	strg := mem.NewMemStorage()
+	b := backup.NewMetricsBackup(services.NewMetricService(strg), fp, 0, backup.WithCompression(compression))
+	if err := b.Restore(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read backup %s: %w", fp, err)
+	}
+	return &Endpoint{
+		Storage: strg,
+		close: func() error {
+			return errors.Join(b.Dump(), strg.Close())
+		},
+	}, nil
+}
+
+// openReadOnly opens an endpoint whose Close does not write anything back.
+func openReadOnly(spec string) (*Endpoint, error) {
+	e, err := Open(spec, backup.CompressionNone)
+	if err != nil || isDSN(spec) {
+		return e, err
+	}
+	e.close = func() error { return nil }
+	return e, nil
+}
+
+func tenants(ctx context.Context, s Storage) ([]string, error) {
+	ids, err := s.Tenants(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,241 @@
+package storagemigrate
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/configs"
+	"github.com/volchkovski/go-practicum-metrics/internal/handlers"
+	"github.com/volchkovski/go-practicum-metrics/internal/selfmetrics"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+var ErrStateMismatch = errors.New("state file belongs to another migration")
+
+// key orders copied metrics: by tenant, then type, then name.
+type key struct {
+	Tenant string `json:"tenant"`
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+}
+
+func (k key) compare(o key) int {
+	return cmp.Or(cmp.Compare(k.Tenant, o.Tenant), cmp.Compare(k.Type, o.Type), cmp.Compare(k.Name, o.Name))
+}
+
+// state is the progress of a migration: every metric up to Last is copied.
+type state struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Last *key   `json:"last,omitempty"`
+}
+
+type Options struct {
+	BatchSize int
+	// StateFile records progress after every batch, it is removed once the copy is done.
+	StateFile string
+	// Resume skips metrics the state file records as copied.
+	Resume   bool
+	From, To string
+}
+
+type Stats struct {
+	Tenants  int
+	Gauges   int
+	Counters int
+	Skipped  int
+}
+
+type item struct {
+	key
+	gauge   float64
+	counter int64
+}
+
+// Copy copies gauges and counters of all tenants from src to dst as absolute values, in
+// batches of one transaction each. Server metrics are skipped, as in backups. Metrics
+// written to src during the copy may be missed, stop writers for an exact copy.
+func Copy(ctx context.Context, src, dst Storage, opts Options) (Stats, error) {
+	var stats Stats
+	st := state{From: opts.From, To: opts.To}
+	if opts.Resume {
+		loaded, err := loadState(opts.StateFile)
+		if err != nil {
+			return stats, err
+		}
+		if loaded != nil {
+			if loaded.From != st.From || loaded.To != st.To {
+				return stats, ErrStateMismatch
+			}
+			st = *loaded
+		}
+	}
+
+	ids, err := tenants(ctx, src)
+	if err != nil {
+		return stats, err
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		items, err := readItems(tenant.WithTenant(ctx, id), src, id)
+		if err != nil {
+			return stats, err
+		}
+		stats.Tenants++
+		if st.Last != nil {
+			n := len(items)
+			items = slices.DeleteFunc(items, func(it item) bool {
+				return it.compare(*st.Last) <= 0
+			})
+			stats.Skipped += n - len(items)
+		}
+
+		for start := 0; start < len(items); start += opts.BatchSize {
+			if err = ctx.Err(); err != nil {
+				return stats, err
+			}
+			batch := items[start:min(start+opts.BatchSize, len(items))]
+			gauges, counters := make(map[string]float64), make(map[string]int64)
+			for _, it := range batch {
+				if it.Type == string(handlers.GaugeType) {
+					gauges[it.Name] = it.gauge
+				} else {
+					counters[it.Name] = it.counter
+				}
+			}
+			if err = dst.SetGaugesCounters(tenant.WithTenant(ctx, id), gauges, counters); err != nil {
+				return stats, fmt.Errorf("failed to write tenant %s: %w", id, err)
+			}
+			stats.Gauges += len(gauges)
+			stats.Counters += len(counters)
+
+			last := batch[len(batch)-1].key
+			st.Last = &last
+			if opts.StateFile != "" {
+				if err = saveState(opts.StateFile, st); err != nil {
+					return stats, err
+				}
+			}
+		}
+	}
+
+	if opts.StateFile != "" {
+		if err = os.Remove(opts.StateFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// readItems returns the metrics of a tenant in copy order, without server metrics.
+func readItems(ctx context.Context, s Storage, id string) ([]item, error) {
+	gauges, err := s.ReadAllGauges(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant %s: %w", id, err)
+	}
+	counters, err := s.ReadAllCounters(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant %s: %w", id, err)
+	}
+
+	items := make([]item, 0, len(gauges)+len(counters))
+	for nm, v := range gauges {
+		if !selfmetrics.Reserved(nm) {
+			items = append(items, item{key: key{id, string(handlers.GaugeType), nm}, gauge: v})
+		}
+	}
+	for nm, v := range counters {
+		if !selfmetrics.Reserved(nm) {
+			items = append(items, item{key: key{id, string(handlers.CounterType), nm}, counter: v})
+		}
+	}
+	slices.SortFunc(items, func(a, b item) int {
+		return a.compare(b.key)
+	})
+	return items, nil
+}
+
+// loadState returns nil when there is no state file.
+func loadState(fp string) (*state, error) {
+	data, err := os.ReadFile(fp)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	st := new(state)
+	if err = json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("state file %s: %w", fp, err)
+	}
+	return st, nil
+}
+
+// saveState replaces the state file atomically, an interrupted save keeps the previous one.
+func saveState(fp string, st state) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fp), ".state-*")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		return errors.Join(err, tmp.Close(), os.Remove(tmp.Name()))
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Join(err, os.Remove(tmp.Name()))
+	}
+	return os.Rename(tmp.Name(), fp)
+}
+
+// Run copies metrics between the endpoints of cfg, verifies the result and prints a report.
+func Run(ctx context.Context, cfg *configs.StorageMigrateConfig, out io.Writer) (err error) {
+	src, err := openReadOnly(cfg.From)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, src.Close())
+	}()
+
+	dst, err := Open(cfg.To, cfg.Compression)
+	if err != nil {
+		return err
+	}
+	stats, err := Copy(ctx, src, dst, Options{
+		BatchSize: cfg.BatchSize,
+		StateFile: cfg.StateFile,
+		Resume:    cfg.Resume,
+		From:      cfg.From,
+		To:        cfg.To,
+	})
+	// The destination is closed either way: a backup file keeps the batches copied so far
+	// for a resumed run.
+	if err = errors.Join(err, dst.Close()); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Copied %d gauges and %d counters of %d tenants, %d skipped as copied before\n",
+		stats.Gauges, stats.Counters, stats.Tenants, stats.Skipped)
+
+	check, err := openReadOnly(cfg.To)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, check.Close())
+	}()
+	reports, err := Verify(ctx, src, check)
+	if reports != nil {
+		err = errors.Join(err, printReports(out, reports))
+	}
+	return err
+}
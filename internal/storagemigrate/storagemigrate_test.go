@@ -0,0 +1,122 @@
+package storagemigrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/volchkovski/go-practicum-metrics/internal/configs"
+	"github.com/volchkovski/go-practicum-metrics/internal/storage/mem"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+func fill(t *testing.T, s Storage) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, s.SetGaugesCounters(ctx,
+		map[string]float64{"Alloc": 1.5, "HeapInuse": 2, "_server.uptime": 3},
+		map[string]int64{"PollCount": 7},
+	))
+	require.NoError(t, s.SetGaugesCounters(tenant.WithTenant(ctx, "team"),
+		map[string]float64{"Alloc": 0.25},
+		map[string]int64{"Requests": 100, "Errors": 2},
+	))
+}
+
+func TestRunFileToFile(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from", "metrics.json"), filepath.Join(dir, "to", "metrics.json")
+	require.NoError(t, os.MkdirAll(filepath.Dir(from), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Dir(to), 0o755))
+
+	src, err := Open(from, "gzip")
+	require.NoError(t, err)
+	fill(t, src)
+	require.NoError(t, src.Close())
+
+	cfg := &configs.StorageMigrateConfig{
+		From:        from,
+		To:          to,
+		BatchSize:   2,
+		StateFile:   filepath.Join(dir, "state.json"),
+		Compression: "zstd",
+	}
+	var out bytes.Buffer
+	require.NoError(t, Run(context.Background(), cfg, &out))
+	assert.Contains(t, out.String(), "Copied 3 gauges and 3 counters of 2 tenants, 0 skipped")
+	assert.Contains(t, out.String(), "default  2       1")
+	assert.Contains(t, out.String(), "team     1       2")
+	assert.NoFileExists(t, cfg.StateFile)
+
+	dst, err := openReadOnly(to)
+	require.NoError(t, err)
+	counters, err := dst.ReadAllCounters(tenant.WithTenant(context.Background(), "team"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"Requests": 100, "Errors": 2}, counters)
+}
+
+// failingStorage fails writes after the given number of batches.
+type failingStorage struct {
+	*mem.MemStorage
+	batches int
+}
+
+func (s *failingStorage) SetGaugesCounters(ctx context.Context, gauges map[string]float64, counters map[string]int64) error {
+	if s.batches == 0 {
+		return errors.New("connection lost")
+	}
+	s.batches--
+	return s.MemStorage.SetGaugesCounters(ctx, gauges, counters)
+}
+
+func TestCopyResume(t *testing.T) {
+	ctx := context.Background()
+	src := mem.NewMemStorage()
+	fill(t, src)
+	dst := &failingStorage{MemStorage: mem.NewMemStorage(), batches: 2}
+	opts := Options{
+		BatchSize: 2,
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+		From:      "a",
+		To:        "b",
+	}
+
+	stats, err := Copy(ctx, src, dst, opts)
+	require.ErrorContains(t, err, "connection lost")
+	assert.Equal(t, 3, stats.Gauges+stats.Counters)
+	assert.FileExists(t, opts.StateFile)
+
+	_, err = Copy(ctx, src, dst, Options{BatchSize: 2, StateFile: opts.StateFile, Resume: true, From: "a", To: "c"})
+	assert.ErrorIs(t, err, ErrStateMismatch)
+
+	dst.batches = -1
+	opts.Resume = true
+	stats, err = Copy(ctx, src, dst, opts)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Tenants: 2, Gauges: 1, Counters: 2, Skipped: 3}, stats)
+	assert.NoFileExists(t, opts.StateFile)
+
+	reports, err := Verify(ctx, src, dst)
+	require.NoError(t, err)
+	assert.Len(t, reports, 2)
+}
+
+func TestVerifyMismatch(t *testing.T) {
+	ctx := context.Background()
+	src, dst := mem.NewMemStorage(), mem.NewMemStorage()
+	fill(t, src)
+	fill(t, dst)
+	require.NoError(t, dst.SetGaugesCounters(tenant.WithTenant(ctx, "team"), map[string]float64{"Alloc": 0.5}, nil))
+
+	reports, err := Verify(ctx, src, dst)
+	require.ErrorIs(t, err, ErrVerification)
+	require.Len(t, reports, 2)
+	assert.True(t, reports[0].OK())
+	assert.False(t, reports[1].OK())
+	assert.Equal(t, reports[1].Source.Gauges, reports[1].Destination.Gauges)
+}
@@ -0,0 +1,108 @@
+package storagemigrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/volchkovski/go-practicum-metrics/internal/handlers"
+	"github.com/volchkovski/go-practicum-metrics/internal/tenant"
+)
+
+var ErrVerification = errors.New("destination does not match the source")
+
+// Summary describes the metrics of a tenant: counts and a checksum over sorted names,
+// types and values.
+type Summary struct {
+	Gauges   int
+	Counters int
+	Checksum string
+}
+
+// Report compares a tenant in the source and the destination.
+type Report struct {
+	Tenant      string
+	Source      Summary
+	Destination Summary
+}
+
+func (r Report) OK() bool {
+	return r.Source == r.Destination
+}
+
+// Verify summarizes every tenant of src and dst, server metrics are left out.
+// It fails with ErrVerification when any tenant differs.
+func Verify(ctx context.Context, src, dst Storage) ([]Report, error) {
+	srcIDs, err := tenants(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	dstIDs, err := tenants(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	ids := append(srcIDs, dstIDs...)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+
+	reports := make([]Report, 0, len(ids))
+	var mismatch bool
+	for _, id := range ids {
+		r := Report{Tenant: id}
+		if r.Source, err = summarize(ctx, src, id); err != nil {
+			return nil, err
+		}
+		if r.Destination, err = summarize(ctx, dst, id); err != nil {
+			return nil, err
+		}
+		mismatch = mismatch || !r.OK()
+		reports = append(reports, r)
+	}
+	if mismatch {
+		return reports, ErrVerification
+	}
+	return reports, nil
+}
+
+func summarize(ctx context.Context, s Storage, id string) (Summary, error) {
+	var sum Summary
+	items, err := readItems(tenant.WithTenant(ctx, id), s, id)
+	if err != nil {
+		return sum, err
+	}
+	h := sha256.New()
+	for _, it := range items {
+		value := strconv.FormatInt(it.counter, 10)
+		if it.Type == string(handlers.GaugeType) {
+			sum.Gauges++
+			value = strconv.FormatFloat(it.gauge, 'g', -1, 64)
+		} else {
+			sum.Counters++
+		}
+		fmt.Fprintf(h, "%s\t%s\t%s\n", it.Type, it.Name, value)
+	}
+	sum.Checksum = hex.EncodeToString(h.Sum(nil))
+	return sum, nil
+}
+
+// printReports writes reports as a table. Reports of a failed verification are printed
+// too, the error follows from Run.
+func printReports(out io.Writer, reports []Report) error {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TENANT\tGAUGES\tCOUNTERS\tCHECKSUM\tSTATUS")
+	for _, r := range reports {
+		status := "ok"
+		if !r.OK() {
+			status = fmt.Sprintf("mismatch: destination has %d gauges, %d counters, checksum %.12s",
+				r.Destination.Gauges, r.Destination.Counters, r.Destination.Checksum)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.12s\t%s\n", r.Tenant, r.Source.Gauges, r.Source.Counters, r.Source.Checksum, status)
+	}
+	return tw.Flush()
+}
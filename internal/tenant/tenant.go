@@ -0,0 +1,34 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"regexp"
+)
+
+const (
+	Default = "default"
+	Header  = "X-Tenant"
+)
+
+var ErrQuotaExceeded = errors.New("tenant series quota exceeded")
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,63}$`)
+
+func Valid(id string) bool {
+	return validID.MatchString(id)
+}
+
+type ctxKey struct{}
+
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the tenant of the request, falling back to the default tenant.
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(ctxKey{}).(string); ok && id != "" {
+		return id
+	}
+	return Default
+}
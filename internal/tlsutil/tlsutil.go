@@ -0,0 +1,107 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// Reloader holds a certificate pair and an optional CA bundle that can be re-read from disk
+// while connections keep using the previously loaded ones.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	cert     atomic.Pointer[tls.Certificate]
+	pool     atomic.Pointer[x509.CertPool]
+}
+
+// NewReloader loads the files. Any of them may be empty, but cert and key go together.
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("certificate and key files must be set together")
+	}
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the files. On error the previously loaded certificates stay in use.
+func (r *Reloader) Reload() error {
+	var (
+		cert *tls.Certificate
+		pool *x509.CertPool
+	)
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate: %w", err)
+		}
+		cert = &c
+	}
+	if r.caFile != "" {
+		pem, err := os.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA bundle %s", r.caFile)
+		}
+	}
+	r.cert.Store(cert)
+	r.pool.Store(pool)
+	return nil
+}
+
+// ServerConfig returns a config that serves the current certificate and, when a CA bundle
+// is set, requires client certificates signed by it.
+func (r *Reloader) ServerConfig() *tls.Config {
+	getCert := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert := r.cert.Load()
+		if cert == nil {
+			return nil, errors.New("no server certificate loaded")
+		}
+		return cert, nil
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCert,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: getCert,
+			}
+			if pool := r.pool.Load(); pool != nil {
+				cfg.ClientCAs = pool
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig returns a config that trusts only the CA bundle when it is set and presents
+// the current client certificate when one is loaded. The CA bundle is read once: the
+// transport keeps the pool it was created with.
+func (r *Reloader) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    r.pool.Load(),
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := r.cert.Load(); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	}
+}
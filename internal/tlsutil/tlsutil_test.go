@@ -0,0 +1,135 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) writeCA(t *testing.T, fp string) {
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	require.NoError(t, os.WriteFile(fp, data, 0600))
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage, certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+func TestMutualTLSAndReload(t *testing.T) {
+	dir := t.TempDir()
+	fp := func(nm string) string { return filepath.Join(dir, nm) }
+
+	ca := newTestCA(t)
+	ca.writeCA(t, fp("ca.pem"))
+	ca.issue(t, 10, x509.ExtKeyUsageServerAuth, fp("server.pem"), fp("server.key"))
+	ca.issue(t, 20, x509.ExtKeyUsageClientAuth, fp("client.pem"), fp("client.key"))
+
+	serverCerts, err := NewReloader(fp("server.pem"), fp("server.key"), fp("ca.pem"))
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = serverCerts.ServerConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	serverSerial := func(cfg *tls.Config) (int64, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			return 0, err
+		}
+		defer func() {
+			require.NoError(t, resp.Body.Close())
+		}()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64(), nil
+	}
+
+	clientCerts, err := NewReloader(fp("client.pem"), fp("client.key"), fp("ca.pem"))
+	require.NoError(t, err)
+
+	t.Run("client with certificate", func(t *testing.T) {
+		serial, err := serverSerial(clientCerts.ClientConfig())
+		require.NoError(t, err)
+		assert.Equal(t, int64(10), serial)
+	})
+
+	t.Run("client without certificate", func(t *testing.T) {
+		pinned, err := NewReloader("", "", fp("ca.pem"))
+		require.NoError(t, err)
+		_, err = serverSerial(pinned.ClientConfig())
+		assert.Error(t, err)
+	})
+
+	t.Run("server certificate reload", func(t *testing.T) {
+		ca.issue(t, 11, x509.ExtKeyUsageServerAuth, fp("server.pem"), fp("server.key"))
+		require.NoError(t, serverCerts.Reload())
+		serial, err := serverSerial(clientCerts.ClientConfig())
+		require.NoError(t, err)
+		assert.Equal(t, int64(11), serial)
+	})
+
+	t.Run("failed reload keeps certificate", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(fp("server.pem"), []byte("garbage"), 0600))
+		require.Error(t, serverCerts.Reload())
+		serial, err := serverSerial(clientCerts.ClientConfig())
+		require.NoError(t, err)
+		assert.Equal(t, int64(11), serial)
+	})
+}